@@ -20,14 +20,14 @@ import (
 )
 
 const usage = "USAGE: trie_bench [-n=<num kv pairs>] [-blake2b=20|32]" +
-	"[-arity=2|16|26] [-optkey] [-valuethr=<terminal optimization threshold>]" +
+	"[-arity=2|4|8|16|32|64|128|256] [-optkey] [-valuethr=<terminal optimization threshold>]" +
 	"[maxkey=<max key size>] [maxvalue=<max value size>]" +
-	"<gen|mkdbbadger|mkdbmem|scandbbadger|mkdbbadgernotrie> <name>\n"
+	"<gen|mkdbbadger|mkdbmem|scandbbadger|migratedbbadger|mkdbbadgernotrie> <name>\n"
 
 var (
 	model    *trie_blake2b.CommitmentModel
 	hashsize = flag.Int("blake2b", 20, "must be 20 or 32")
-	arityPar = flag.Int("arity", 16, "must be 2, 16 or 256")
+	arityPar = flag.Int("arity", 16, "must be a power of 2 up to 256")
 	num      = flag.Int("n", 1000, "number of k/v pairs")
 	hashkv   = flag.Bool("hashkv", false, "hash keys and values")
 	optkey   = flag.Bool("optkey", false, "optimize hash commitments")
@@ -50,21 +50,14 @@ func main() {
 	cmd = tail[0]
 
 	switch cmd {
-	case "gen", "mkdbbadger", "mkdbmem", "scandbbadger", "mkdbbadgernotrie":
+	case "gen", "mkdbbadger", "mkdbmem", "scandbbadger", "migratedbbadger", "mkdbbadgernotrie":
 	default:
 		fmt.Printf(usage)
 		os.Exit(1)
 	}
 	name = tail[1]
-	var arity trie.PathArity
-	switch *arityPar {
-	case 2:
-		arity = trie.PathArity2
-	case 16:
-		arity = trie.PathArity16
-	case 256:
-		arity = trie.PathArity256
-	default:
+	arity := trie.PathArity(*arityPar - 1)
+	if *arityPar < 2 || *arityPar > 256 || !arity.IsValid() {
 		fmt.Printf(usage)
 		os.Exit(1)
 	}
@@ -108,6 +101,9 @@ func main() {
 	case "scandbbadger":
 		scandbbadger()
 
+	case "migratedbbadger":
+		migratedbbadger()
+
 	default:
 		fmt.Printf(usage)
 		os.Exit(1)
@@ -276,6 +272,42 @@ func scandbbadger() {
 	})
 }
 
+// migratedbbadger rewrites trie nodes of the database to the current node format
+func migratedbbadger() {
+	if _, err := os.Stat(dbdir); os.IsNotExist(err) {
+		fmt.Printf("directory %s does not exist\n", dbdir)
+		os.Exit(1)
+	}
+	fmt.Printf("opening database '%s'\n", dbdir)
+
+	db, err := badger.CreateDB(dbdir)
+	must(err)
+	defer func() { _ = db.Close() }()
+
+	kvs := badger.New(db)
+	trieKVS := hive_adaptor.NewHiveKVStoreAdaptor(kvs, triePrefix)
+	valueKVS := hive_adaptor.NewHiveKVStoreAdaptor(kvs, valueStorePrefix)
+
+	// rewritten nodes are staged in the temporary database, not in memory
+	scratchDir, err := os.MkdirTemp("", "trie_bench_migrate_*")
+	must(err)
+	defer func() { _ = os.RemoveAll(scratchDir) }()
+	scratchDB, err := badger.CreateDB(scratchDir)
+	must(err)
+	defer func() { _ = scratchDB.Close() }()
+	scratchKVS := hive_adaptor.NewHiveKVStoreAdaptor(badger.New(scratchDB), nil)
+
+	fmt.Printf("TRIE: size of nodes before migration: %d bytes\n", trie.ByteSize(trieKVS))
+	for from := trie.NodeFormatLegacy; from < trie.CurrentNodeFormat; from++ {
+		tm := newTimer()
+		stats, err := trie.MigrateNodeFormat(model, trieKVS, valueKVS, scratchKVS, from)
+		must(err)
+		fmt.Printf("migrated %d of %d nodes from %s to %s in %v. Root commitment: %s\n",
+			stats.NumMigrated, stats.NumNodes, from, from+1, tm.Duration(), stats.Root)
+	}
+	fmt.Printf("TRIE: size of nodes after migration: %d bytes\n", trie.ByteSize(trieKVS))
+}
+
 type timer time.Time
 
 var (
@@ -32,25 +32,41 @@ func makeKey(prefix, k []byte) []byte {
 	return trie.Concat(prefix, k)
 }
 
+// HiveKVStoreAdaptor implements trie.KVReaderWithErr, so the trie passes I/O errors to the caller of *Err functions
+var _ trie.KVReaderWithErr = &HiveKVStoreAdaptor{}
+
 func (kvs *HiveKVStoreAdaptor) Get(key []byte) []byte {
+	v, err := kvs.GetErr(key)
+	mustNoErr(err)
+	return v
+}
+
+func (kvs *HiveKVStoreAdaptor) GetErr(key []byte) ([]byte, error) {
 	v, err := kvs.kvs.Get(makeKey(kvs.prefix, key))
 	if errors.Is(err, kvstore.ErrKeyNotFound) {
-		return nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
-	mustNoErr(err)
 	if len(v) == 0 {
-		return nil
+		return nil, nil
 	}
-	return v
+	return v, nil
 }
 
 func (kvs *HiveKVStoreAdaptor) Has(key []byte) bool {
-	v, err := kvs.kvs.Get(makeKey(kvs.prefix, key))
-	if errors.Is(err, kvstore.ErrKeyNotFound) || len(v) == 0 {
-		return false
-	}
+	ret, err := kvs.HasErr(key)
 	mustNoErr(err)
-	return true
+	return ret
+}
+
+func (kvs *HiveKVStoreAdaptor) HasErr(key []byte) (bool, error) {
+	v, err := kvs.GetErr(key)
+	if err != nil {
+		return false, err
+	}
+	return len(v) > 0, nil
 }
 
 func (kvs *HiveKVStoreAdaptor) Set(key, value []byte) {
@@ -100,15 +116,55 @@ func NewHiveBatchedUpdater(kvs kvstore.KVStore, model trie.CommitmentModel, trie
 
 // Update adds key values store both to the batch and to the trie
 func (a *HiveBatchedUpdater) Update(key []byte, value []byte) {
+	mustNoErr(a.UpdateErr(key, value))
+}
+
+// UpdateErr same as Update, but returns error instead of panicking
+func (a *HiveBatchedUpdater) UpdateErr(key []byte, value []byte) error {
+	if err := a.ensureBatch(); err != nil {
+		return err
+	}
+	if err := a.wValue.SetErr(key, value); err != nil {
+		return err
+	}
+	return a.trie.UpdateErr(key, value)
+}
+
+// DeletePrefix deletes all keys with the prefix both from the trie and from the value store
+func (a *HiveBatchedUpdater) DeletePrefix(prefix []byte) {
+	mustNoErr(a.DeletePrefixErr(prefix))
+}
+
+// DeletePrefixErr same as DeletePrefix, but returns error instead of panicking
+func (a *HiveBatchedUpdater) DeletePrefixErr(prefix []byte) error {
+	if err := a.ensureBatch(); err != nil {
+		return err
+	}
+	keys, err := a.trie.DeletePrefixErr(prefix)
+	if err != nil {
+		return err
+	}
+	// the trie knows all deleted keys, including the ones updated in the current batch
+	for _, k := range keys {
+		if err = a.wValue.SetErr(k, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureBatch starts a new batch if there is none
+func (a *HiveBatchedUpdater) ensureBatch() error {
+	if a.batch != nil {
+		return nil
+	}
 	var err error
-	if a.batch == nil {
-		a.batch, err = a.kvs.Batched()
-		mustNoErr(err)
-		a.wTrie = newBatchWriter(a.batch, a.triePrefix)
-		a.wValue = newBatchWriter(a.batch, a.valueStorePrefix)
+	if a.batch, err = a.kvs.Batched(); err != nil {
+		return err
 	}
-	a.wValue.Set(key, value)
-	a.trie.Update(key, value)
+	a.wTrie = newBatchWriter(a.batch, a.triePrefix)
+	a.wValue = newBatchWriter(a.batch, a.valueStorePrefix)
+	return nil
 }
 
 // batchWriter implements KVWriter interface over the hive.go batch
@@ -125,13 +181,14 @@ func newBatchWriter(b kvstore.BatchedMutations, prefix []byte) batchWriter {
 }
 
 func (b batchWriter) Set(key, value []byte) {
-	var err error
+	mustNoErr(b.SetErr(key, value))
+}
+
+func (b batchWriter) SetErr(key, value []byte) error {
 	if len(value) > 0 {
-		err = b.batch.Set(makeKey(b.prefix, key), value)
-	} else {
-		err = b.batch.Delete(makeKey(b.prefix, key))
+		return b.batch.Set(makeKey(b.prefix, key), value)
 	}
-	mustNoErr(err)
+	return b.batch.Delete(makeKey(b.prefix, key))
 }
 
 // Commit commits the tries cache and persist mutations to the batch. Then it commits the whole batch
@@ -140,8 +197,12 @@ func (a *HiveBatchedUpdater) Commit() error {
 	if a.batch == nil {
 		return nil
 	}
-	a.trie.Commit()
-	a.trie.PersistMutations(a.wTrie)
+	if err := a.trie.CommitErr(); err != nil {
+		return err
+	}
+	if _, err := a.trie.PersistMutationsErr(a.wTrie); err != nil {
+		return err
+	}
 	if err := a.batch.Commit(); err != nil {
 		return err
 	}
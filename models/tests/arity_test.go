@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_blake2b/trie_blake2b_verify"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllArities(t *testing.T) {
+	runTest := func(m *trie_blake2b.CommitmentModel, data []string) {
+		t.Run("arity"+tn(m), func(t *testing.T) {
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(m, store, nil)
+			for _, d := range data {
+				tr.Update([]byte(d), []byte(d+"++"))
+			}
+			tr.Commit()
+			tr.PersistMutations(store)
+			root := trie.RootCommitment(tr).Bytes()
+
+			// nodes read back from the store give the same root
+			trBack := trie.New(m, store, nil)
+			for _, d := range data[:len(data)/2] {
+				trBack.Delete([]byte(d))
+			}
+			for _, d := range data[:len(data)/2] {
+				trBack.Update([]byte(d), []byte(d+"++"))
+			}
+			trBack.Commit()
+			require.EqualValues(t, root, trie.RootCommitment(trBack).Bytes())
+
+			for _, d := range data {
+				p := m.Proof([]byte(d), tr)
+				require.NoError(t, trie_blake2b_verify.ValidateWithValue(p, root, []byte(d+"++")))
+				pBack, err := trie_blake2b.ProofFromBytes(p.Bytes())
+				require.NoError(t, err)
+				require.NoError(t, trie_blake2b_verify.ValidateWithValue(pBack, root, []byte(d+"++")))
+
+				p = m.Proof([]byte(d+"absent"), tr)
+				require.NoError(t, trie_blake2b_verify.Validate(p, root))
+				require.True(t, trie_blake2b_verify.IsProofOfAbsence(p))
+			}
+
+			// iteration returns the original packed keys in order
+			keys := make(map[string]bool)
+			for _, d := range data {
+				keys[d] = true
+			}
+			var prev []byte
+			trie.NewTrieReader(m, store, nil).Iterate(func(k []byte, _ trie.TCommitment) bool {
+				require.True(t, keys[string(k)])
+				require.True(t, prev == nil || string(prev) < string(k))
+				delete(keys, string(k))
+				prev = k
+				return true
+			})
+			require.EqualValues(t, 0, len(keys))
+		})
+	}
+	data := genRnd3()
+	for _, arity := range trie.AllPathArity {
+		runTest(trie_blake2b.New(arity, trie_blake2b.HashSize160), data)
+		runTest(trie_blake2b.New(arity, trie_blake2b.HashSize256), data)
+	}
+}
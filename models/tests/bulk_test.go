@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTrie(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string, optimizeKeyCommitments bool) {
+		value := func(i int) []byte {
+			switch {
+			case optimizeKeyCommitments && i%2 == 0:
+				return []byte(data[i])
+			case i%7 == 0:
+				// long values are not stored in nodes
+				return bytes.Repeat([]byte(data[i]+"++"), 20)
+			}
+			return []byte(data[i] + "++")
+		}
+		t.Run("sorted"+tn(m), func(t *testing.T) {
+			store1 := trie.NewInMemoryKVStore()
+			tr := trie.New(m, store1, nil, optimizeKeyCommitments)
+			pairs := make(map[string][]byte)
+			for i := range data {
+				tr.Update([]byte(data[i]), value(i))
+				pairs[data[i]] = value(i)
+			}
+			tr.Commit()
+			tr.PersistMutations(store1)
+
+			keys := make([]string, 0, len(pairs))
+			for k := range pairs {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var buf bytes.Buffer
+			w := trie.NewBinaryStreamWriter(&buf)
+			for _, k := range keys {
+				require.NoError(t, w.Write([]byte(k), pairs[k]))
+			}
+			store2 := trie.NewInMemoryKVStore()
+			root, err := trie.BuildTrie(m, trie.NewBinaryStreamIterator(&buf), store2, optimizeKeyCommitments)
+			require.NoError(t, err)
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr), root))
+			requireEqualStores(t, store1, store2)
+
+			rdr := trie.NewTrieReader(m, store2, nil)
+			require.True(t, m.EqualCommitments(root, trie.RootCommitment(rdr)))
+		})
+		t.Run("unsorted"+tn(m), func(t *testing.T) {
+			store1 := trie.NewInMemoryKVStore()
+			tr := trie.New(m, store1, nil, optimizeKeyCommitments)
+			var buf bytes.Buffer
+			w := trie.NewBinaryStreamWriter(&buf)
+			rnd := rand.New(rand.NewSource(int64(len(data))))
+			for _, i := range rnd.Perm(len(data)) {
+				tr.Update([]byte(data[i]), value(i))
+				require.NoError(t, w.Write([]byte(data[i]), value(i)))
+			}
+			// updates and deletions of already written keys
+			for i := 0; i < len(data)/3; i++ {
+				v := []byte("updated")
+				if i%2 == 0 {
+					v = nil
+				}
+				tr.Update([]byte(data[i]), v)
+				require.NoError(t, w.Write([]byte(data[i]), v))
+			}
+			tr.Commit()
+			tr.PersistMutations(store1)
+
+			sorted, err := trie.SortKVStream(trie.NewBinaryStreamIterator(&buf), t.TempDir(), 1000)
+			require.NoError(t, err)
+			defer sorted.Close()
+
+			var prev []byte
+			err = sorted.Iterate(func(k, _ []byte) bool {
+				require.True(t, prev == nil || bytes.Compare(prev, k) < 0)
+				prev = k
+				return true
+			})
+			require.NoError(t, err)
+
+			store2 := trie.NewInMemoryKVStore()
+			root, err := trie.BuildTrie(m, sorted, store2, optimizeKeyCommitments)
+			require.NoError(t, err)
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr), root))
+			requireEqualStores(t, store1, store2)
+		})
+	}
+	data := genRnd4()[:3000]
+	for _, o := range []bool{false, true} {
+		runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256), data, o)
+		runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256), data, o)
+		runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256), data, o)
+		runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data, o)
+		runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), data, o)
+		runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160), data, o)
+		runTest(trie_kzg_bn256.New(), data[:50], o)
+	}
+	t.Run("sort runs larger than read buffer", func(t *testing.T) {
+		// pairs of runs cross the boundary of the buffer of the reader
+		rnd := rand.New(rand.NewSource(1))
+		var buf bytes.Buffer
+		w := trie.NewBinaryStreamWriter(&buf)
+		pairs := make(map[string][]byte)
+		for _, i := range rnd.Perm(len(data)) {
+			v := bytes.Repeat([]byte{byte(i)}, 1+rnd.Intn(3000))
+			require.NoError(t, w.Write([]byte(data[i]), v))
+			pairs[data[i]] = v
+		}
+		sorted, err := trie.SortKVStream(trie.NewBinaryStreamIterator(&buf), t.TempDir(), 64*1024)
+		require.NoError(t, err)
+		defer sorted.Close()
+
+		keys := make([]string, 0, len(pairs))
+		for k := range pairs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		i := 0
+		err = sorted.Iterate(func(k, v []byte) bool {
+			require.EqualValues(t, keys[i], string(k))
+			require.EqualValues(t, pairs[keys[i]], v)
+			i++
+			return true
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, len(keys), i)
+	})
+	t.Run("empty and unsorted", func(t *testing.T) {
+		m := trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256)
+		store := trie.NewInMemoryKVStore()
+		root, err := trie.BuildTrie(m, trie.NewBinaryStreamIterator(&bytes.Buffer{}), store)
+		require.NoError(t, err)
+		require.Nil(t, root)
+		require.EqualValues(t, 0, countKeys(store))
+
+		var buf bytes.Buffer
+		w := trie.NewBinaryStreamWriter(&buf)
+		require.NoError(t, w.Write([]byte("b"), []byte("1")))
+		require.NoError(t, w.Write([]byte("a"), []byte("2")))
+		_, err = trie.BuildTrie(m, trie.NewBinaryStreamIterator(&buf), store)
+		require.ErrorIs(t, err, trie.ErrUnsortedStream)
+	})
+}
+
+func requireEqualStores(t *testing.T, s1, s2 trie.KVStore) {
+	require.EqualValues(t, countKeys(s1), countKeys(s2))
+	s1.Iterate(func(k, v []byte) bool {
+		require.EqualValues(t, v, s2.Get(k))
+		return true
+	})
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string, terminalsInValueStore bool) {
+		arity := m.PathArity()
+		// makeStores returns consistent trie store and value store
+		makeStores := func() (trie.KVStore, trie.KVStore, trie.VCommitment) {
+			trieStore := trie.NewInMemoryKVStore()
+			valueStore := trie.NewInMemoryKVStore()
+			tr := trie.New(m, trieStore, valueStore)
+			for _, d := range data {
+				tr.Update([]byte(d), []byte(d+"++"))
+				valueStore.Set([]byte(d), []byte(d+"++"))
+			}
+			tr.Commit()
+			tr.PersistMutations(trieStore)
+			return trieStore, valueStore, trie.RootCommitment(tr)
+		}
+		// findNode returns encoded key and node data of the first node which satisfies the condition
+		findNode := func(trieStore, valueStore trie.KVStore, cond func(unpackedKey []byte, n *trie.NodeData) bool) ([]byte, *trie.NodeData) {
+			var retKey []byte
+			var retNode *trie.NodeData
+			trieStore.Iterate(func(k, v []byte) bool {
+				unpackedKey, err := trie.DecodeToUnpackedBytes(k, arity)
+				require.NoError(t, err)
+				n, err := trie.NodeDataFromBytes(m, v, unpackedKey, arity, valueStore)
+				require.NoError(t, err)
+				if cond(unpackedKey, n) {
+					retKey, retNode = k, n
+					return false
+				}
+				return true
+			})
+			require.NotNil(t, retKey)
+			return retKey, retNode
+		}
+		isLeaf := func(unpackedKey []byte, n *trie.NodeData) bool {
+			return len(unpackedKey) > 0 && len(n.ChildCommitments) == 0
+		}
+		nodeBytes := func(n *trie.NodeData) []byte {
+			var buf bytes.Buffer
+			require.NoError(t, n.Write(&buf, arity, false, false))
+			return buf.Bytes()
+		}
+		check := func(trieStore, valueStore trie.KVStore) *trie.CheckReport {
+			return trie.Check(trie.NewTrieReader(m, trieStore, valueStore), trieStore)
+		}
+
+		t.Run("consistent"+tn(m), func(t *testing.T) {
+			trieStore, valueStore, root := makeStores()
+			report := check(trieStore, valueStore)
+			require.True(t, report.IsConsistent(), report.String())
+			require.True(t, m.EqualCommitments(root, report.Root))
+			keys := make(map[string]struct{})
+			for _, d := range data {
+				keys[d] = struct{}{}
+			}
+			require.EqualValues(t, len(keys), report.NumTerminals)
+			require.EqualValues(t, trie.NumEntries(trieStore), report.NumNodes)
+			require.EqualValues(t, report.NumNodes, report.NumStoredNodes)
+
+			report = trie.Check(trie.NewTrieReader(m, trie.NewInMemoryKVStore(), nil), trie.NewInMemoryKVStore())
+			require.True(t, report.IsConsistent())
+			require.Nil(t, report.Root)
+		})
+		t.Run("orphaned below reachable node"+tn(m), func(t *testing.T) {
+			trieStore, valueStore, _ := makeStores()
+			leafKey, leaf := findNode(trieStore, valueStore, isLeaf)
+			unpackedKey, err := trie.DecodeToUnpackedBytes(leafKey, arity)
+			require.NoError(t, err)
+			// the leaf has no children, so the node at the key of its child is not reachable
+			orphanKey, err := trie.EncodeUnpackedBytes(trie.Concat(unpackedKey, leaf.PathFragment, byte(0)), arity)
+			require.NoError(t, err)
+			trieStore.Set(orphanKey, nodeBytes(leaf))
+
+			report := check(trieStore, valueStore)
+			require.EqualValues(t, 1, len(report.Issues), report.String())
+			require.EqualValues(t, 1, report.NumIssues(trie.CheckOrphanedNode), report.String())
+		})
+		t.Run("inconsistent"+tn(m), func(t *testing.T) {
+			trieStore, valueStore, root := makeStores()
+
+			// unmerged node: the removed child with its subtree becomes orphaned
+			unmergedKey, unmerged := findNode(trieStore, valueStore, func(k []byte, n *trie.NodeData) bool {
+				return len(k) > 0 && n.Terminal == nil && len(n.ChildCommitments) == 2
+			})
+			var removed []byte
+			for i := range unmerged.ChildCommitments {
+				delete(unmerged.ChildCommitments, i)
+				unpackedKey, err := trie.DecodeToUnpackedBytes(unmergedKey, arity)
+				require.NoError(t, err)
+				removed = trie.Concat(unpackedKey, unmerged.PathFragment, i)
+				break
+			}
+			trieStore.Set(unmergedKey, nodeBytes(unmerged))
+
+			reachableLeaf := func(k []byte, n *trie.NodeData) bool {
+				return isLeaf(k, n) && !bytes.HasPrefix(k, removed)
+			}
+			// corrupted node
+			corruptedKey, _ := findNode(trieStore, valueStore, reachableLeaf)
+			trieStore.Set(corruptedKey, []byte{0xff, 0xff})
+
+			// missing node
+			missingKey, leaf := findNode(trieStore, valueStore, reachableLeaf)
+			trieStore.Set(missingKey, nil)
+
+			// orphaned node
+			orphanKey, err := trie.EncodeUnpackedBytes(trie.UnpackBytes([]byte{0xff, 0xfe, 0xfd}, arity), arity)
+			require.NoError(t, err)
+			trieStore.Set(orphanKey, nodeBytes(leaf))
+
+			report := check(trieStore, valueStore)
+			t.Logf("%s", report)
+			require.False(t, report.IsConsistent())
+			require.EqualValues(t, 1, report.NumIssues(trie.CheckCorruptedNode))
+			require.EqualValues(t, 1, report.NumIssues(trie.CheckMissingNode))
+			require.EqualValues(t, 1, report.NumIssues(trie.CheckUnmergedNode))
+			require.True(t, report.NumIssues(trie.CheckOrphanedNode) >= 2)
+			require.True(t, report.NumIssues(trie.CheckWrongCommitment) >= 1)
+
+			// the trie store is not changed if the repair fails
+			numStored := trie.NumEntries(trieStore)
+			_, err = trie.RepairTrie(m, trie.NewInMemoryKVStore(), trieStore, filepath.Join(t.TempDir(), "missing"))
+			require.Error(t, err)
+			require.EqualValues(t, numStored, trie.NumEntries(trieStore))
+
+			tmpDir := t.TempDir()
+			rootBack, err := trie.RepairTrie(m, valueStore, trieStore, tmpDir)
+			require.NoError(t, err)
+			require.True(t, m.EqualCommitments(root, rootBack))
+			report = check(trieStore, valueStore)
+			require.True(t, report.IsConsistent(), report.String())
+			require.True(t, m.EqualCommitments(root, report.Root))
+			files, err := os.ReadDir(tmpDir)
+			require.NoError(t, err)
+			require.EqualValues(t, 0, len(files))
+		})
+		t.Run("empty node and wrong commitment"+tn(m), func(t *testing.T) {
+			trieStore, valueStore, _ := makeStores()
+			leafKey, _ := findNode(trieStore, valueStore, isLeaf)
+			// the node can't be written by Write
+			trieStore.Set(leafKey, []byte{0x80 | byte(trie.CurrentNodeFormat), 0x00})
+			report := check(trieStore, valueStore)
+			require.EqualValues(t, 1, report.NumIssues(trie.CheckEmptyNode), report.String())
+			require.EqualValues(t, 1, report.NumIssues(trie.CheckWrongCommitment), report.String())
+			require.EqualValues(t, 2, len(report.Issues), report.String())
+		})
+		t.Run("missing value"+tn(m), func(t *testing.T) {
+			trieStore, valueStore, _ := makeStores()
+			shortest := data[0]
+			for _, d := range data {
+				if len(d) > 0 && (len(shortest) == 0 || len(d) < len(shortest)) {
+					shortest = d
+				}
+			}
+			valueStore.Set([]byte(shortest), nil)
+			report := check(trieStore, valueStore)
+			if !terminalsInValueStore {
+				require.True(t, report.IsConsistent(), report.String())
+				return
+			}
+			// the node can't be read, so its subtree is unreachable, but not orphaned
+			require.EqualValues(t, 1, report.NumIssues(trie.CheckMissingValue), report.String())
+			require.EqualValues(t, 0, report.NumIssues(trie.CheckOrphanedNode), report.String())
+			require.True(t, report.NumIssues(trie.CheckUnreachableNode) > 0, report.String())
+			require.EqualValues(t, 1+report.NumIssues(trie.CheckUnreachableNode), len(report.Issues), report.String())
+			var unreadable []byte
+			for _, i := range report.Issues {
+				switch i.Kind {
+				case trie.CheckMissingValue:
+					unreadable = i.Key
+				case trie.CheckUnreachableNode:
+					require.True(t, bytes.HasPrefix(i.Key, unreadable), report.String())
+				}
+			}
+		})
+	}
+	data := genRnd4()[:2000]
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data, false)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160, 10), data, true)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256, 10), data, true)
+	runTest(trie_kzg_bn256.New(), data[:100], false)
+}
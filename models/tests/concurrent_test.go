@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelCommit(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string) {
+		t.Run("parallel commit"+tn(m), func(t *testing.T) {
+			store1 := trie.NewInMemoryKVStore()
+			store2 := trie.NewInMemoryKVStore()
+			tr1 := trie.New(m, store1, nil)
+			tr2 := trie.New(m, store2, nil)
+			tr2.SetCommitWorkers(8)
+			for i, d := range data {
+				tr1.Update([]byte(d), []byte(d+"++"))
+				tr2.Update([]byte(d), []byte(d+"++"))
+				if i%(len(data)/5) == 0 {
+					tr1.Commit()
+					tr2.Commit()
+					require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+					tr2.PersistMutations(store2)
+					tr2.ClearCache()
+				}
+			}
+			for _, d := range data[:len(data)/2] {
+				tr1.Delete([]byte(d))
+				tr2.Delete([]byte(d))
+			}
+			tr1.Commit()
+			tr2.Commit()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+		})
+	}
+	data := genRnd4()[:5000]
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160), data)
+	runTest(trie_kzg_bn256.New(), data[:50])
+}
+
+func TestCommittedState(t *testing.T) {
+	m := trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256)
+	store := trie.NewInMemoryKVStore()
+	tr := trie.New(m, store, nil)
+	tr.SetCommitWorkers(4)
+	require.Nil(t, trie.RootCommitment(tr.Committed()))
+
+	tr.Update([]byte("a"), []byte("1"))
+	require.Nil(t, trie.RootCommitment(tr.Committed()))
+	tr.Commit()
+	committed := trie.RootCommitment(tr.Committed())
+	require.True(t, m.EqualCommitments(trie.RootCommitment(tr), committed))
+
+	// uncommitted mutations are not visible
+	tr.Update([]byte("b"), []byte("2"))
+	tr.Delete([]byte("a"))
+	require.True(t, m.EqualCommitments(committed, trie.RootCommitment(tr.Committed())))
+
+	const numCommits = 20
+	var mutex sync.Mutex
+	roots := make(map[string]struct{})
+	roots[committed.String()] = struct{}{}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				state := tr.Committed()
+				c := trie.RootCommitment(state)
+				trie.GetProofGeneric(state, trie.UnpackBytes([]byte("0-1"), m.PathArity()))
+				mutex.Lock()
+				_, ok := roots[c.String()]
+				mutex.Unlock()
+				if !ok {
+					t.Errorf("unexpected committed root %s", c)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < numCommits; i++ {
+		for j := 0; j < 100; j++ {
+			k := fmt.Sprintf("%d-%d", i, j)
+			tr.Update([]byte(k), []byte(k))
+		}
+		// the root is known before it becomes visible
+		cl := tr.Clone()
+		cl.Commit()
+		mutex.Lock()
+		roots[trie.RootCommitment(cl).String()] = struct{}{}
+		mutex.Unlock()
+
+		tr.Commit()
+	}
+	close(stop)
+	wg.Wait()
+	require.True(t, m.EqualCommitments(trie.RootCommitment(tr), trie.RootCommitment(tr.Committed())))
+}
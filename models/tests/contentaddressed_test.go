@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentAddressedStore(t *testing.T) {
+	const numBatches = 5
+	runTest := func(m trie.CommitmentModel) {
+		t.Run("content addressed"+tn(m), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			store := trie.NewInMemoryKVStore()
+			cas := trie.NewContentAddressedStore(m, store)
+			require.Nil(t, cas.Root())
+
+			// the same trie in the default layout
+			storeDefault := trie.NewInMemoryKVStore()
+			trDefault := trie.New(m, storeDefault, nil)
+
+			tr := trie.New(m, cas.LatestTrieStore(), nil)
+			state := make(map[string]string)
+			roots := make([]trie.VCommitment, 0)
+			states := make([][]string, 0)
+			for b := 0; b < numBatches; b++ {
+				for i := 0; i < 300; i++ {
+					k := fmt.Sprintf("%d", rnd.Intn(1000))
+					v := fmt.Sprintf("%s-%d", k, b)
+					if rnd.Intn(3) == 0 {
+						v = ""
+						delete(state, k)
+					} else {
+						state[k] = v
+					}
+					tr.Update([]byte(k), []byte(v))
+					trDefault.Update([]byte(k), []byte(v))
+				}
+				tr.Commit()
+				trDefault.Commit()
+				trDefault.PersistMutations(storeDefault)
+				trDefault.ClearCache()
+
+				root := cas.Persist(tr)
+				require.True(t, m.EqualCommitments(trie.RootCommitment(trDefault), root))
+				require.True(t, m.EqualCommitments(cas.Root(), root))
+				roots = append(roots, root)
+				states = append(states, sortedState(state))
+			}
+			// all states are readable
+			for b := range roots {
+				rdr := trie.NewTrieReader(m, cas.TrieStore(roots[b]), nil)
+				require.True(t, m.EqualCommitments(trie.RootCommitment(rdr), roots[b]))
+				keys := make([]string, 0)
+				rdr.Iterate(func(k []byte, _ trie.TCommitment) bool {
+					keys = append(keys, string(k))
+					return true
+				})
+				require.EqualValues(t, states[b], keys)
+			}
+			// only the changed path, the node split by the new key and the root index entry are stored for the new root
+			sizeBefore := trie.NumEntries(store)
+			tr.Update([]byte("new key"), []byte("new value"))
+			tr.Commit()
+			cas.Persist(tr)
+			proofGeneric := trie.GetProofGeneric(trie.NewTrieReader(m, cas.LatestTrieStore(), nil), trie.UnpackBytes([]byte("new key"), m.PathArity()))
+			require.True(t, trie.NumEntries(store)-sizeBefore <= len(proofGeneric.Path)+2)
+
+			// tampered node is detected
+			rdr := trie.NewTrieReader(m, cas.LatestTrieStore(), nil)
+			rootKey := trie.Concat(byte(1), store.Get([]byte{0})[:32])
+			rootData := store.Get(rootKey)
+			store.Set(rootKey, append(rootData[:len(rootData)-1], rootData[len(rootData)-1]^0x01))
+			_, _, err := rdr.GetNodeErr(nil)
+			require.True(t, errors.Is(err, trie.ErrCorruptedNode), "%v", err)
+
+			store.Set(rootKey, nil)
+			_, _, err = rdr.GetNodeErr(nil)
+			require.True(t, errors.Is(err, trie.ErrMissingNode), "%v", err)
+		})
+	}
+	for _, arity := range trie.AllPathArity {
+		runTest(trie_blake2b.New(arity, trie_blake2b.HashSize256))
+		runTest(trie_blake2b.New(arity, trie_blake2b.HashSize160))
+	}
+
+	t.Run("content addressed equal commitments", func(t *testing.T) {
+		m := trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256)
+		cas := trie.NewContentAddressedStore(m, trie.NewInMemoryKVStore())
+		tr := trie.New(m, cas.LatestTrieStore(), nil)
+		// nodes with path fragments "\x00" and "\x00\x00" and the same terminal have equal commitments
+		tr.Update([]byte("a\x00"), []byte("v"))
+		tr.Update([]byte("b\x00\x00"), []byte("v"))
+		tr.Commit()
+		root := cas.Persist(tr)
+
+		// the trie with swapped path fragments has the same root commitment
+		tr.Delete([]byte("a\x00"))
+		tr.Delete([]byte("b\x00\x00"))
+		tr.Update([]byte("a\x00\x00"), []byte("v"))
+		tr.Update([]byte("b\x00"), []byte("v"))
+		tr.Commit()
+		require.True(t, m.EqualCommitments(root, cas.Persist(tr)))
+
+		for _, rdr := range []*trie.TrieReader{
+			trie.NewTrieReader(m, cas.LatestTrieStore(), nil),
+			trie.NewTrieReader(m, cas.TrieStore(root), nil),
+		} {
+			keys := make([]string, 0)
+			rdr.Iterate(func(k []byte, _ trie.TCommitment) bool {
+				keys = append(keys, string(k))
+				return true
+			})
+			require.EqualValues(t, []string{"a\x00\x00", "b\x00"}, keys)
+		}
+	})
+}
+
+// run with -race: the committed state and the trie read nodes through the same reader of the latest root
+func TestContentAddressedConcurrent(t *testing.T) {
+	m := trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256)
+	cas := trie.NewContentAddressedStore(m, trie.NewInMemoryKVStore())
+	tr := trie.New(m, cas.LatestTrieStore(), nil)
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%d", i)
+		tr.Update([]byte(k), []byte(k+"++"))
+	}
+	tr.Commit()
+	root := cas.Persist(tr)
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := r; i < 1000; i += 4 {
+				state := tr.Committed()
+				p := trie.GetProofGeneric(state, []byte(fmt.Sprintf("%d", i)))
+				if p.Ending != trie.EndingTerminal {
+					t.Errorf("key %d is not in the committed state", i)
+					return
+				}
+			}
+		}(r)
+	}
+	for i := 0; i < 1000; i += 3 {
+		k := fmt.Sprintf("%d", i)
+		tr.Update([]byte(k), []byte(k+"--"))
+	}
+	wg.Wait()
+	require.True(t, m.EqualCommitments(root, trie.RootCommitment(tr.Committed())))
+}
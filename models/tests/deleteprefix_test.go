@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/iotaledger/hive.go/core/kvstore/mapdb"
+	"github.com/iotaledger/trie.go/hive_adaptor"
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func countKeys(store trie.KVIterator) int {
+	ret := 0
+	store.Iterate(func(_ []byte, _ []byte) bool {
+		ret++
+		return true
+	})
+	return ret
+}
+
+func TestDeletePrefix(t *testing.T) {
+	runTest := func(m trie.CommitmentModel) {
+		keys, _ := gen2different(5000)
+		sort.Strings(keys)
+		// reference trie without keys with the prefix
+		reference := func(prefix string) (trie.VCommitment, trie.KVStore, []string) {
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(m, store, nil)
+			deleted := make([]string, 0)
+			for _, k := range keys {
+				if strings.HasPrefix(k, prefix) {
+					deleted = append(deleted, k)
+					continue
+				}
+				tr.UpdateStr(k, k+"++")
+			}
+			tr.Commit()
+			tr.PersistMutations(store)
+			return trie.RootCommitment(tr), store, deleted
+		}
+		for _, prefix := range []string{"", "a", "ab", keys[len(keys)/2][:2], keys[len(keys)/3], "\xff"} {
+			expectedRoot, expectedStore, expectedDeleted := reference(prefix)
+			for _, persisted := range []bool{false, true} {
+				t.Run("delete prefix '"+prefix+"'"+tn(m), func(t *testing.T) {
+					store := trie.NewInMemoryKVStore()
+					tr := trie.New(m, store, nil)
+					for _, k := range keys {
+						tr.UpdateStr(k, k+"++")
+					}
+					if persisted {
+						tr.Commit()
+						tr.PersistMutations(store)
+						tr.ClearCache()
+					}
+					deleted := tr.DeletePrefix([]byte(prefix))
+					require.EqualValues(t, len(expectedDeleted), len(deleted))
+					for i := range deleted {
+						require.EqualValues(t, expectedDeleted[i], string(deleted[i]))
+					}
+					tr.Commit()
+					require.True(t, m.EqualCommitments(expectedRoot, trie.RootCommitment(tr)))
+					tr.PersistMutations(store)
+					tr.ClearCache()
+					require.True(t, m.EqualCommitments(expectedRoot, trie.RootCommitment(tr)))
+					// deleted nodes are removed from the store
+					require.EqualValues(t, countKeys(expectedStore), countKeys(store))
+
+					// deleted keys can be inserted again
+					for _, k := range expectedDeleted {
+						tr.UpdateStr(k, k+"++")
+					}
+					tr.Commit()
+					require.Nil(t, tr.DeletePrefix([]byte("\xff")))
+					fullRoot, _, _ := reference("\xff")
+					require.True(t, m.EqualCommitments(fullRoot, trie.RootCommitment(tr)))
+				})
+			}
+		}
+	}
+	for _, arity := range trie.AllPathArity {
+		runTest(trie_blake2b.New(arity, trie_blake2b.HashSize256))
+		runTest(trie_blake2b.New(arity, trie_blake2b.HashSize160))
+	}
+}
+
+func TestDeletePrefixUnaligned(t *testing.T) {
+	// unpacked prefix "a" ends with an incomplete element in 8-, 32-, 64- and 128-ary tries
+	keys := []string{"", "a", "a\x00", "a\x80", "a\xff", "a\xff\x01", "`\xff", "b", "b\x00"}
+	for _, arity := range trie.AllPathArity {
+		m := trie_blake2b.New(arity, trie_blake2b.HashSize256)
+		for _, prefix := range []string{"a", "a\x80", "b", "`"} {
+			t.Run("delete unaligned prefix '"+prefix+"'"+tn(m), func(t *testing.T) {
+				expected := trie.New(m, trie.NewInMemoryKVStore(), nil)
+				tr := trie.New(m, trie.NewInMemoryKVStore(), nil)
+				expectedDeleted := make([]string, 0)
+				for _, k := range keys {
+					tr.UpdateStr(k, k+"++")
+					if strings.HasPrefix(k, prefix) {
+						expectedDeleted = append(expectedDeleted, k)
+					} else {
+						expected.UpdateStr(k, k+"++")
+					}
+				}
+				tr.Commit()
+				expected.Commit()
+
+				iterated := make([]string, 0)
+				trie.IteratePrefix(tr, []byte(prefix), func(k []byte, _ trie.TCommitment) bool {
+					iterated = append(iterated, string(k))
+					return true
+				})
+				require.EqualValues(t, expectedDeleted, iterated)
+
+				deleted := tr.DeletePrefix([]byte(prefix))
+				require.EqualValues(t, len(expectedDeleted), len(deleted))
+				for i := range deleted {
+					require.EqualValues(t, expectedDeleted[i], string(deleted[i]))
+				}
+				tr.Commit()
+				require.True(t, m.EqualCommitments(trie.RootCommitment(expected), trie.RootCommitment(tr)))
+			})
+		}
+	}
+}
+
+func TestDeletePrefixHiveBatch(t *testing.T) {
+	for _, arity := range trie.AllPathArity {
+		m := trie_blake2b.New(arity, trie_blake2b.HashSize256)
+		t.Run("delete prefix hive batch"+tn(m), func(t *testing.T) {
+			triePrefix, valueStorePrefix := []byte{0}, []byte{1}
+			kvs := mapdb.NewMapDB()
+			updater, err := hive_adaptor.NewHiveBatchedUpdater(kvs, m, triePrefix, valueStorePrefix, false)
+			require.NoError(t, err)
+			for _, k := range []string{"a", "ab", "abc", "a\x00", "a\x80", "a\xff", "b", "bc"} {
+				updater.Update([]byte(k), []byte(k+"++"))
+			}
+			require.NoError(t, updater.Commit())
+
+			// the key updated in the same batch is deleted too
+			updater.Update([]byte("abd"), []byte("abd++"))
+			require.NoError(t, updater.DeletePrefixErr([]byte("a")))
+			require.NoError(t, updater.Commit())
+
+			valueStore := hive_adaptor.NewHiveKVStoreAdaptor(kvs, valueStorePrefix)
+			for _, k := range []string{"a", "ab", "abc", "abd", "a\x00", "a\x80", "a\xff"} {
+				require.False(t, valueStore.Has([]byte(k)))
+			}
+			for _, k := range []string{"b", "bc"} {
+				require.EqualValues(t, k+"++", string(valueStore.Get([]byte(k))))
+			}
+			expected := trie.New(m, trie.NewInMemoryKVStore(), nil)
+			expected.UpdateStr("b", "b++")
+			expected.UpdateStr("bc", "bc++")
+			expected.Commit()
+			tr := trie.NewTrieReader(m, hive_adaptor.NewHiveKVStoreAdaptor(kvs, triePrefix), valueStore)
+			require.True(t, m.EqualCommitments(trie.RootCommitment(expected), trie.RootCommitment(tr)))
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+// countingKVReader counts reads of the store
+type countingKVReader struct {
+	trie.KVReader
+	counter *int
+}
+
+func (c countingKVReader) Get(key []byte) []byte {
+	*c.counter++
+	return c.KVReader.Get(key)
+}
+
+func TestDiff(t *testing.T) {
+	type diffEntry struct {
+		key      string
+		old, new trie.TCommitment
+	}
+	collect := func(from, to trie.NodeStore) []diffEntry {
+		ret := make([]diffEntry, 0)
+		trie.Diff(from, to, func(k []byte, oldC, newC trie.TCommitment) bool {
+			ret = append(ret, diffEntry{string(k), oldC, newC})
+			return true
+		})
+		return ret
+	}
+	runTest := func(m trie.CommitmentModel) {
+		t.Run("diff"+tn(m), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			vs := trie.NewVersionedStore(trie.NewInMemoryKVStore())
+			tr := trie.New(m, vs.LatestTrieStore(), nil)
+			state := make(map[string]string)
+			for i := 0; i < 1000; i++ {
+				k := fmt.Sprintf("%d", rnd.Intn(10000))
+				tr.Update([]byte(k), []byte(k))
+				state[k] = k
+			}
+			tr.Commit()
+			v0 := vs.Persist(tr)
+
+			expected := make(map[string]string)
+			for _, k := range sortedState(state) {
+				switch rnd.Intn(10) {
+				case 0:
+					tr.Update([]byte(k), nil)
+					expected[k] = "deleted"
+				case 1:
+					tr.Update([]byte(k), []byte(k+"-modified"))
+					expected[k] = "modified"
+				}
+			}
+			for i := 0; i < 100; i++ {
+				k := fmt.Sprintf("%d", 10000+rnd.Intn(10000))
+				tr.Update([]byte(k), []byte(k))
+				expected[k] = "added"
+			}
+			tr.Commit()
+
+			store0, _ := vs.TrieStore(v0)
+			rdr0 := trie.NewTrieReader(m, store0, nil)
+			check := func(diff []diffEntry) {
+				require.EqualValues(t, len(expected), len(diff))
+				require.True(t, sort.SliceIsSorted(diff, func(i, j int) bool { return diff[i].key < diff[j].key }))
+				for _, d := range diff {
+					switch expected[d.key] {
+					case "added":
+						require.True(t, d.old == nil && d.new != nil)
+					case "deleted":
+						require.True(t, d.old != nil && d.new == nil)
+					case "modified":
+						require.True(t, d.old != nil && d.new != nil)
+						require.False(t, m.EqualCommitments(d.old, d.new))
+					default:
+						t.Fatalf("unexpected key in diff: '%s'", d.key)
+					}
+				}
+			}
+			// committed trie against persisted state
+			check(collect(rdr0, tr))
+
+			v1 := vs.Persist(tr)
+			store1, _ := vs.TrieStore(v1)
+			rdr1 := trie.NewTrieReader(m, store1, nil)
+			check(collect(rdr0, rdr1))
+
+			// reverse diff swaps added and deleted keys
+			reverse := collect(rdr1, rdr0)
+			require.EqualValues(t, len(expected), len(reverse))
+			for _, d := range reverse {
+				if expected[d.key] == "added" {
+					require.True(t, d.old != nil && d.new == nil)
+				}
+			}
+			require.EqualValues(t, 0, len(collect(rdr1, rdr1)))
+
+			// interrupted diff
+			counter := 0
+			trie.Diff(rdr0, rdr1, func(_ []byte, _, _ trie.TCommitment) bool {
+				counter++
+				return counter < 5
+			})
+			require.EqualValues(t, 5, counter)
+
+			// the cost of the diff depends on the size of the difference
+			tr.Update([]byte("single key"), []byte("single value"))
+			tr.Commit()
+			v2 := vs.Persist(tr)
+			store2, _ := vs.TrieStore(v2)
+			numReads := 0
+			diff := collect(
+				trie.NewTrieReader(m, countingKVReader{store1, &numReads}, nil),
+				trie.NewTrieReader(m, countingKVReader{store2, &numReads}, nil),
+			)
+			require.EqualValues(t, 1, len(diff))
+			require.EqualValues(t, "single key", diff[0].key)
+			numNodes := 0
+			countNodes(rdr1, nil, &numNodes)
+			require.True(t, numReads < numNodes/10)
+
+			// diff with the empty trie lists all keys
+			empty := trie.NewTrieReader(m, trie.NewInMemoryKVStore(), nil)
+			require.EqualValues(t, len(state), len(collect(empty, rdr0)))
+			require.EqualValues(t, len(state), len(collect(rdr0, empty)))
+		})
+	}
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160))
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160))
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160))
+}
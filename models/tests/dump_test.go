@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string) {
+		arity := m.PathArity()
+		makeTrie := func(data []string) (*trie.Trie, trie.KVStore) {
+			trieStore := trie.NewInMemoryKVStore()
+			tr := trie.New(m, trieStore, nil)
+			for _, d := range data {
+				tr.UpdateStr(d, d+"++")
+			}
+			tr.Commit()
+			tr.PersistMutations(trieStore)
+			return tr, trieStore
+		}
+		toJSON := func(d *trie.TrieDump) []byte {
+			var buf bytes.Buffer
+			require.NoError(t, d.WriteJSON(&buf))
+			return buf.Bytes()
+		}
+		toDOT := func(d *trie.TrieDump) []byte {
+			var buf bytes.Buffer
+			require.NoError(t, d.WriteDOT(&buf))
+			return buf.Bytes()
+		}
+
+		t.Run("deterministic"+tn(m), func(t *testing.T) {
+			tr1, trieStore := makeTrie(data)
+			reversed := make([]string, len(data))
+			for i, d := range data {
+				reversed[len(data)-1-i] = d
+			}
+			tr2, _ := makeTrie(reversed)
+
+			d1 := trie.DumpTrie(trie.NewTrieReader(m, trieStore, nil))
+			require.EqualValues(t, trie.NumEntries(trieStore), len(d1.Nodes))
+			for _, n := range d1.Nodes {
+				require.False(t, n.Dirty)
+				require.NotEmpty(t, n.Commitment)
+			}
+			require.EqualValues(t, hex.EncodeToString(trie.RootCommitment(tr1).Bytes()), d1.Nodes[0].Commitment)
+			d2 := trie.DumpTrie(tr2)
+			require.EqualValues(t, toJSON(d1), toJSON(d2))
+			require.EqualValues(t, toDOT(d1), toDOT(d2))
+
+			back := &trie.TrieDump{}
+			require.NoError(t, json.Unmarshal(toJSON(d1), back))
+			require.EqualValues(t, d1, back)
+
+			require.EqualValues(t, 0, len(trie.DumpTrie(trie.NewTrieReader(m, trie.NewInMemoryKVStore(), nil)).Nodes))
+		})
+		t.Run("path"+tn(m), func(t *testing.T) {
+			tr, _ := makeTrie(data)
+			for _, d := range data[:10] {
+				if len(d) == 0 {
+					continue
+				}
+				dump := trie.DumpPath(tr, []byte(d))
+				proof := trie.GetProofGeneric(tr, trie.UnpackBytes([]byte(d), arity))
+				require.EqualValues(t, len(proof.Path), len(dump.Nodes))
+				for i, k := range proof.Path {
+					require.EqualValues(t, hex.EncodeToString(k), dump.Nodes[i].Key)
+				}
+				require.NotEmpty(t, dump.Nodes[len(dump.Nodes)-1].Terminal)
+			}
+		})
+		t.Run("dirty"+tn(m), func(t *testing.T) {
+			tr, _ := makeTrie(data)
+			deleted := data[len(data)-1]
+			remaining := []string{"dirty key"}
+			for _, d := range data {
+				if d != deleted {
+					remaining = append(remaining, d)
+				}
+			}
+			tr.UpdateStr("dirty key", "dirty value")
+			tr.DeleteStr(deleted)
+
+			dump := trie.DumpTrie(tr)
+			require.True(t, dump.Nodes[0].Dirty)
+			require.Empty(t, dump.Nodes[0].Commitment)
+			numDirty := 0
+			for _, n := range dump.Nodes {
+				if n.Dirty {
+					numDirty++
+				}
+			}
+			require.True(t, numDirty > 1)
+			pathDump := trie.DumpPath(tr, []byte("dirty key"))
+			last := pathDump.Nodes[len(pathDump.Nodes)-1]
+			require.True(t, last.Dirty)
+			require.NotEmpty(t, last.Terminal)
+			require.True(t, strings.Contains(string(toDOT(dump)), "fillcolor"))
+
+			tr.Commit()
+			expected, _ := makeTrie(remaining)
+			// the value of the 'dirty key' is different
+			tr.UpdateStr("dirty key", "dirty key++")
+			tr.Commit()
+			require.EqualValues(t, toJSON(trie.DumpTrie(expected)), toJSON(trie.DumpTrie(tr)))
+		})
+	}
+	data := genRnd4()[:500]
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256), data)
+	runTest(trie_kzg_bn256.New(), data[:50])
+}
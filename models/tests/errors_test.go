@@ -0,0 +1,276 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+// failingKVReader returns error on every read
+type failingKVReader struct {
+	err error
+}
+
+func (f failingKVReader) GetErr(_ []byte) ([]byte, error) {
+	return nil, f.err
+}
+
+func (f failingKVReader) HasErr(_ []byte) (bool, error) {
+	return false, f.err
+}
+
+// failingKVWriter panics with error on every write, as hive.go adaptor does
+type failingKVWriter struct {
+	err error
+}
+
+func (f failingKVWriter) Set(_, _ []byte) {
+	panic(f.err)
+}
+
+// switchableKVReader returns error on every read while 'fail' is set
+type switchableKVReader struct {
+	trie.KVReader
+	err  error
+	fail bool
+}
+
+func (s *switchableKVReader) GetErr(key []byte) ([]byte, error) {
+	if s.fail {
+		return nil, s.err
+	}
+	return s.Get(key), nil
+}
+
+func (s *switchableKVReader) HasErr(key []byte) (bool, error) {
+	if s.fail {
+		return false, s.err
+	}
+	return s.Has(key), nil
+}
+
+// buggyKVReader fails with runtime error on every read
+type buggyKVReader struct {
+	trie.KVReader
+}
+
+func (b buggyKVReader) Get(key []byte) []byte {
+	var m map[string][]byte
+	m[string(key)] = key
+	return nil
+}
+
+// failingModel panics with the error when it commits to the node with the terminal value "fail".
+// Nil error means runtime error
+type failingModel struct {
+	*trie_blake2b.CommitmentModel
+	err error
+}
+
+func (m *failingModel) UpdateNodeCommitment(mutate *trie.NodeData, childUpdates map[byte]trie.VCommitment, calcDelta bool, terminal trie.TCommitment, update *trie.VCommitment) {
+	if terminal != nil && m.EqualCommitments(terminal, m.CommitToData([]byte("fail"))) {
+		if m.err == nil {
+			var children map[byte]trie.VCommitment
+			children[0] = nil
+		}
+		panic(m.err)
+	}
+	m.CommitmentModel.UpdateNodeCommitment(mutate, childUpdates, calcDelta, terminal, update)
+}
+
+func TestErrors(t *testing.T) {
+	data := []string{"a", "ab", "abc", "ac", "acb", "adb", "bcdddd"}
+	runTest := func(arity trie.PathArity, hashSize trie_blake2b.HashSize) {
+		t.Run("corrupted node"+ar(arity), func(t *testing.T) {
+			model := trie_blake2b.New(arity, hashSize)
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(model, store, nil)
+			for _, d := range data {
+				tr.Update([]byte(d), []byte(d+"++"))
+			}
+			tr.Commit()
+			tr.PersistMutations(store)
+
+			store.Set(mustEncode(t, nil, arity), []byte{0xff, 0xff, 0xff})
+
+			rdr := trie.NewTrieReader(model, store, nil)
+			_, _, err := rdr.GetNodeErr(nil)
+			require.True(t, errors.Is(err, trie.ErrCorruptedNode))
+
+			tr = trie.New(model, store, nil)
+			_, _, err = tr.GetNodeErr(nil)
+			require.True(t, errors.Is(err, trie.ErrCorruptedNode))
+			err = tr.UpdateErr([]byte("x"), []byte("y"))
+			require.True(t, errors.Is(err, trie.ErrCorruptedNode))
+		})
+		t.Run("missing value"+ar(arity), func(t *testing.T) {
+			// terminals of values not longer than the threshold are taken from the value store
+			model := trie_blake2b.New(arity, hashSize, 10)
+			trieStore := trie.NewInMemoryKVStore()
+			valueStore := trie.NewInMemoryKVStore()
+			tr := trie.New(model, trieStore, valueStore)
+			for _, d := range data {
+				v := []byte(d + "++")
+				tr.Update([]byte(d), v)
+				valueStore.Set([]byte(d), v)
+			}
+			tr.Commit()
+			tr.PersistMutations(trieStore)
+
+			valueStore.Set([]byte("bcdddd"), nil)
+			rdr := trie.NewTrieReader(model, trieStore, valueStore)
+			proofGeneric := trie.GetProofGeneric(rdr, trie.UnpackBytes([]byte("abc"), arity))
+			n, ok, err := rdr.GetNodeErr(proofGeneric.Path[len(proofGeneric.Path)-1])
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.NotNil(t, n.Terminal())
+
+			tr = trie.New(model, trieStore, valueStore)
+			err = tr.UpdateErr([]byte("bcdddd"), []byte("1"))
+			require.True(t, errors.Is(err, trie.ErrMissingValue), "%v", err)
+		})
+		t.Run("store error"+ar(arity), func(t *testing.T) {
+			model := trie_blake2b.New(arity, hashSize)
+			errStore := errors.New("i/o error")
+			store := trie.NewKVReaderWithErrAdaptor(failingKVReader{err: errStore})
+
+			rdr := trie.NewTrieReader(model, store, nil)
+			_, _, err := rdr.GetNodeErr(nil)
+			require.True(t, errors.Is(err, errStore))
+
+			tr := trie.New(model, store, nil)
+			err = tr.UpdateErr([]byte("a"), []byte("b"))
+			require.True(t, errors.Is(err, errStore))
+			require.Panics(t, func() {
+				tr.GetNode(nil)
+			})
+
+			tr = trie.New(model, trie.NewInMemoryKVStore(), nil)
+			require.NoError(t, tr.UpdateErr([]byte("a"), []byte("b")))
+			require.NoError(t, tr.CommitErr())
+			_, err = tr.PersistMutationsErr(failingKVWriter{err: errStore})
+			require.True(t, errors.Is(err, errStore))
+		})
+		t.Run("rollback on error"+ar(arity), func(t *testing.T) {
+			model := trie_blake2b.New(arity, hashSize)
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(model, store, nil)
+			for _, d := range data {
+				tr.Update([]byte(d), []byte(d+"++"))
+			}
+			tr.Commit()
+			tr.PersistMutations(store)
+
+			// buffered mutations before the failure survive it
+			good := func(tr *trie.Trie) {
+				tr.Update([]byte("ab"), []byte("changed"))
+				tr.Update([]byte("ax"), []byte("new"))
+			}
+			expected := trie.New(model, store, nil)
+			good(expected)
+			expected.Commit()
+
+			errStore := errors.New("i/o error")
+			rdr := &switchableKVReader{KVReader: store, err: errStore}
+			tr = trie.New(model, trie.NewKVReaderWithErrAdaptor(rdr), nil)
+			failAll := func() {
+				rdr.fail = true
+				defer func() { rdr.fail = false }()
+				// nodes of the subtree are removed one by one until the unread node is reached
+				_, err := tr.DeletePrefixErr([]byte("a"))
+				require.True(t, errors.Is(err, errStore), "%v", err)
+				err = tr.DeleteErr([]byte("bcdddd"))
+				require.True(t, errors.Is(err, errStore), "%v", err)
+				err = tr.UpdateErr([]byte("acb"), []byte("1"))
+				require.True(t, errors.Is(err, errStore), "%v", err)
+			}
+			good(tr)
+			failAll()
+			tr.Commit()
+			require.True(t, model.EqualCommitments(trie.RootCommitment(expected), trie.RootCommitment(tr)))
+
+			// savepoints taken before the failure remain valid
+			savepoint := tr.Savepoint()
+			tr.Update([]byte("x"), []byte("rolled back"))
+			failAll()
+			tr.RollbackTo(savepoint)
+			tr.Update([]byte("y"), []byte("kept"))
+			failAll()
+			require.NoError(t, tr.UpdateErr([]byte("acb"), []byte("1")))
+			tr.Commit()
+			expected.Update([]byte("y"), []byte("kept"))
+			expected.Update([]byte("acb"), []byte("1"))
+			expected.Commit()
+			require.True(t, model.EqualCommitments(trie.RootCommitment(expected), trie.RootCommitment(tr)))
+		})
+		t.Run("runtime error"+ar(arity), func(t *testing.T) {
+			// bugs are not reported as errors of the store
+			model := trie_blake2b.New(arity, hashSize)
+			tr := trie.New(model, buggyKVReader{}, nil)
+			require.Panics(t, func() {
+				_ = tr.UpdateErr([]byte("a"), []byte("b"))
+			})
+			require.Panics(t, func() {
+				_, _, _ = trie.NewTrieReader(model, buggyKVReader{}, nil).GetNodeErr(nil)
+			})
+		})
+		t.Run("missing node"+ar(arity), func(t *testing.T) {
+			model := trie_blake2b.New(arity, hashSize)
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(model, store, nil)
+			tr.Update([]byte("ab1"), []byte("1"))
+			tr.Update([]byte("ac1"), []byte("2"))
+			tr.Commit()
+			tr.PersistMutations(store)
+
+			// delete the node of the key "ac1" from the store
+			proofGeneric := trie.GetProofGeneric(tr, trie.UnpackBytes([]byte("ac1"), arity))
+			lastKey := proofGeneric.Path[len(proofGeneric.Path)-1]
+			store.Set(mustEncode(t, lastKey, arity), nil)
+
+			// deletion of "ab1" requires merge of the root with the node of "ac1"
+			tr = trie.New(model, store, nil)
+			err := tr.UpdateErr([]byte("ab1"), nil)
+			require.True(t, errors.Is(err, trie.ErrMissingNode), "%v", err)
+		})
+		t.Run("parallel commit error"+ar(arity), func(t *testing.T) {
+			runCommit := func(err error) error {
+				tr := trie.New(&failingModel{CommitmentModel: trie_blake2b.New(arity, hashSize), err: err}, trie.NewInMemoryKVStore(), nil)
+				tr.SetCommitWorkers(8)
+				for i := 0; i < 200; i++ {
+					tr.UpdateStr(fmt.Sprintf("%03d", i), "v")
+				}
+				tr.UpdateStr("077", "fail")
+				return tr.CommitErr()
+			}
+			// order of children is random, so the failing node is committed by the worker most of the times
+			for i := 0; i < 20; i++ {
+				err := runCommit(fmt.Errorf("value store: %w", trie.ErrMissingValue))
+				require.True(t, errors.Is(err, trie.ErrMissingValue), "%v", err)
+				// bugs are propagated to the calling goroutine as panics
+				require.Panics(t, func() {
+					_ = runCommit(nil)
+				})
+			}
+		})
+		t.Run("wrong types"+ar(arity), func(t *testing.T) {
+			tr := trie.New(trie_blake2b.New(arity, hashSize), trie.NewInMemoryKVStore(), nil)
+			require.Error(t, tr.UpdateStrErr(1, "a"))
+			require.Error(t, tr.UpdateStrErr("a", 1))
+			require.NoError(t, tr.UpdateStrErr("a", "b"))
+		})
+	}
+	for _, arity := range trie.AllPathArity {
+		runTest(arity, trie_blake2b.HashSize256)
+	}
+}
+
+func mustEncode(t *testing.T, unpacked []byte, arity trie.PathArity) []byte {
+	ret, err := trie.EncodeUnpackedBytes(unpacked, arity)
+	require.NoError(t, err)
+	return ret
+}
@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_blake2b/trie_blake2b_verify"
+	"github.com/iotaledger/trie.go/models/trie_hash"
+	"github.com/iotaledger/trie.go/models/trie_hash/trie_hash_verify"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestHashers(t *testing.T) {
+	expected := []struct {
+		h    trie_hash.Hasher
+		data string
+		hash string
+	}{
+		{trie_hash.HasherSHA256, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{trie_hash.HasherSHA3, "", "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"},
+		{trie_hash.HasherKeccak256, "", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+	}
+	for _, e := range expected {
+		require.EqualValues(t, e.hash, hex.EncodeToString(e.h.Hash([]byte(e.data))))
+	}
+	for _, h := range trie_hash.AllHashers {
+		require.EqualValues(t, h.Size(), len(h.Hash([]byte("abc"))))
+	}
+}
+
+// TestBlake2bGolden checks roots and proofs of trie_blake2b, and of trie_hash with blake2b hashers, against
+// the values produced by the original trie_blake2b implementation, before it became a wrapper of trie_hash
+func TestBlake2bGolden(t *testing.T) {
+	golden := []struct {
+		arity    trie.PathArity
+		hashSize trie_blake2b.HashSize
+		root     string
+		// blake2b-256 hash of all proofs
+		proofs string
+	}{
+		{trie.PathArity256, trie_blake2b.HashSize160, "575ba20dca1cd88fb78bb033e9bdef5a70dad484", "f1c29493b48a130b5ca13eab6dda6980be357f6b115b4c0cf00eaed65b994cd8"},
+		{trie.PathArity256, trie_blake2b.HashSize256, "b006fb257e9aa4743d603bdb1c7661bf6805948e81fb726b75e23ebecf3ce025", "24a3fe839517c31a6dc7fe490d1494903594d265abe775e34b45e4f90a51f896"},
+		{trie.PathArity16, trie_blake2b.HashSize160, "b23a2dd8c58297bb5f397e39bb433a73489036db", "ff594812adb42069f2329b84b6010e97a851a63bc4958f6f127679f06f14e475"},
+		{trie.PathArity16, trie_blake2b.HashSize256, "dc7b2ab6f52d064bd3576edd858268503964c3528706516d1848c1da55cb870b", "f61cb19caaca7f2c34a61bc9ff972b1de30ca2b681808dc74b043512122ce605"},
+		{trie.PathArity2, trie_blake2b.HashSize160, "1b60d2da8d9f484dd3cd4106a34a38005e8a4462", "4e499ae5a9a33d1295c1dcf11ffe3e0d4661c9f2bb8a4808404df5b0db12b2a4"},
+		{trie.PathArity2, trie_blake2b.HashSize256, "096943f90b55fe5ea56ea93ccaf70b0d091b3b39d48ee2aff96d138dd39241d8", "be3fe75137c2d273e49f137dda25124c2c0de35f921752a426616b06b1fba01a"},
+	}
+	// proof of the key data[250] in the 16-ary trie with 160 bit hashes
+	const goldenProof = "0f1406000037393639330400020001300700027e03000000000000000000000000000000000000000000000000000000000000" +
+		"c930c6c38e97a8364dcde4943856311fb5f7a700ffff92fac124ea1e68915c236d063cf922cb5845312783d9db3f87231c9dfa98d9e4" +
+		"0069a4f8ed8c99508f92e421244883bf6b33778fa9dc5fd13a3c5a6b621db112059c369d5c3a1236a721497c2a5c7ad670d79e1031ef" +
+		"3cb97fbdac62a690610a9331e55de7ba680ffcadedcc6ddf429bc2b6a063f1ffce03ecfce1862a1bdc27ebb79f0ff3e92da4f5280200" +
+		"0130090002ff010000000000000000000000000000000000000000000000000000000000000ee3ab46e9e1c76d8e70e76e0bdc34c2cf" +
+		"2f0e99e8da89fecc52ba4ae038dd1b487617566b561f025b39eb7823055aedc41488f5170881f10a7c39bae8278a4b859a269699849b" +
+		"6eec91fdc1da4ce21e49ef3d39c0ecc0dfb8909cbd16c9eba6f965527d677e612140ab30a1af2d975ee83a45d3b1c2443145c309b7c6" +
+		"d6f480ec8d543aa5df379088bc6aac4627c0d96c6752d569b0932fcec2c98849c5094b2956f3802c4e3ebbd6225c2fb9228235498569" +
+		"cb02000130060002140100000000000000000000000000000000000000000000000000000000000017b56bcb687b97eb7656595770f1" +
+		"c18398012cddfb73b79bcf74545acd23cd8c1ed4f0dc6f6e34169de6367e94b32ae7a3a8e08893cf9f32ae2bd8de03000039331000011" +
+		"497781bf0ed854f8e57cd748bcf17d941079812d1"
+
+	data := make([]string, 0, 500)
+	for i := 0; i < 500; i++ {
+		data = append(data, fmt.Sprintf("%d", i*7919%100003))
+	}
+	makeTrie := func(m trie.CommitmentModel) *trie.Trie {
+		tr := trie.New(m, trie.NewInMemoryKVStore(), nil)
+		for i, d := range data {
+			v := []byte(d + "++")
+			if i%2 == 0 {
+				v = bytes.Repeat(v, 5)
+			}
+			tr.Update([]byte(d), v)
+		}
+		for _, d := range data[:len(data)/4] {
+			tr.Delete([]byte(d))
+		}
+		tr.Commit()
+		return tr
+	}
+	for _, g := range golden {
+		m1 := trie_blake2b.New(g.arity, g.hashSize, 10)
+		m2 := trie_hash.New(g.arity, g.hashSize.Hasher(), 10)
+		t.Run("golden"+tn(m1), func(t *testing.T) {
+			require.EqualValues(t, m1.ShortName(), m2.ShortName())
+			tr1 := makeTrie(m1)
+			tr2 := makeTrie(m2)
+			root := trie.RootCommitment(tr1).Bytes()
+			require.EqualValues(t, g.root, hex.EncodeToString(root))
+			require.EqualValues(t, g.root, hex.EncodeToString(trie.RootCommitment(tr2).Bytes()))
+
+			h1, err := blake2b.New256(nil)
+			require.NoError(t, err)
+			h2, err := blake2b.New256(nil)
+			require.NoError(t, err)
+			for i := 0; i < len(data); i += 7 {
+				for _, k := range []string{data[i], data[i] + "absent"} {
+					p1 := m1.Proof([]byte(k), tr1)
+					require.EqualValues(t, g.hashSize, p1.HashSize)
+					require.NoError(t, trie_blake2b_verify.Validate(p1, root))
+					h1.Write(p1.Bytes())
+
+					p2 := m2.Proof([]byte(k), tr2)
+					require.NoError(t, trie_hash_verify.Validate(p2, root))
+					h2.Write(p2.Bytes())
+
+					pBack, err := trie_blake2b.ProofFromBytes(p1.Bytes())
+					require.NoError(t, err)
+					require.EqualValues(t, g.hashSize, pBack.HashSize)
+					require.NoError(t, trie_blake2b_verify.Validate(pBack, root))
+				}
+			}
+			require.EqualValues(t, g.proofs, hex.EncodeToString(h1.Sum(nil)))
+			require.EqualValues(t, g.proofs, hex.EncodeToString(h2.Sum(nil)))
+
+			if g.arity == trie.PathArity16 && g.hashSize == trie_blake2b.HashSize160 {
+				require.EqualValues(t, goldenProof, hex.EncodeToString(m1.Proof([]byte(data[250]), tr1).Bytes()))
+			}
+
+			require.EqualValues(t, fmt.Sprintf("trie commitment model implementation based on blake2b %s, arity: %s, terminal optimization threshold: 10",
+				g.hashSize, g.arity), m1.Description())
+			p := m1.Proof([]byte(data[250]), tr1)
+			for _, e := range p.Path {
+				var buf, bufBack bytes.Buffer
+				require.NoError(t, (*trie_blake2b.ProofElement)(e).Write(&buf, g.arity, g.hashSize))
+				elemBytes := buf.Bytes()
+				eBack := &trie_blake2b.ProofElement{}
+				require.NoError(t, eBack.Read(bytes.NewReader(elemBytes), g.arity, g.hashSize))
+				require.NoError(t, eBack.Write(&bufBack, g.arity, g.hashSize))
+				require.EqualValues(t, elemBytes, bufBack.Bytes())
+				require.EqualValues(t, e.Children, eBack.Children)
+			}
+		})
+	}
+}
+
+func TestTrieHashProof(t *testing.T) {
+	runTest := func(m *trie_hash.CommitmentModel, data []string) {
+		t.Run("proof"+tn(m), func(t *testing.T) {
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(m, store, nil)
+			for _, d := range data {
+				tr.Update([]byte(d), []byte(d+"++"))
+			}
+			tr.Commit()
+			root := trie.RootCommitment(tr).Bytes()
+			for _, d := range data {
+				p := m.Proof([]byte(d), tr)
+				require.NoError(t, trie_hash_verify.Validate(p, root))
+				if len(d) > 0 {
+					require.False(t, trie_hash_verify.IsProofOfAbsence(p))
+					require.NoError(t, trie_hash_verify.ValidateWithValue(p, root, []byte(d+"++")))
+					require.Error(t, trie_hash_verify.ValidateWithValue(p, root, []byte(d+"--")))
+				}
+				p = m.Proof([]byte(d+"absent"), tr)
+				require.NoError(t, trie_hash_verify.Validate(p, root))
+				require.True(t, trie_hash_verify.IsProofOfAbsence(p))
+			}
+			_, err := trie_hash.ProofFromBytes(m.Proof([]byte(data[0]), tr).Bytes(), trie_hash.HasherBlake2b160)
+			require.Error(t, err)
+		})
+	}
+	data := genRnd4()[:500]
+	for _, h := range []trie_hash.Hasher{trie_hash.HasherSHA256, trie_hash.HasherSHA3, trie_hash.HasherKeccak256} {
+		for _, arity := range trie.AllPathArity {
+			runTest(trie_hash.New(arity, h), data)
+		}
+	}
+}
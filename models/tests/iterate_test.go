@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterate(t *testing.T) {
+	runTest := func(t *testing.T, m trie.CommitmentModel) {
+		data := genRnd4()[:5000]
+		data = append(data, "", "a", "ab", "abc", "abd", "ac", "a\x00", "a\x80", "a\xff")
+		sorted := make([]string, 0, len(data))
+		uniq := make(map[string]struct{})
+		for _, d := range data {
+			if _, ok := uniq[d]; !ok {
+				uniq[d] = struct{}{}
+				sorted = append(sorted, d)
+			}
+		}
+		sort.Strings(sorted)
+
+		store := trie.NewInMemoryKVStore()
+		tr := trie.New(m, store, nil)
+		for _, d := range data {
+			tr.Update([]byte(d), []byte(d+"+"))
+		}
+		tr.Commit()
+		tr.PersistMutations(store)
+		rdr := trie.NewTrieReader(m, store, nil)
+
+		t.Run("iterate all"+tn(m), func(t *testing.T) {
+			keys := make([]string, 0, len(sorted))
+			rdr.Iterate(func(k []byte, c trie.TCommitment) bool {
+				require.True(t, m.EqualCommitments(m.CommitToData([]byte(string(k)+"+")), c))
+				keys = append(keys, string(k))
+				return true
+			})
+			require.EqualValues(t, sorted, keys)
+		})
+		t.Run("iterate prefix"+tn(m), func(t *testing.T) {
+			for _, prefix := range []string{"", "a", "ab", "abc", "abcd", "b", "zzzz", "\x00"} {
+				expected := make([]string, 0)
+				for _, s := range sorted {
+					if strings.HasPrefix(s, prefix) {
+						expected = append(expected, s)
+					}
+				}
+				keys := make([]string, 0)
+				rdr.IteratePrefix([]byte(prefix), func(k []byte, _ trie.TCommitment) bool {
+					require.True(t, bytes.HasPrefix(k, []byte(prefix)))
+					keys = append(keys, string(k))
+					return true
+				})
+				require.EqualValues(t, expected, keys)
+			}
+		})
+		t.Run("iterate from"+tn(m), func(t *testing.T) {
+			for _, start := range []string{"", "a", "ab", "abc", "abcd", "b", "zzzz", "\x00", sorted[len(sorted)/2]} {
+				i := sort.SearchStrings(sorted, start)
+				keys := make([]string, 0)
+				rdr.IterateFrom([]byte(start), func(k []byte, _ trie.TCommitment) bool {
+					keys = append(keys, string(k))
+					return true
+				})
+				require.EqualValues(t, sorted[i:], keys)
+			}
+		})
+		t.Run("iterate stop"+tn(m), func(t *testing.T) {
+			keys := make([]string, 0)
+			rdr.Iterate(func(k []byte, _ trie.TCommitment) bool {
+				keys = append(keys, string(k))
+				return len(keys) < 10
+			})
+			require.EqualValues(t, sorted[:10], keys)
+		})
+	}
+	for _, arity := range trie.AllPathArity {
+		runTest(t, trie_blake2b.New(arity, trie_blake2b.HashSize256))
+		runTest(t, trie_blake2b.New(arity, trie_blake2b.HashSize160))
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavepoint(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string) {
+		mutate := func(tr *trie.Trie, from, to int) {
+			for i := from; i < to; i++ {
+				switch i % 5 {
+				case 0:
+					tr.Delete([]byte(data[i/2]))
+				case 1:
+					tr.DeletePrefix([]byte(data[i]))
+				default:
+					tr.Update([]byte(data[i]), []byte(data[i]+"--"))
+				}
+			}
+		}
+		t.Run("savepoint"+tn(m), func(t *testing.T) {
+			store1 := trie.NewInMemoryKVStore()
+			store2 := trie.NewInMemoryKVStore()
+			tr1 := trie.New(m, store1, nil)
+			tr2 := trie.New(m, store2, nil)
+			half := len(data) / 2
+			for _, d := range data[:half] {
+				tr1.Update([]byte(d), []byte(d+"++"))
+				tr2.Update([]byte(d), []byte(d+"++"))
+			}
+			tr1.Commit()
+			tr1.PersistMutations(store1)
+			tr1.ClearCache()
+			tr2.Commit()
+			tr2.PersistMutations(store2)
+			tr2.ClearCache()
+			// uncommitted mutations before the savepoint are kept
+			mutate(tr1, half, half+len(data)/8)
+			mutate(tr2, half, half+len(data)/8)
+
+			sp1 := tr1.Savepoint()
+			mutate(tr1, half+len(data)/8, half+len(data)/4)
+			sp2 := tr1.Savepoint()
+			mutate(tr1, half+len(data)/4, len(data))
+			tr1.RollbackTo(sp2)
+
+			mutate(tr2, half+len(data)/8, half+len(data)/4)
+			tr1.Commit()
+			tr2.Commit()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+			require.Panics(t, func() {
+				tr1.RollbackTo(sp1)
+			})
+
+			sp3 := tr1.Savepoint()
+			require.NotEqualValues(t, sp1, sp3)
+			mutate(tr1, 0, len(data))
+			tr1.RollbackTo(sp3)
+			mutate(tr1, half, len(data))
+			tr1.RollbackTo(sp3)
+			tr1.Commit()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+
+			tr1.PersistMutations(store1)
+			tr1.ClearCache()
+			tr2.PersistMutations(store2)
+			tr2.ClearCache()
+			requireEqualStores(t, store1, store2)
+
+			// rollback of all buffered mutations
+			sp4 := tr1.Savepoint()
+			mutate(tr1, 0, len(data))
+			tr1.RollbackTo(sp4)
+			tr1.Commit()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+			tr1.PersistMutations(store1)
+			requireEqualStores(t, store1, store2)
+		})
+	}
+	data := genRnd4()[:4000]
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160), data)
+	runTest(trie_kzg_bn256.New(), data[:80])
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateNodeFormat(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string) {
+		t.Run("migrate"+tn(m), func(t *testing.T) {
+			trieStore := trie.NewInMemoryKVStore()
+			valueStore := trie.NewInMemoryKVStore()
+			tr := trie.New(m, trieStore, valueStore, true)
+			for i, d := range data {
+				v := []byte(d + "++")
+				if i%3 == 0 {
+					v = []byte(d)
+				}
+				tr.Update([]byte(d), v)
+				valueStore.Set([]byte(d), v)
+			}
+			tr.Commit()
+			tr.PersistMutations(trieStore)
+			root := trie.RootCommitment(tr)
+
+			legacyStore := trie.NewInMemoryKVStore()
+			trieStore.Iterate(func(k, v []byte) bool {
+				format, err := trie.NodeFormatOf(v)
+				require.NoError(t, err)
+				require.EqualValues(t, trie.CurrentNodeFormat, format)
+				unpackedKey, err := trie.DecodeToUnpackedBytes(k, m.PathArity())
+				require.NoError(t, err)
+				legacy, err := trie.ConvertNode(m, unpackedKey, v, valueStore, trie.NodeFormatLegacy)
+				require.NoError(t, err)
+				legacyStore.Set(k, legacy)
+				return true
+			})
+			// the root node was updated after the upgrade, so the store has nodes in both formats
+			rootKey, err := trie.EncodeUnpackedBytes(nil, m.PathArity())
+			require.NoError(t, err)
+			legacyStore.Set(rootKey, trieStore.Get(rootKey))
+			rdr := trie.NewTrieReader(m, legacyStore, valueStore)
+			require.True(t, m.EqualCommitments(root, trie.RootCommitment(rdr)))
+
+			t.Run("missing node", func(t *testing.T) {
+				// each node can be migrated, but the trie is inconsistent. The store is not changed
+				brokenStore := trie.NewInMemoryKVStore()
+				expected := trie.NewInMemoryKVStore()
+				var missingKey []byte
+				legacyStore.Iterate(func(k, v []byte) bool {
+					if missingKey == nil && !bytes.Equal(k, rootKey) {
+						missingKey = trie.Concat(k)
+						return true
+					}
+					brokenStore.Set(k, v)
+					expected.Set(k, v)
+					return true
+				})
+				scratch := trie.NewInMemoryKVStore()
+				_, err := trie.MigrateNodeFormat(m, brokenStore, valueStore, scratch, trie.NodeFormatLegacy)
+				require.True(t, errors.Is(err, trie.ErrCorruptedNode), "%v", err)
+				requireEqualStores(t, expected, brokenStore)
+				require.EqualValues(t, 0, countKeys(scratch))
+			})
+
+			// the scratch store is reused for all migrations
+			scratch := trie.NewInMemoryKVStore()
+			for from := trie.NodeFormatLegacy; from < trie.CurrentNodeFormat; from++ {
+				stats, err := trie.MigrateNodeFormat(m, legacyStore, valueStore, scratch, from)
+				require.NoError(t, err)
+				require.True(t, m.EqualCommitments(root, stats.Root))
+				require.EqualValues(t, countKeys(trieStore), stats.NumNodes)
+				require.EqualValues(t, stats.NumNodes-1, stats.NumMigrated)
+				require.EqualValues(t, 0, countKeys(scratch))
+
+				// repeated migration does not rewrite anything
+				stats, err = trie.MigrateNodeFormat(m, legacyStore, valueStore, scratch, from)
+				require.NoError(t, err)
+				require.True(t, m.EqualCommitments(root, stats.Root))
+				require.EqualValues(t, 0, stats.NumMigrated)
+			}
+			requireEqualStores(t, trieStore, legacyStore)
+		})
+	}
+	data := genRnd4()[:1000]
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256, 10), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160, 10), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160), data)
+	runTest(trie_kzg_bn256.New(), data[:50])
+
+	t.Run("errors", func(t *testing.T) {
+		m := trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160)
+		store := trie.NewInMemoryKVStore()
+		_, err := trie.MigrateNodeFormat(m, store, nil, trie.NewInMemoryKVStore(), trie.CurrentNodeFormat)
+		require.True(t, errors.Is(err, trie.ErrUnknownNodeFormat))
+
+		for _, first := range []byte{0x80, 0xff} {
+			_, err = trie.NodeFormatOf([]byte{first, 0x01})
+			require.True(t, errors.Is(err, trie.ErrUnknownNodeFormat))
+		}
+
+		store.Set([]byte{1}, []byte{0xff, 0x01})
+		_, err = trie.MigrateNodeFormat(m, store, nil, trie.NewInMemoryKVStore(), trie.NodeFormatLegacy)
+		require.True(t, errors.Is(err, trie.ErrCorruptedNode))
+
+		// the scratch store must be empty
+		scratch := trie.NewInMemoryKVStore()
+		scratch.Set([]byte{1}, []byte{1})
+		_, err = trie.MigrateNodeFormat(m, trie.NewInMemoryKVStore(), nil, scratch, trie.NodeFormatLegacy)
+		require.Error(t, err)
+		require.EqualValues(t, 1, countKeys(scratch))
+	})
+}
+
+func TestCompactNodeFormat(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string) {
+		t.Run("compact"+tn(m), func(t *testing.T) {
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(m, store, nil)
+			for _, d := range data {
+				tr.Update([]byte(d), []byte(d+"++"))
+			}
+			tr.Commit()
+			tr.PersistMutations(store)
+
+			storeV1 := trie.NewInMemoryKVStore()
+			store.Iterate(func(k, v []byte) bool {
+				unpackedKey, err := trie.DecodeToUnpackedBytes(k, m.PathArity())
+				require.NoError(t, err)
+				v1, err := trie.ConvertNode(m, unpackedKey, v, nil, trie.NodeFormatV1)
+				require.NoError(t, err)
+				storeV1.Set(k, v1)
+				return true
+			})
+			sizeV1 := trie.ByteSize(storeV1)
+			sizeV2 := trie.ByteSize(store)
+			t.Logf("trie store size: %s: %d bytes, %s: %d bytes", trie.NodeFormatV1, sizeV1, trie.NodeFormatV2, sizeV2)
+			require.Less(t, sizeV2, sizeV1)
+		})
+	}
+	data := genRnd4()
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity64, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), data)
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_blake2b/trie_blake2b_verify"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiProofBlake2b(t *testing.T) {
+	runTest := func(arity trie.PathArity, hashSize trie_blake2b.HashSize) {
+		model := trie_blake2b.New(arity, hashSize)
+		t.Run("multiproof empty trie"+tn(model), func(t *testing.T) {
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(model, store, nil)
+
+			proof := model.MultiProof([][]byte{[]byte("a"), []byte("b")}, tr)
+			require.Nil(t, proof.Root)
+			err := trie_blake2b_verify.ValidateMulti(proof, nil)
+			require.NoError(t, err)
+			_, terms := trie_blake2b_verify.MustKeysWithTerminals(proof)
+			require.EqualValues(t, [][]byte{nil, nil}, terms)
+		})
+		t.Run("multiproof many keys"+tn(model), func(t *testing.T) {
+			addKeys, delKeys := gen2different(10000)
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(model, store, nil)
+			for _, s := range addKeys {
+				tr.Update([]byte(s), []byte(s+"++"))
+			}
+			tr.Commit()
+			rootC := trie.RootCommitment(tr)
+
+			keys := make([][]byte, 0)
+			values := make([][]byte, 0)
+			for i := 0; i < 300 && i < len(addKeys); i++ {
+				keys = append(keys, []byte(addKeys[i]))
+				values = append(values, []byte(addKeys[i]+"++"))
+			}
+			for i := 0; i < 100 && i < len(delKeys); i++ {
+				keys = append(keys, []byte(delKeys[i]))
+				values = append(values, nil)
+			}
+			proof := model.MultiProof(keys, tr)
+			err := trie_blake2b_verify.ValidateMultiWithValues(proof, rootC.Bytes(), values)
+			require.NoError(t, err)
+
+			sizeSingle := 0
+			for _, k := range keys {
+				sizeSingle += trie.MustSize(model.Proof(k, tr))
+			}
+			sizeMulti := trie.MustSize(proof)
+			t.Logf("num keys: %d, size of single proofs: %d, size of multiproof: %d", len(keys), sizeSingle, sizeMulti)
+			require.True(t, sizeMulti < sizeSingle)
+
+			proofBin := proof.Bytes()
+			require.EqualValues(t, len(proofBin), sizeMulti)
+			proofBack, err := trie_blake2b.MultiProofFromBytes(proofBin)
+			require.NoError(t, err)
+			err = trie_blake2b_verify.ValidateMultiWithValues(proofBack, rootC.Bytes(), values)
+			require.NoError(t, err)
+
+			// wrong value
+			values[0] = []byte("wrong value")
+			err = trie_blake2b_verify.ValidateMultiWithValues(proofBack, rootC.Bytes(), values)
+			require.Error(t, err)
+		})
+		t.Run("multiproof incomplete"+tn(model), func(t *testing.T) {
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(model, store, nil)
+			for _, s := range []string{"abc", "abd", "xyz"} {
+				tr.Update([]byte(s), []byte(s+"++"))
+			}
+			tr.Commit()
+			rootC := trie.RootCommitment(tr)
+
+			proof := model.MultiProof([][]byte{[]byte("abc"), []byte("abe")}, tr)
+			err := trie_blake2b_verify.ValidateMultiWithValues(proof, rootC.Bytes(), [][]byte{[]byte("abc++"), nil})
+			require.NoError(t, err)
+
+			// the proof does not contain path to the key
+			proof.Keys = append(proof.Keys, trie.UnpackBytes([]byte("xyz"), arity))
+			err = trie_blake2b_verify.ValidateMulti(proof, rootC.Bytes())
+			require.Error(t, err)
+		})
+	}
+	runTest(trie.PathArity256, trie_blake2b.HashSize256)
+	runTest(trie.PathArity16, trie_blake2b.HashSize256)
+	runTest(trie.PathArity2, trie_blake2b.HashSize256)
+	runTest(trie.PathArity256, trie_blake2b.HashSize160)
+	runTest(trie.PathArity16, trie_blake2b.HashSize160)
+	runTest(trie.PathArity2, trie_blake2b.HashSize160)
+}
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeCache(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string) {
+		t.Run("node cache trie"+tn(m), func(t *testing.T) {
+			store1 := trie.NewInMemoryKVStore()
+			store2 := trie.NewInMemoryKVStore()
+			tr1 := trie.New(m, store1, nil)
+			tr2 := trie.New(m, store2, nil)
+			cache := trie.NewNodeCache(1000)
+			tr2.SetNodeCache(cache)
+			for i, d := range data {
+				tr1.Update([]byte(d), []byte(d+"++"))
+				tr2.Update([]byte(d), []byte(d+"++"))
+				if i%(len(data)/5) == 0 {
+					tr1.Commit()
+					tr2.Commit()
+					require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+					tr2.PersistMutations(store2)
+					tr2.ClearCache()
+				}
+			}
+			for _, d := range data[:len(data)/2] {
+				tr1.Delete([]byte(d))
+				tr2.Delete([]byte(d))
+			}
+			tr1.Commit()
+			tr2.Commit()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+			require.True(t, cache.Len() <= 1000)
+		})
+		t.Run("node cache reader"+tn(m), func(t *testing.T) {
+			store := trie.NewInMemoryKVStore()
+			tr := trie.New(m, store, nil)
+			for _, d := range data {
+				tr.Update([]byte(d), []byte(d+"++"))
+			}
+			tr.Commit()
+			tr.PersistMutations(store)
+			tr.ClearCache()
+
+			cache := trie.NewNodeCache(10)
+			reader := trie.NewTrieReader(m, store, nil)
+			reader.SetNodeCache(cache)
+			for i := 0; i < 3; i++ {
+				require.True(t, m.EqualCommitments(trie.RootCommitment(tr), trie.RootCommitment(reader)))
+			}
+			metrics := cache.Metrics()
+			require.EqualValues(t, 2, metrics.Hits)
+			require.EqualValues(t, 1, metrics.Misses)
+			require.EqualValues(t, 0, metrics.Evictions)
+
+			counter := 0
+			reader.Iterate(func(k []byte, c trie.TCommitment) bool {
+				counter++
+				return true
+			})
+			require.True(t, counter > 0)
+			require.EqualValues(t, 10, cache.Len())
+			require.True(t, cache.Metrics().Evictions > 0)
+
+			cache.Clear()
+			require.EqualValues(t, 0, cache.Len())
+		})
+		t.Run("node cache invalidation"+tn(m), func(t *testing.T) {
+			store := trie.NewInMemoryKVStore()
+			cache := trie.NewNodeCache(1000)
+			tr := trie.New(m, store, nil)
+			tr.SetNodeCache(cache)
+			reader := trie.NewTrieReader(m, store, nil)
+			reader.SetNodeCache(cache)
+			for i, d := range data {
+				tr.Update([]byte(d), []byte(d+"++"))
+				if i%(len(data)/5) != 0 {
+					continue
+				}
+				tr.Commit()
+				if (i/(len(data)/5))%2 == 0 {
+					tr.PersistMutations(store)
+				} else {
+					// mutations are written to the store after PersistMutations, as with the batch
+					batch := trie.NewInMemoryKVStore()
+					tr.PersistMutations(batch)
+					// the reader reads old nodes in between
+					trie.RootCommitment(reader)
+					batch.Iterate(func(k, v []byte) bool {
+						store.Set(k, v)
+						return true
+					})
+				}
+				tr.ClearCache()
+				require.True(t, m.EqualCommitments(trie.RootCommitment(tr), trie.RootCommitment(reader)))
+				// the reader with the cache reads the same as the reader without
+				fresh := trie.NewTrieReader(m, store, nil)
+				collect := func(tr *trie.TrieReader) map[string]string {
+					ret := make(map[string]string)
+					tr.Iterate(func(k []byte, c trie.TCommitment) bool {
+						ret[string(k)] = c.String()
+						return true
+					})
+					return ret
+				}
+				require.EqualValues(t, collect(fresh), collect(reader))
+			}
+		})
+		if _, ok := m.(*trie_blake2b.CommitmentModel); !ok || m.PathArity() != trie.PathArity256 {
+			// the content addressed layout requires distinct commitments of nodes, see ContentAddressedStore
+			return
+		}
+		t.Run("node cache content addressed"+tn(m), func(t *testing.T) {
+			cas := trie.NewContentAddressedStore(m, trie.NewInMemoryKVStore())
+			cache := trie.NewNodeCache(1000)
+			tr := trie.New(m, cas.LatestTrieStore(), nil)
+			tr.SetNodeCache(cache)
+			reader := trie.NewTrieReader(m, cas.LatestTrieStore(), nil)
+			reader.SetNodeCache(cache)
+			for i := 0; i < 1000; i++ {
+				tr.Update([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("%d++", i)))
+				if i%200 == 0 {
+					tr.Delete([]byte(fmt.Sprintf("%d", i/2)))
+					tr.Commit()
+					root := cas.Persist(tr)
+					require.True(t, m.EqualCommitments(root, trie.RootCommitment(reader)))
+					require.True(t, m.EqualCommitments(root, trie.RootCommitment(tr)))
+				}
+			}
+		})
+	}
+	// terminals of short values are taken from the value store, so nodes serialize to the same bytes
+	// when the value changes
+	t.Run("node cache short values", func(t *testing.T) {
+		m := trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160, 10)
+		store := trie.NewInMemoryKVStore()
+		valueStore := trie.NewInMemoryKVStore()
+		cache := trie.NewNodeCache(1000)
+		tr := trie.New(m, store, valueStore)
+		tr.SetNodeCache(cache)
+		reader := trie.NewTrieReader(m, store, valueStore)
+		reader.SetNodeCache(cache)
+		terminal := func(key string) trie.TCommitment {
+			n, ok := reader.GetNode(trie.UnpackBytes([]byte(key), m.PathArity()))
+			require.True(t, ok)
+			return n.Terminal()
+		}
+		for i, value := range []string{"1", "2", "3"} {
+			for _, k := range []string{"a", "b", "c"} {
+				tr.UpdateStr(k, value)
+				valueStore.Set([]byte(k), []byte(value))
+			}
+			tr.Commit()
+			tr.PersistMutations(store)
+			tr.ClearCache()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr), trie.RootCommitment(reader)))
+			require.True(t, m.EqualCommitments(m.CommitToData([]byte(value)), terminal("b")), "round %d", i)
+		}
+	})
+	data := genRnd4()[:2000]
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160), data)
+	runTest(trie_kzg_bn256.New(), data[:50])
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlay(t *testing.T) {
+	const numOverlays = 4
+	runTest := func(m trie.CommitmentModel, data []string) {
+		mutate := func(tr *trie.Trie, n int) {
+			for i := n; i < len(data); i += numOverlays {
+				if i%3 == 0 {
+					tr.Delete([]byte(data[i/2]))
+				} else {
+					tr.Update([]byte(data[i]), []byte(data[i]+"--"))
+				}
+			}
+		}
+		copyStore := func(store trie.KVStore) trie.KVStore {
+			ret := trie.NewInMemoryKVStore()
+			store.Iterate(func(k, v []byte) bool {
+				ret.Set(k, v)
+				return true
+			})
+			return ret
+		}
+		t.Run("overlay"+tn(m), func(t *testing.T) {
+			store := trie.NewInMemoryKVStore()
+			base := trie.New(m, store, nil)
+			half := len(data) / 2
+			for _, d := range data[:half] {
+				base.Update([]byte(d), []byte(d+"++"))
+			}
+			base.Commit()
+			base.PersistMutations(store)
+			base.ClearCache()
+			// the last commit of the base is not persisted
+			for _, d := range data[half : half+len(data)/4] {
+				base.Update([]byte(d), []byte(d+"++"))
+			}
+			base.Commit()
+			baseRoot := trie.RootCommitment(base)
+			baseStore := copyStore(store)
+
+			overlays := make([]*trie.Trie, numOverlays)
+			var wg sync.WaitGroup
+			for i := range overlays {
+				overlays[i] = trie.NewOverlay(base)
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					mutate(overlays[i], i)
+					overlays[i].Commit()
+				}(i)
+			}
+			wg.Wait()
+			// the base was not modified by overlays
+			require.True(t, m.EqualCommitments(baseRoot, trie.RootCommitment(base)))
+			requireEqualStores(t, baseStore, store)
+
+			base.PersistMutations(store)
+			base.ClearCache()
+			for i, ov := range overlays {
+				storeExpected := copyStore(store)
+				expected := trie.New(m, storeExpected, nil)
+				mutate(expected, i)
+				expected.Commit()
+				require.True(t, m.EqualCommitments(trie.RootCommitment(expected), trie.RootCommitment(ov)))
+				require.True(t, m.EqualCommitments(trie.RootCommitment(expected), trie.RootCommitment(ov.Committed())))
+
+				storeOverlay := copyStore(store)
+				ov.PersistMutations(storeOverlay)
+				rdr := trie.NewTrieReader(m, storeOverlay, nil)
+				require.True(t, m.EqualCommitments(trie.RootCommitment(expected), trie.RootCommitment(rdr)))
+			}
+			require.True(t, m.EqualCommitments(baseRoot, trie.RootCommitment(trie.NewTrieReader(m, store, nil))))
+
+			// overlay of the overlay
+			ov := trie.NewOverlay(overlays[0])
+			require.True(t, m.EqualCommitments(trie.RootCommitment(overlays[0]), trie.RootCommitment(ov)))
+			mutate(ov, 1)
+			ov.Commit()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(overlays[0]), trie.RootCommitment(overlays[0].Committed())))
+			ov.ClearCache()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(overlays[0]), trie.RootCommitment(ov)))
+		})
+	}
+	data := genRnd4()[:4000]
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256), data)
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), data)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160), data)
+	runTest(trie_kzg_bn256.New(), data[:80])
+}
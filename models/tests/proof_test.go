@@ -565,6 +565,111 @@ func TestTrieProofKZG(t *testing.T) {
 		_, ok := Model.ProofOfInclusion([]byte("ab"), tr)
 		require.False(t, ok)
 	})
+	t.Run("proof of path", func(t *testing.T) {
+		store := trie.NewInMemoryKVStore()
+		tr := trie.New(Model, store, nil)
+
+		_, ok := Model.ProofOfPath([]byte("a"), tr)
+		require.False(t, ok)
+
+		data := []string{"a", "ab", "abc", "ac", "acb", "adb", "bcdddd"}
+		for _, d := range data {
+			tr.Update([]byte(d), []byte("1"+d))
+		}
+		tr.Commit()
+		rootC := trie.RootCommitment(tr)
+
+		for _, d := range data {
+			_, ok = Model.ProofOfPath([]byte(d), tr)
+			require.False(t, ok)
+		}
+		// "abd", "ae", "acbb" and "x" extend the path, "b", "bc" and "bcdd1" split the path,
+		// "" points to the node without terminal
+		absent := []string{"", "abd", "ae", "b", "bc", "bcdd1", "x", "acbb"}
+		for _, d := range absent {
+			pop, ok := Model.ProofOfPath([]byte(d), tr)
+			require.True(t, ok)
+			err := pop.Validate(rootC)
+			require.NoError(t, err)
+
+			popBack, err := trie_kzg_bn256.ProofOfPathFromBytes(pop.Bytes())
+			require.NoError(t, err)
+			err = popBack.Validate(rootC)
+			require.NoError(t, err)
+			t.Logf("key: '%s', proof of path size = %d bytes", d, trie.MustSize(pop))
+
+			// the proof must not be valid for a present key
+			popBack.Key = []byte("abc")
+			require.Error(t, popBack.Validate(rootC))
+		}
+	})
+	t.Run("aggregated proof", func(t *testing.T) {
+		store := trie.NewInMemoryKVStore()
+		tr := trie.New(Model, store, nil)
+
+		_, ok := Model.AggregatedProof([][]byte{[]byte("a")}, tr)
+		require.False(t, ok)
+
+		data := []string{"a", "ab", "abc", "ac", "acb", "adb", "bcdddd"}
+		for _, d := range data {
+			tr.Update([]byte(d), []byte("1"+d))
+		}
+		tr.Commit()
+		rootC := trie.RootCommitment(tr)
+
+		keys := make([][]byte, 0)
+		values := make([][]byte, 0)
+		sizeSingle := 0
+		for _, d := range data {
+			keys = append(keys, []byte(d))
+			values = append(values, []byte("1"+d))
+
+			ap, ok := Model.AggregatedProof([][]byte{[]byte(d)}, tr)
+			require.True(t, ok)
+			err := ap.Validate(rootC, []byte("1"+d))
+			require.NoError(t, err)
+
+			poi, ok := Model.ProofOfInclusion([]byte(d), tr)
+			require.True(t, ok)
+			sizeSingle += trie.MustSize(poi)
+		}
+		ap, ok := Model.AggregatedProof(keys, tr)
+		require.True(t, ok)
+		err := ap.Validate(rootC, values...)
+		require.NoError(t, err)
+		t.Logf("num keys: %d, size of single proofs: %d, size of aggregated proof: %d", len(keys), sizeSingle, trie.MustSize(ap))
+
+		apBack, err := trie_kzg_bn256.AggregatedProofFromBytes(ap.Bytes())
+		require.NoError(t, err)
+		err = apBack.Validate(rootC, values...)
+		require.NoError(t, err)
+
+		values[1] = []byte("wrong value")
+		require.Error(t, apBack.Validate(rootC, values...))
+
+		// wrong terminal
+		apBack.Terminals[0] = apBack.Terminals[1]
+		require.Error(t, apBack.Validate(rootC))
+
+		// the keys are bound to the paths
+		apBack, err = trie_kzg_bn256.AggregatedProofFromBytes(ap.Bytes())
+		require.NoError(t, err)
+		apBack.Keys[1] = []byte("aX")
+		require.Error(t, apBack.Validate(rootC))
+		apBack.Keys[1] = []byte("abc")
+		require.Error(t, apBack.Validate(rootC))
+
+		apBack, err = trie_kzg_bn256.AggregatedProofFromBytes(ap.Bytes())
+		require.NoError(t, err)
+		last := apBack.Paths[len(keys)-1]
+		apBack.PathFragments[last[len(last)-1].CommitmentIndex] = []byte("X")
+		require.Error(t, apBack.Validate(rootC))
+
+		_, ok = Model.AggregatedProof([][]byte{[]byte("a"), []byte("abd")}, tr)
+		require.False(t, ok)
+		_, ok = Model.AggregatedProof([][]byte{[]byte("")}, tr)
+		require.False(t, ok)
+	})
 	t.Run("proof many entries", func(t *testing.T) {
 		store := trie.NewInMemoryKVStore()
 		tr := trie.New(Model, store, nil)
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_blake2b/trie_blake2b_verify"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeProofBlake2b(t *testing.T) {
+	runTest := func(arity trie.PathArity, hashSize trie_blake2b.HashSize) {
+		model := trie_blake2b.New(arity, hashSize)
+		t.Run("range proof empty trie"+tn(model), func(t *testing.T) {
+			tr := trie.New(model, trie.NewInMemoryKVStore(), nil)
+			proof := model.RangeProof([]byte("a"), []byte("b"), tr)
+			require.Nil(t, proof.Root)
+			err := trie_blake2b_verify.ValidateRange(proof, nil, nil, nil)
+			require.NoError(t, err)
+			err = trie_blake2b_verify.ValidateRange(proof, nil, [][]byte{[]byte("a")}, [][]byte{[]byte("a")})
+			require.Error(t, err)
+		})
+		t.Run("range proof"+tn(model), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			addKeys, _ := gen2different(10000)
+			addKeys = append(addKeys, "", "a", "ab", "abc")
+			tr := trie.New(model, trie.NewInMemoryKVStore(), nil)
+			uniq := make(map[string]struct{})
+			for _, s := range addKeys {
+				tr.Update([]byte(s), []byte(s+"++"))
+				uniq[s] = struct{}{}
+			}
+			tr.Commit()
+			rootC := trie.RootCommitment(tr)
+			sorted := make([]string, 0, len(uniq))
+			for s := range uniq {
+				sorted = append(sorted, s)
+			}
+			sort.Strings(sorted)
+
+			// keys and values in the range, nil end means no bound
+			inRange := func(start, end []byte) ([][]byte, [][]byte) {
+				keys := make([][]byte, 0)
+				values := make([][]byte, 0)
+				for _, s := range sorted[sort.SearchStrings(sorted, string(start)):] {
+					if end != nil && s > string(end) {
+						break
+					}
+					keys = append(keys, []byte(s))
+					values = append(values, []byte(s+"++"))
+				}
+				return keys, values
+			}
+			bounds := [][2][]byte{
+				{[]byte(""), nil},
+				{[]byte(""), []byte("")},
+				{[]byte("a"), []byte("abc")},
+				{[]byte("ab"), []byte("ab")},
+				{[]byte("zzz"), nil},
+				{[]byte(sorted[10]), []byte(sorted[20])},
+				{[]byte(sorted[len(sorted)/2] + "\x00"), []byte(sorted[len(sorted)/2+1])},
+			}
+			for i := 0; i < 20; i++ {
+				i1, i2 := rnd.Intn(len(sorted)), rnd.Intn(len(sorted))
+				if i1 > i2 {
+					i1, i2 = i2, i1
+				}
+				bounds = append(bounds, [2][]byte{[]byte(sorted[i1]), []byte(sorted[i2])})
+			}
+			for _, b := range bounds {
+				keys, values := inRange(b[0], b[1])
+				proof := model.RangeProof(b[0], b[1], tr)
+				err := trie_blake2b_verify.ValidateRange(proof, rootC.Bytes(), keys, values)
+				require.NoError(t, err, "%s", proof)
+				unpackedKeys, _ := trie_blake2b_verify.MustKeysWithTerminalsInRange(proof)
+				require.EqualValues(t, len(keys), len(unpackedKeys))
+
+				proofBack, err := trie_blake2b.RangeProofFromBytes(proof.Bytes())
+				require.NoError(t, err)
+				require.EqualValues(t, proof.Bytes(), proofBack.Bytes())
+				err = trie_blake2b_verify.ValidateRange(proofBack, rootC.Bytes(), keys, values)
+				require.NoError(t, err)
+
+				if len(keys) > 0 {
+					// omitted key
+					i := rnd.Intn(len(keys))
+					omittedKeys := append(append([][]byte{}, keys[:i]...), keys[i+1:]...)
+					omittedValues := append(append([][]byte{}, values[:i]...), values[i+1:]...)
+					err = trie_blake2b_verify.ValidateRange(proof, rootC.Bytes(), omittedKeys, omittedValues)
+					require.Error(t, err)
+
+					// wrong value
+					wrongValues := append([][]byte{}, values...)
+					wrongValues[i] = []byte("wrong value")
+					err = trie_blake2b_verify.ValidateRange(proof, rootC.Bytes(), keys, wrongValues)
+					require.Error(t, err)
+				}
+				// injected key
+				injected := trie.Concat(b[0], "\x00injected")
+				if b[1] == nil || string(injected) <= string(b[1]) {
+					injectedKeys := append([][]byte{injected}, keys...)
+					injectedValues := append([][]byte{[]byte("injected")}, values...)
+					sort.Sort(byKeys{injectedKeys, injectedValues})
+					err = trie_blake2b_verify.ValidateRange(proof, rootC.Bytes(), injectedKeys, injectedValues)
+					require.Error(t, err)
+				}
+			}
+		})
+		t.Run("range proof incomplete"+tn(model), func(t *testing.T) {
+			tr := trie.New(model, trie.NewInMemoryKVStore(), nil)
+			for _, s := range []string{"abc", "abd", "xyz"} {
+				tr.Update([]byte(s), []byte(s+"++"))
+			}
+			tr.Commit()
+			rootC := trie.RootCommitment(tr)
+
+			keys := [][]byte{[]byte("abc"), []byte("abd")}
+			values := [][]byte{[]byte("abc++"), []byte("abd++")}
+			proof := model.RangeProof([]byte("a"), []byte("b"), tr)
+			err := trie_blake2b_verify.ValidateRange(proof, rootC.Bytes(), keys, values)
+			require.NoError(t, err)
+
+			// the proof with the wider range does not contain nodes of the key 'xyz'
+			proof.End = nil
+			err = trie_blake2b_verify.ValidateRange(proof, rootC.Bytes(), keys, values)
+			require.Error(t, err)
+			proof.End = trie.UnpackBytes([]byte("xyz"), arity)
+			err = trie_blake2b_verify.ValidateRange(proof, rootC.Bytes(), keys, values)
+			require.Error(t, err)
+		})
+	}
+	runTest(trie.PathArity256, trie_blake2b.HashSize256)
+	runTest(trie.PathArity16, trie_blake2b.HashSize256)
+	runTest(trie.PathArity2, trie_blake2b.HashSize256)
+	runTest(trie.PathArity256, trie_blake2b.HashSize160)
+	runTest(trie.PathArity16, trie_blake2b.HashSize160)
+	runTest(trie.PathArity2, trie_blake2b.HashSize160)
+}
+
+// byKeys sorts keys together with values
+type byKeys struct {
+	keys, values [][]byte
+}
+
+func (b byKeys) Len() int           { return len(b.keys) }
+func (b byKeys) Less(i, j int) bool { return string(b.keys[i]) < string(b.keys[j]) }
+func (b byKeys) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+// nodeStoreOnly hides the value store of the node store
+type nodeStoreOnly struct {
+	trie.NodeStore
+}
+
+func TestSnapshot(t *testing.T) {
+	runTest := func(m trie.CommitmentModel) {
+		t.Run("snapshot"+tn(m), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			trieStore := trie.NewInMemoryKVStore()
+			valueStore := trie.NewInMemoryKVStore()
+			tr := trie.New(m, trieStore, valueStore)
+			for i := 0; i < 100; i++ {
+				k := fmt.Sprintf("%d", rnd.Intn(1000))
+				v := fmt.Sprintf("value of the key %s", k)
+				if i%2 == 0 {
+					v = k
+				}
+				tr.Update([]byte(k), []byte(v))
+				valueStore.Set([]byte(k), []byte(v))
+			}
+			tr.Commit()
+			tr.PersistMutations(trieStore)
+			tr.ClearCache()
+			rdr := trie.NewTrieReader(m, trieStore, valueStore)
+			root := trie.RootCommitment(rdr)
+
+			var buf bytes.Buffer
+			err := trie.ExportSnapshot(rdr, &buf)
+			require.NoError(t, err)
+			snapshot := buf.Bytes()
+
+			importTo := func(data []byte) (*trie.SnapshotHeader, trie.KVStore, trie.KVStore, error) {
+				trieStoreBack := trie.NewInMemoryKVStore()
+				valueStoreBack := trie.NewInMemoryKVStore()
+				header, err := trie.ImportSnapshot(m, bytes.NewReader(data), trieStoreBack, valueStoreBack)
+				return header, trieStoreBack, valueStoreBack, err
+			}
+			header, trieStoreBack, valueStoreBack, err := importTo(snapshot)
+			require.NoError(t, err)
+			require.EqualValues(t, m.ShortName(), header.ModelName)
+			require.EqualValues(t, m.PathArity(), header.PathArity)
+			require.True(t, bytes.Equal(root.Bytes(), header.Root))
+			t.Logf("%s, snapshot size: %d", header, len(snapshot))
+
+			rdrBack := trie.NewTrieReader(m, trieStoreBack, valueStoreBack)
+			require.True(t, m.EqualCommitments(root, trie.RootCommitment(rdrBack)))
+			require.EqualValues(t, trie.NumEntries(valueStore), trie.NumEntries(valueStoreBack))
+			valueStore.Iterate(func(k, v []byte) bool {
+				require.True(t, bytes.Equal(v, valueStoreBack.Get(k)))
+				return true
+			})
+			// the trie continues on top of the imported store
+			trBack := trie.New(m, trieStoreBack, valueStoreBack)
+			tr = trie.New(m, trieStore, valueStore)
+			trBack.Update([]byte("new key"), []byte("new value"))
+			tr.Update([]byte("new key"), []byte("new value"))
+			trBack.Commit()
+			tr.Commit()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr), trie.RootCommitment(trBack)))
+
+			// committed Trie is exported the same way as the reader
+			buf.Reset()
+			err = trie.ExportSnapshot(trie.New(m, trieStore, valueStore), &buf)
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(snapshot, buf.Bytes()))
+
+			for size := 0; size < len(snapshot); size += 1 + rnd.Intn(len(snapshot)/20) {
+				_, _, _, err = importTo(snapshot[:size])
+				require.True(t, errors.Is(err, trie.ErrTruncatedSnapshot))
+			}
+			_, _, _, err = importTo(snapshot[:len(snapshot)-1])
+			require.True(t, errors.Is(err, trie.ErrTruncatedSnapshot))
+
+			for i := 0; i < len(snapshot); i += 1 + rnd.Intn(8) {
+				tampered := make([]byte, len(snapshot))
+				copy(tampered, snapshot)
+				tampered[i] ^= 0x01
+				_, _, _, err = importTo(tampered)
+				require.Error(t, err, "tampered byte #%d", i)
+			}
+
+			// snapshot without values can't be imported with the value store
+			buf.Reset()
+			err = trie.ExportSnapshot(nodeStoreOnly{rdr}, &buf)
+			require.NoError(t, err)
+			noValues := buf.Bytes()
+			_, _, _, err = importTo(noValues)
+			require.True(t, errors.Is(err, trie.ErrInvalidSnapshot), "%v", err)
+			header, err = trie.ImportSnapshot(m, bytes.NewReader(noValues), trie.NewInMemoryKVStore(), nil)
+			require.NoError(t, err)
+			require.False(t, header.HasValues)
+
+			// the snapshot claims values, but they are dropped. The checksum is correct
+			forged := make([]byte, len(noValues))
+			copy(forged, noValues)
+			flagPos := 1 + 2 + len(header.ModelName) + 1 + 1 + 2 + len(header.Root)
+			require.EqualValues(t, 0, forged[flagPos])
+			forged[flagPos] = 1
+			checksum := blake2b.Sum256(forged[:len(forged)-blake2b.Size256])
+			copy(forged[len(forged)-blake2b.Size256:], checksum[:])
+			_, _, _, err = importTo(forged)
+			require.True(t, errors.Is(err, trie.ErrInvalidSnapshot), "%v", err)
+
+			// all values must be exported
+			var someKey []byte
+			valueStore.Iterate(func(k, _ []byte) bool {
+				someKey = trie.Concat(k)
+				return false
+			})
+			partialValues := trie.NewInMemoryKVStore()
+			valueStore.Iterate(func(k, v []byte) bool {
+				if !bytes.Equal(k, someKey) {
+					partialValues.Set(k, v)
+				}
+				return true
+			})
+			err = trie.ExportSnapshot(trie.NewTrieReader(m, trieStore, partialValues), &bytes.Buffer{})
+			require.True(t, errors.Is(err, trie.ErrMissingValue), "%v", err)
+
+			otherHashSize := trie_blake2b.HashSize160
+			if m.(*trie_blake2b.CommitmentModel).HashSize() == otherHashSize {
+				otherHashSize = trie_blake2b.HashSize256
+			}
+			otherModel := trie_blake2b.New(m.PathArity(), otherHashSize)
+			_, err = trie.ImportSnapshot(otherModel, bytes.NewReader(snapshot), trie.NewInMemoryKVStore(), nil)
+			require.True(t, errors.Is(err, trie.ErrInvalidSnapshot))
+
+			buf.Reset()
+			err = trie.ExportSnapshot(trie.NewTrieReader(m, trie.NewInMemoryKVStore(), nil), &buf)
+			require.NoError(t, err)
+			header, trieStoreBack, _, err = importTo(buf.Bytes())
+			require.NoError(t, err)
+			require.EqualValues(t, 0, len(header.Root))
+			require.EqualValues(t, 0, trie.NumEntries(trieStoreBack))
+		})
+	}
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160))
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160))
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160, 10))
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_kzg_bn256"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpill(t *testing.T) {
+	runTest := func(m trie.CommitmentModel, data []string, maxNodes, maxBytes int, optimizeKeyCommitments bool) {
+		t.Run("spill"+tn(m), func(t *testing.T) {
+			store1 := trie.NewInMemoryKVStore()
+			tr1 := trie.New(m, store1, nil, optimizeKeyCommitments)
+
+			store2 := trie.NewInMemoryKVStore()
+			scratch := trie.NewInMemoryKVStore()
+			tr2 := trie.New(m, store2, nil, optimizeKeyCommitments)
+			tr2.SetSpillStore(scratch, maxNodes, maxBytes)
+
+			for round := 0; round < 2; round++ {
+				for i, d := range data {
+					tr1.Update([]byte(d), []byte(d+"++"))
+					tr2.Update([]byte(d), []byte(d+"++"))
+					if optimizeKeyCommitments && i%5 == 0 {
+						tr1.InsertKeyCommitment([]byte(d + "k"))
+						tr2.InsertKeyCommitment([]byte(d + "k"))
+					}
+					if i%3 == 0 {
+						tr1.Delete([]byte(data[i/2]))
+						tr2.Delete([]byte(data[i/2]))
+					}
+				}
+				require.True(t, countKeys(scratch) > 0)
+				tr1.DeletePrefix([]byte("a"))
+				tr2.DeletePrefix([]byte("a"))
+
+				tr1.Commit()
+				tr2.Commit()
+				require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+				require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2.Committed())))
+
+				tr1.PersistMutations(store1)
+				tr1.ClearCache()
+				tr2.PersistMutations(store2)
+				tr2.ClearCache()
+				require.EqualValues(t, 0, countKeys(scratch))
+				requireEqualStores(t, store1, store2)
+				rdr := trie.NewTrieReader(m, store2, nil)
+				require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(rdr)))
+			}
+		})
+	}
+	// terminals of spilled nodes are taken from the value store, which is not updated yet
+	runTestValues := func(m trie.CommitmentModel, data []string, maxNodes int) {
+		t.Run("spill values"+tn(m), func(t *testing.T) {
+			store1 := trie.NewInMemoryKVStore()
+			tr1 := trie.New(m, store1, nil)
+
+			store2 := trie.NewInMemoryKVStore()
+			scratch := trie.NewInMemoryKVStore()
+			tr2 := trie.New(m, store2, nil)
+			tr2.SetSpillStore(scratch, maxNodes, 0)
+
+			valueStore := trie.NewInMemoryKVStore()
+			for _, d := range data {
+				tr1.Update([]byte(d), []byte(d+"++"))
+				tr2.Update([]byte(d), []byte(d+"++"))
+				valueStore.Set([]byte(d), []byte(d+"++"))
+			}
+			require.True(t, countKeys(scratch) > 0)
+			tr1.Commit()
+			tr2.Commit()
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(tr2)))
+
+			tr1.PersistMutations(store1)
+			tr2.PersistMutations(store2)
+			tr2.ClearCache()
+			// terminals of spilled nodes are not stored with nodes, same as terminals of buffered nodes
+			requireEqualStores(t, store1, store2)
+			rdr := trie.NewTrieReader(m, store2, valueStore)
+			require.True(t, m.EqualCommitments(trie.RootCommitment(tr1), trie.RootCommitment(rdr)))
+			for _, d := range data {
+				require.True(t, trie.GetProofGeneric(rdr, trie.UnpackBytes([]byte(d), m.PathArity())).Ending == trie.EndingTerminal)
+			}
+		})
+	}
+	data := genRnd4()[:5000]
+	runTestValues(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160, 10), data[:200], 5)
+	runTestValues(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256, 10), data[:1000], 50)
+	runTestValues(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160, 10), data[:1000], 100)
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256), data, 100, 0, false)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256), data, 0, 100000, false)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256), data, 1000, 0, false)
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), data, 0, 50000, false)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), data, 10, 0, false)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160), data, 500, 500000, false)
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256), data, 100, 0, true)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160), data, 0, 100000, true)
+	runTest(trie_kzg_bn256.New(), data[:50], 10, 0, false)
+}
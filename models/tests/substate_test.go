@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_blake2b/trie_blake2b_verify"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubstateBlake2b(t *testing.T) {
+	// bit length of 105 bytes is a multiple of bit widths of all path arities, so the prefixes are always aligned
+	pa, px := strings.Repeat("a", 105), strings.Repeat("x", 105)
+	runTest := func(arity trie.PathArity, hashSize trie_blake2b.HashSize) {
+		model := trie_blake2b.New(arity, hashSize)
+		t.Run("substate empty trie"+tn(model), func(t *testing.T) {
+			tr := trie.New(model, trie.NewInMemoryKVStore(), nil)
+			require.Nil(t, trie.SubstateRoot(tr, []byte(pa)))
+			proof := model.SubstateProof([]byte(pa), tr)
+			err := trie_blake2b_verify.ValidateSubstate(proof, nil, nil)
+			require.NoError(t, err)
+		})
+		t.Run("substate root"+tn(model), func(t *testing.T) {
+			keys := []string{"a", "abc", "abd", "abde", "acb", "b", "bcd", "xyz1", "xyz2"}
+			tr := trie.New(model, trie.NewInMemoryKVStore(), nil)
+			for _, k := range keys {
+				tr.UpdateStr(k, k+"++")
+			}
+			tr.Commit()
+			rootC := trie.RootCommitment(tr)
+			require.True(t, model.EqualCommitments(rootC, trie.SubstateRoot(tr, nil)))
+
+			for _, prefix := range []string{"", "a", "ab", "abd", "abde", "b", "x", "xy", "xyz", "xyz1"} {
+				if !trie.IsAlignedPrefix([]byte(prefix), arity) {
+					require.Panics(t, func() { trie.SubstateRoot(tr, []byte(prefix)) })
+					require.Panics(t, func() { model.SubstateProof([]byte(prefix), tr) })
+					continue
+				}
+				subRoot := trie.SubstateRoot(tr, []byte(prefix))
+				require.NotNil(t, subRoot)
+				proof := model.SubstateProof([]byte(prefix), tr)
+				err := trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), subRoot.Bytes())
+				require.NoError(t, err)
+				require.EqualValues(t, subRoot.Bytes(), trie_blake2b_verify.MustSubstateRoot(proof))
+
+				proofBack, err := trie_blake2b.ProofFromBytes(proof.Bytes())
+				require.NoError(t, err)
+				err = trie_blake2b_verify.ValidateSubstate(proofBack, rootC.Bytes(), subRoot.Bytes())
+				require.NoError(t, err)
+
+				err = trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), nil)
+				require.Error(t, err)
+				err = trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), rootC.Bytes())
+				if prefix != "" {
+					require.Error(t, err)
+				}
+			}
+			for _, prefix := range []string{"abcd", "ac1", "c", "xyz3", "xyz11"} {
+				if !trie.IsAlignedPrefix([]byte(prefix), arity) {
+					continue
+				}
+				require.Nil(t, trie.SubstateRoot(tr, []byte(prefix)))
+				proof := model.SubstateProof([]byte(prefix), tr)
+				err := trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), nil)
+				require.NoError(t, err)
+				require.Nil(t, trie_blake2b_verify.MustSubstateRoot(proof))
+				err = trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), rootC.Bytes())
+				require.Error(t, err)
+			}
+		})
+		t.Run("substate proof with swapped key"+tn(model), func(t *testing.T) {
+			tr := trie.New(model, trie.NewInMemoryKVStore(), nil)
+			for _, k := range []string{pa + "c", pa + "d", px + "1", px + "2"} {
+				tr.UpdateStr(k, k+"++")
+			}
+			tr.Commit()
+			rootC := trie.RootCommitment(tr)
+
+			proof := model.SubstateProof([]byte(px), tr)
+			err := trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), trie.SubstateRoot(tr, []byte(px)).Bytes())
+			require.NoError(t, err)
+			// the path of the proof does not follow the swapped key, so it cannot prove the sub-state of the other prefix
+			proof.Key = trie.UnpackBytes([]byte(pa), arity)
+			err = trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), trie.SubstateRoot(tr, []byte(px)).Bytes())
+			require.Error(t, err)
+			err = trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), trie.SubstateRoot(tr, []byte(pa)).Bytes())
+			require.Error(t, err)
+
+			tr = trie.New(model, trie.NewInMemoryKVStore(), nil)
+			for _, k := range []string{"a", "b", "bcd"} {
+				tr.UpdateStr(k, k+"++")
+			}
+			tr.Commit()
+			rootC = trie.RootCommitment(tr)
+			proof = model.Proof([]byte("b"), tr)
+			subRootB := trie_blake2b_verify.MustSubstateRoot(proof)
+			require.NoError(t, trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), subRootB))
+			proof.Key = trie.UnpackBytes([]byte("a"), arity)
+			require.Error(t, trie_blake2b_verify.Validate(proof, rootC.Bytes()))
+			require.Error(t, trie_blake2b_verify.ValidateSubstate(proof, rootC.Bytes(), subRootB))
+		})
+		t.Run("substate root changes"+tn(model), func(t *testing.T) {
+			tr := trie.New(model, trie.NewInMemoryKVStore(), nil)
+			for _, k := range []string{pa + "c", pa + "d", px + "1", px + "2"} {
+				tr.UpdateStr(k, k+"++")
+			}
+			tr.Commit()
+			subRootA := trie.SubstateRoot(tr, []byte(pa))
+			subRootX := trie.SubstateRoot(tr, []byte(px))
+
+			// mutation outside the sub-state does not change the sub-state root
+			tr.UpdateStr(px+"1", "changed")
+			tr.Commit()
+			require.True(t, model.EqualCommitments(subRootA, trie.SubstateRoot(tr, []byte(pa))))
+			require.False(t, model.EqualCommitments(subRootX, trie.SubstateRoot(tr, []byte(px))))
+
+			tr.UpdateStr(pa+"e", "new")
+			tr.Commit()
+			require.False(t, model.EqualCommitments(subRootA, trie.SubstateRoot(tr, []byte(pa))))
+			tr.DeleteStr(pa + "e")
+			tr.Commit()
+			require.True(t, model.EqualCommitments(subRootA, trie.SubstateRoot(tr, []byte(pa))))
+		})
+	}
+	for _, arity := range trie.AllPathArity {
+		runTest(arity, trie_blake2b.HashSize256)
+		runTest(arity, trie_blake2b.HashSize160)
+	}
+}
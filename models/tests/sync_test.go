@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/iotaledger/trie.go/trie_sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	genState := func(m trie.CommitmentModel, n int, optimizeKeyCommitments bool) (trie.KVStore, trie.KVStore, []byte) {
+		rnd := rand.New(rand.NewSource(1))
+		trieStore := trie.NewInMemoryKVStore()
+		valueStore := trie.NewInMemoryKVStore()
+		tr := trie.New(m, trieStore, valueStore, optimizeKeyCommitments)
+		for i := 0; i < n; i++ {
+			k := fmt.Sprintf("%d", rnd.Intn(10*n))
+			v := k
+			if i%2 == 0 {
+				v = fmt.Sprintf("value of the key %s", k)
+			}
+			tr.Update([]byte(k), []byte(v))
+			valueStore.Set([]byte(k), []byte(v))
+		}
+		// keys which are prefixes of other keys
+		for _, k := range []string{"", "1", "12", "123"} {
+			tr.Update([]byte(k), []byte(k+"+"))
+			valueStore.Set([]byte(k), []byte(k+"+"))
+		}
+		tr.Commit()
+		tr.PersistMutations(trieStore)
+		root := trie.RootCommitment(tr)
+		if root == nil {
+			return trieStore, valueStore, nil
+		}
+		return trieStore, valueStore, root.Bytes()
+	}
+	// runSync runs the client against the server, which serves responses modified by 'tamper'
+	runSync := func(m *trie_blake2b.CommitmentModel, trieStore, valueStore trie.KVReader, root []byte, maxKeys uint16,
+		tamper func(resp *trie_sync.ChunkResponse), optimizeKeyCommitments bool) (trie.KVStore, trie.KVStore, int, error) {
+		server := trie_sync.NewServer(m, trieStore, valueStore)
+		clientEnd, serverEnd := trie_sync.NewPipe()
+		go func() {
+			for {
+				data, err := serverEnd.Receive()
+				if err != nil {
+					return
+				}
+				req, err := trie_sync.ChunkRequestFromBytes(data)
+				if err != nil {
+					panic(err)
+				}
+				resp, err := server.Chunk(req)
+				if err != nil {
+					panic(err)
+				}
+				if tamper != nil {
+					tamper(resp)
+				}
+				if serverEnd.Send(resp.Bytes()) != nil {
+					return
+				}
+			}
+		}()
+		trieStoreBack := trie.NewInMemoryKVStore()
+		valueStoreBack := trie.NewInMemoryKVStore()
+		client := trie_sync.NewClient(m, root, clientEnd, trieStoreBack, valueStoreBack, optimizeKeyCommitments)
+		n, err := client.Sync(maxKeys)
+		_ = clientEnd.Close()
+		return trieStoreBack, valueStoreBack, n, err
+	}
+	runTest := func(m *trie_blake2b.CommitmentModel, optimizeKeyCommitments bool) {
+		t.Run("sync"+tn(m), func(t *testing.T) {
+			trieStore, valueStore, root := genState(m, 1000, optimizeKeyCommitments)
+			for _, maxKeys := range []uint16{1, 7, 100, 10000} {
+				trieStoreBack, valueStoreBack, n, err := runSync(m, trieStore, valueStore, root, maxKeys, nil, optimizeKeyCommitments)
+				require.NoError(t, err)
+				require.EqualValues(t, trie.NumEntries(valueStore), n)
+				require.EqualValues(t, trie.NumEntries(valueStore), trie.NumEntries(valueStoreBack))
+				require.True(t, bytes.Equal(root, trie.RootCommitment(trie.NewTrieReader(m, trieStoreBack, valueStoreBack)).Bytes()))
+			}
+			// over the transport, served by the server loop
+			server := trie_sync.NewServer(m, trieStore, valueStore)
+			clientEnd, serverEnd := trie_sync.NewPipe()
+			done := make(chan error)
+			go func() {
+				done <- server.Serve(serverEnd)
+			}()
+			client := trie_sync.NewClient(m, root, clientEnd, trie.NewInMemoryKVStore(), trie.NewInMemoryKVStore(), optimizeKeyCommitments)
+			n, err := client.Sync(50)
+			require.NoError(t, err)
+			require.EqualValues(t, trie.NumEntries(valueStore), n)
+			require.NoError(t, clientEnd.Close())
+			require.NoError(t, <-done)
+
+			// wrong root
+			wrongRoot := make([]byte, len(root))
+			_, _, _, err = runSync(m, trieStore, valueStore, wrongRoot, 100, nil, optimizeKeyCommitments)
+			require.True(t, errors.Is(err, trie_sync.ErrInvalidChunk))
+
+			tamperings := map[string]func(resp *trie_sync.ChunkResponse){
+				"omitted key": func(resp *trie_sync.ChunkResponse) {
+					if len(resp.Keys) > 2 {
+						resp.Keys = append(resp.Keys[:1], resp.Keys[2:]...)
+						resp.Values = append(resp.Values[:1], resp.Values[2:]...)
+					}
+				},
+				"injected key": func(resp *trie_sync.ChunkResponse) {
+					if len(resp.Keys) > 1 {
+						resp.Keys = append([][]byte{resp.Keys[0], trie.Concat(resp.Keys[0], byte(0))}, resp.Keys[1:]...)
+						resp.Values = append([][]byte{resp.Values[0], []byte("injected")}, resp.Values[1:]...)
+					}
+				},
+				"modified value": func(resp *trie_sync.ChunkResponse) {
+					if len(resp.Keys) > 0 {
+						resp.Values[0] = []byte("modified")
+					}
+				},
+				"cut chunk": func(resp *trie_sync.ChunkResponse) {
+					resp.Keys = resp.Keys[:len(resp.Keys)/2]
+					resp.Values = resp.Values[:len(resp.Values)/2]
+				},
+				"premature last": func(resp *trie_sync.ChunkResponse) {
+					resp.Last = true
+				},
+				"empty chunk": func(resp *trie_sync.ChunkResponse) {
+					resp.Keys, resp.Values = nil, nil
+				},
+			}
+			for name, tamper := range tamperings {
+				_, _, _, err = runSync(m, trieStore, valueStore, root, 100, tamper, optimizeKeyCommitments)
+				require.True(t, errors.Is(err, trie_sync.ErrInvalidChunk), "%s: %v", name, err)
+			}
+
+			// empty state
+			emptyStore := trie.NewInMemoryKVStore()
+			_, _, n, err = runSync(m, emptyStore, emptyStore, nil, 100, nil, optimizeKeyCommitments)
+			require.NoError(t, err)
+			require.EqualValues(t, 0, n)
+		})
+	}
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256), false)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256), false)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256), false)
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160), true)
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160), true)
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160, 10), false)
+}
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionedStore(t *testing.T) {
+	const numVersions = 10
+	runTest := func(m trie.CommitmentModel) {
+		t.Run("versioned"+tn(m), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			store := trie.NewInMemoryKVStore()
+			vs := trie.NewVersionedStore(store)
+			_, _, ok := vs.Versions()
+			require.False(t, ok)
+
+			tr := trie.New(m, vs.LatestTrieStore(), nil)
+			state := make(map[string]string)
+			states := make([][]string, 0)
+			for v := 0; v < numVersions; v++ {
+				for i := 0; i < 200; i++ {
+					k := fmt.Sprintf("%d", rnd.Intn(1000))
+					if rnd.Intn(3) == 0 {
+						tr.Update([]byte(k), nil)
+						delete(state, k)
+					} else {
+						val := fmt.Sprintf("%s-%d", k, v)
+						tr.Update([]byte(k), []byte(val))
+						state[k] = val
+					}
+				}
+				tr.Commit()
+				rootC := trie.RootCommitment(tr)
+				version := vs.Persist(tr)
+				require.EqualValues(t, v, version)
+
+				root, ok := vs.Root(version)
+				require.True(t, ok)
+				require.True(t, bytes.Equal(rootC.Bytes(), root))
+
+				states = append(states, sortedState(state))
+			}
+			checkVersion := func(v uint32) {
+				trieStore, ok := vs.TrieStore(v)
+				require.True(t, ok)
+				rdr := trie.NewTrieReader(m, trieStore, nil)
+				root, _ := vs.Root(v)
+				require.True(t, bytes.Equal(trie.RootCommitment(rdr).Bytes(), root))
+
+				keys := make([]string, 0)
+				rdr.Iterate(func(k []byte, c trie.TCommitment) bool {
+					keys = append(keys, string(k))
+					return true
+				})
+				require.EqualValues(t, states[v], keys)
+			}
+			for v := uint32(0); v < numVersions; v++ {
+				checkVersion(v)
+			}
+			sizeBefore := trie.NumEntries(store)
+			removed := vs.Prune(numVersions / 2)
+			require.True(t, removed > 0)
+			require.True(t, trie.NumEntries(store) < sizeBefore)
+			first, last, ok := vs.Versions()
+			require.True(t, ok)
+			require.EqualValues(t, numVersions/2, first)
+			require.EqualValues(t, numVersions-1, last)
+
+			_, ok = vs.TrieStore(numVersions/2 - 1)
+			require.False(t, ok)
+			for v := uint32(numVersions / 2); v < numVersions; v++ {
+				checkVersion(v)
+			}
+			// prune all except the last version. Only nodes of the last state remain
+			vs.Prune(numVersions - 1)
+			checkVersion(numVersions - 1)
+
+			numNodes := 0
+			trieStore, _ := vs.TrieStore(numVersions - 1)
+			rdr := trie.NewTrieReader(m, trieStore, nil)
+			countNodes(rdr, nil, &numNodes)
+			numRecords := 0
+			store.Iterate(func(k, _ []byte) bool {
+				// node records
+				if k[0] == 3 {
+					numRecords++
+				}
+				return true
+			})
+			require.EqualValues(t, numNodes, numRecords)
+
+			// the trie continues on top of the last version
+			tr.Update([]byte("new key"), []byte("new value"))
+			tr.Commit()
+			version := vs.Persist(tr)
+			require.EqualValues(t, numVersions, version)
+			checkVersion(numVersions - 1)
+		})
+	}
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize256))
+	runTest(trie_blake2b.New(trie.PathArity256, trie_blake2b.HashSize160))
+	runTest(trie_blake2b.New(trie.PathArity16, trie_blake2b.HashSize160))
+	runTest(trie_blake2b.New(trie.PathArity2, trie_blake2b.HashSize160))
+}
+
+func sortedState(state map[string]string) []string {
+	ret := make([]string, 0, len(state))
+	for k := range state {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func countNodes(tr trie.NodeStore, key []byte, counter *int) {
+	n, ok := tr.GetNode(key)
+	if !ok {
+		return
+	}
+	*counter++
+	for idx := range n.ChildCommitments() {
+		countNodes(tr, trie.Concat(key, n.PathFragment(), idx), counter)
+	}
+}
@@ -0,0 +1,307 @@
+package trie_blake2b
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/iotaledger/trie.go/models/trie_hash"
+	"github.com/iotaledger/trie.go/trie"
+)
+
+// MultiProof is a proof of inclusion or absence of several keys against the same root.
+// It is a sub-tree of the trie, a union of the proof paths of all keys. Each node of the trie
+// is included only once, so the upper nodes shared by many paths are not repeated
+type MultiProof struct {
+	PathArity trie.PathArity
+	HashSize  HashSize
+	// unpacked keys the proof is about
+	Keys [][]byte
+	// Root is nil if the trie is empty
+	Root *MultiProofNode
+}
+
+// MultiProofNode is a node of the MultiProof
+type MultiProofNode struct {
+	PathFragment []byte
+	Terminal     []byte
+	// Children commitments to the children which are not included in the proof
+	Children map[byte][]byte
+	// Next contains the children which are included in the proof
+	Next map[byte]*MultiProofNode
+}
+
+func MultiProofFromBytes(data []byte) (*MultiProof, error) {
+	ret := &MultiProof{}
+	rdr := bytes.NewReader(data)
+	if err := ret.Read(rdr); err != nil {
+		return nil, err
+	}
+	if rdr.Len() != 0 {
+		return nil, trie.ErrNotAllBytesConsumed
+	}
+	return ret, nil
+}
+
+func newMultiProofNode() *MultiProofNode {
+	return &MultiProofNode{
+		Children: make(map[byte][]byte),
+		Next:     make(map[byte]*MultiProofNode),
+	}
+}
+
+// MultiProof converts generic proof paths of all keys to one proof
+func (m *CommitmentModel) MultiProof(keys [][]byte, tr trie.NodeStore) *MultiProof {
+	ret := &MultiProof{
+		PathArity: tr.PathArity(),
+		HashSize:  m.hashSize,
+		Keys:      make([][]byte, len(keys)),
+	}
+	// nodes of the proof indexed by the trie node key
+	nodes := make(map[string]*MultiProofNode)
+	for i, key := range keys {
+		unpackedKey := trie.UnpackBytes(key, tr.PathArity())
+		ret.Keys[i] = unpackedKey
+		proofGeneric := trie.GetProofGeneric(tr, unpackedKey)
+		var prev *MultiProofNode
+		for _, k := range proofGeneric.Path {
+			n, ok := nodes[string(k)]
+			if !ok {
+				n = newMultiProofNode()
+				nodes[string(k)] = n
+				if len(k) == 0 {
+					ret.Root = n
+				}
+			}
+			if prev != nil {
+				prev.Next[k[len(k)-1]] = n
+			}
+			prev = n
+		}
+	}
+	for k, n := range nodes {
+		node, ok := tr.GetNode([]byte(k))
+		if !ok {
+			panic(fmt.Errorf("can't find node key '%x'", k))
+		}
+		n.PathFragment = node.PathFragment()
+		if node.Terminal() != nil {
+			n.Terminal = trie_hash.TerminalBytes(node.Terminal())
+		}
+		for idx, v := range node.ChildCommitments() {
+			if _, isNext := n.Next[idx]; isNext {
+				// commitment will come from the node in the proof
+				continue
+			}
+			n.Children[idx] = v.Bytes()
+		}
+	}
+	return ret
+}
+
+func (p *MultiProof) Bytes() []byte {
+	return trie.MustBytes(p)
+}
+
+func (p *MultiProof) Write(w io.Writer) error {
+	var err error
+	if err = trie.WriteByte(w, byte(p.PathArity)); err != nil {
+		return err
+	}
+	if err = trie.WriteByte(w, byte(p.HashSize)); err != nil {
+		return err
+	}
+	if err = trie.WriteUint16(w, uint16(len(p.Keys))); err != nil {
+		return err
+	}
+	for _, k := range p.Keys {
+		encodedKey, err := trie.EncodeUnpackedBytes(k, p.PathArity)
+		if err != nil {
+			return err
+		}
+		if err = trie.WriteBytes16(w, encodedKey); err != nil {
+			return err
+		}
+	}
+	if p.Root == nil {
+		return trie.WriteByte(w, 0)
+	}
+	if err = trie.WriteByte(w, 1); err != nil {
+		return err
+	}
+	return p.Root.Write(w, p.PathArity, p.HashSize)
+}
+
+func (p *MultiProof) Read(r io.Reader) error {
+	b, err := trie.ReadByte(r)
+	if err != nil {
+		return err
+	}
+	p.PathArity = trie.PathArity(b)
+
+	b, err = trie.ReadByte(r)
+	if err != nil {
+		return err
+	}
+	p.HashSize = HashSize(b)
+	if p.HashSize != HashSize256 && p.HashSize != HashSize160 {
+		return errors.New("wrong hash size")
+	}
+	var size uint16
+	if err = trie.ReadUint16(r, &size); err != nil {
+		return err
+	}
+	p.Keys = make([][]byte, size)
+	for i := range p.Keys {
+		var encodedKey []byte
+		if encodedKey, err = trie.ReadBytes16(r); err != nil {
+			return err
+		}
+		if p.Keys[i], err = trie.DecodeToUnpackedBytes(encodedKey, p.PathArity); err != nil {
+			return err
+		}
+	}
+	if b, err = trie.ReadByte(r); err != nil {
+		return err
+	}
+	p.Root = nil
+	switch b {
+	case 0:
+		return nil
+	case 1:
+		p.Root = newMultiProofNode()
+		return p.Root.Read(r, p.PathArity, p.HashSize)
+	}
+	return errors.New("wrong root flag")
+}
+
+// flags of the node are the same as flags of the ProofElement
+const (
+	hasTerminalValueFlag = 0x01
+	hasChildrenFlag      = 0x02
+	hasNextFlag          = 0x04
+)
+
+func childFlagsSize(arity trie.PathArity) int {
+	return (arity.NumChildren() + 7) / 8
+}
+
+func (n *MultiProofNode) Write(w io.Writer, arity trie.PathArity, sz HashSize) error {
+	encodedPathFragment, err := trie.EncodeUnpackedBytes(n.PathFragment, arity)
+	if err != nil {
+		return err
+	}
+	if err = trie.WriteBytes16(w, encodedPathFragment); err != nil {
+		return err
+	}
+	var smallFlags byte
+	if n.Terminal != nil {
+		smallFlags |= hasTerminalValueFlag
+	}
+	childrenFlags := make([]byte, childFlagsSize(arity))
+	for i := range n.Children {
+		childrenFlags[i/8] |= 0x1 << (i % 8)
+		smallFlags |= hasChildrenFlag
+	}
+	nextFlags := make([]byte, childFlagsSize(arity))
+	for i := range n.Next {
+		nextFlags[i/8] |= 0x1 << (i % 8)
+		smallFlags |= hasNextFlag
+	}
+	if err = trie.WriteByte(w, smallFlags); err != nil {
+		return err
+	}
+	if smallFlags&hasTerminalValueFlag != 0 {
+		if err = trie.WriteBytes8(w, n.Terminal); err != nil {
+			return err
+		}
+	}
+	if smallFlags&hasChildrenFlag != 0 {
+		if _, err = w.Write(childrenFlags); err != nil {
+			return err
+		}
+		for i := 0; i < arity.NumChildren(); i++ {
+			child, ok := n.Children[uint8(i)]
+			if !ok {
+				continue
+			}
+			if len(child) != int(sz) {
+				return fmt.Errorf("wrong data size. Expected %s, got %d", sz.String(), len(child))
+			}
+			if _, err = w.Write(child); err != nil {
+				return err
+			}
+		}
+	}
+	if smallFlags&hasNextFlag != 0 {
+		if _, err = w.Write(nextFlags); err != nil {
+			return err
+		}
+		for i := 0; i < arity.NumChildren(); i++ {
+			next, ok := n.Next[uint8(i)]
+			if !ok {
+				continue
+			}
+			if err = next.Write(w, arity, sz); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *MultiProofNode) Read(r io.Reader, arity trie.PathArity, sz HashSize) error {
+	var err error
+	var encodedPathFragment []byte
+	if encodedPathFragment, err = trie.ReadBytes16(r); err != nil {
+		return err
+	}
+	if n.PathFragment, err = trie.DecodeToUnpackedBytes(encodedPathFragment, arity); err != nil {
+		return err
+	}
+	var smallFlags byte
+	if smallFlags, err = trie.ReadByte(r); err != nil {
+		return err
+	}
+	if smallFlags&hasTerminalValueFlag != 0 {
+		if n.Terminal, err = trie.ReadBytes8(r); err != nil {
+			return err
+		}
+	} else {
+		n.Terminal = nil
+	}
+	n.Children = make(map[byte][]byte)
+	if smallFlags&hasChildrenFlag != 0 {
+		flags := make([]byte, childFlagsSize(arity))
+		if _, err = io.ReadFull(r, flags); err != nil {
+			return err
+		}
+		for i := 0; i < arity.NumChildren(); i++ {
+			ib := uint8(i)
+			if flags[i/8]&(0x1<<(i%8)) != 0 {
+				n.Children[ib] = make([]byte, sz)
+				if _, err = io.ReadFull(r, n.Children[ib]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	n.Next = make(map[byte]*MultiProofNode)
+	if smallFlags&hasNextFlag != 0 {
+		flags := make([]byte, childFlagsSize(arity))
+		if _, err = io.ReadFull(r, flags); err != nil {
+			return err
+		}
+		for i := 0; i < arity.NumChildren(); i++ {
+			ib := uint8(i)
+			if flags[i/8]&(0x1<<(i%8)) != 0 {
+				n.Next[ib] = newMultiProofNode()
+				if err = n.Next[ib].Read(r, arity, sz); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -3,26 +3,21 @@ package trie_blake2b
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
 
+	"github.com/iotaledger/trie.go/models/trie_hash"
 	"github.com/iotaledger/trie.go/trie"
 )
 
-// Proof blake2b 20 byte model-specific proof of inclusion
+// Proof blake2b model-specific proof of inclusion. It is trie_hash.Proof with the blake2b hasher of the hash size
 type Proof struct {
-	PathArity trie.PathArity
-	HashSize  HashSize
-	Key       []byte
-	Path      []*ProofElement
+	trie_hash.Proof
+	HashSize HashSize
 }
 
-type ProofElement struct {
-	PathFragment []byte
-	Children     map[byte][]byte
-	Terminal     []byte
-	ChildIndex   int
-}
+// ProofElement is trie_hash.ProofElement with the hash size of the blake2b model.
+// Elements of the proof path convert to it as (*ProofElement)(p.Path[i])
+type ProofElement trie_hash.ProofElement
 
 func ProofFromBytes(data []byte) (*Proof, error) {
 	ret := &Proof{}
@@ -38,225 +33,45 @@ func ProofFromBytes(data []byte) (*Proof, error) {
 
 // Proof converts generic proof path to the Merkle proof path
 func (m *CommitmentModel) Proof(key []byte, tr trie.NodeStore) *Proof {
-	unpackedKey := trie.UnpackBytes(key, tr.PathArity())
-	proofGeneric := trie.GetProofGeneric(tr, unpackedKey)
-	if proofGeneric == nil {
+	p := m.CommitmentModel.Proof(key, tr)
+	if p == nil {
 		return nil
 	}
-	ret := &Proof{
-		PathArity: tr.PathArity(),
-		HashSize:  m.hashSize,
-		Key:       proofGeneric.Key,
-		Path:      make([]*ProofElement, len(proofGeneric.Path)),
-	}
-	var elemKeyPosition int
-	var isLast bool
-	var childIndex int
-
-	for i, k := range proofGeneric.Path {
-		node, ok := tr.GetNode(k)
-		if !ok {
-			panic(fmt.Errorf("can't find node key '%x'", k))
-		}
-		isLast = i == len(proofGeneric.Path)-1
-		if !isLast {
-			elemKeyPosition += len(node.PathFragment())
-			childIndex = int(unpackedKey[elemKeyPosition])
-			elemKeyPosition++
-		} else {
-			switch proofGeneric.Ending {
-			case trie.EndingTerminal:
-				childIndex = m.arity.TerminalCommitmentIndex()
-			case trie.EndingExtend, trie.EndingSplit:
-				childIndex = m.arity.PathFragmentCommitmentIndex()
-			default:
-				panic("wrong ending code")
-			}
-		}
-		em := &ProofElement{
-			PathFragment: node.PathFragment(),
-			Children:     make(map[byte][]byte),
-			Terminal:     nil,
-			ChildIndex:   childIndex,
-		}
-		if node.Terminal() != nil {
-			em.Terminal = node.Terminal().(*terminalCommitment).bytes
-		}
-		for idx, v := range node.ChildCommitments() {
-			if int(idx) == childIndex {
-				// skipping the commitment which must come from the next child
-				continue
-			}
-			em.Children[idx] = v.(vectorCommitment)
-		}
-		ret.Path[i] = em
-	}
-	return ret
-}
-
-func (p *Proof) Bytes() []byte {
-	return trie.MustBytes(p)
-}
-
-func (p *Proof) Write(w io.Writer) error {
-	var err error
-	if err = trie.WriteByte(w, byte(p.PathArity)); err != nil {
-		return err
-	}
-	if err = trie.WriteByte(w, byte(p.HashSize)); err != nil {
-		return err
-	}
-	encodedKey, err := trie.EncodeUnpackedBytes(p.Key, p.PathArity)
-	if err != nil {
-		return err
-	}
-	if err = trie.WriteBytes16(w, encodedKey); err != nil {
-		return err
-	}
-	if err = trie.WriteUint16(w, uint16(len(p.Path))); err != nil {
-		return err
-	}
-	for _, e := range p.Path {
-		if err = e.Write(w, p.PathArity, p.HashSize); err != nil {
-			return err
-		}
+	return &Proof{
+		Proof:    *p,
+		HashSize: m.hashSize,
 	}
-	return nil
 }
 
+// Read reads the proof. The hasher is selected by the hash size of the serialized proof
 func (p *Proof) Read(r io.Reader) error {
-	b, err := trie.ReadByte(r)
-	if err != nil {
-		return err
-	}
-	p.PathArity = trie.PathArity(b)
-
-	b, err = trie.ReadByte(r)
-	if err != nil {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
 		return err
 	}
-	p.HashSize = HashSize(b)
-	if p.HashSize != HashSize256 && p.HashSize != HashSize160 {
+	sz := HashSize(header[1])
+	if sz != HashSize256 && sz != HashSize160 {
 		return errors.New("wrong hash size")
 	}
-
-	var encodedKey []byte
-	if encodedKey, err = trie.ReadBytes16(r); err != nil {
-		return err
-	}
-	if p.Key, err = trie.DecodeToUnpackedBytes(encodedKey, p.PathArity); err != nil {
-		return err
-	}
-	var size uint16
-	if err = trie.ReadUint16(r, &size); err != nil {
-		return err
-	}
-	p.Path = make([]*ProofElement, size)
-	for i := range p.Path {
-		p.Path[i] = &ProofElement{}
-		if err = p.Path[i].Read(r, p.PathArity, p.HashSize); err != nil {
-			return err
-		}
-	}
-	return nil
+	p.HashSize = sz
+	p.Hasher = sz.Hasher()
+	return p.Proof.Read(io.MultiReader(bytes.NewReader(header[:]), r))
 }
 
-const (
-	hasTerminalValueFlag = 0x01
-	hasChildrenFlag      = 0x02
-)
-
 func (e *ProofElement) Write(w io.Writer, arity trie.PathArity, sz HashSize) error {
-	encodedPathFragment, err := trie.EncodeUnpackedBytes(e.PathFragment, arity)
-	if err != nil {
-		return err
-	}
-	if err = trie.WriteBytes16(w, encodedPathFragment); err != nil {
-		return err
-	}
-	if err = trie.WriteUint16(w, uint16(e.ChildIndex)); err != nil {
-		return err
-	}
-	var smallFlags byte
-	if e.Terminal != nil {
-		smallFlags = hasTerminalValueFlag
-	}
-	// compress children flags 32 bytes (if any)
-	var flags [32]byte
-	for i := range e.Children {
-		flags[i/8] |= 0x1 << (i % 8)
-		smallFlags |= hasChildrenFlag
-	}
-	if err := trie.WriteByte(w, smallFlags); err != nil {
-		return err
-	}
-	// write terminal commitment if any
-	if smallFlags&hasTerminalValueFlag != 0 {
-		if err = trie.WriteBytes8(w, e.Terminal); err != nil {
-			return err
-		}
-	}
-	// write child commitments if any
-	if smallFlags&hasChildrenFlag != 0 {
-		if _, err = w.Write(flags[:]); err != nil {
-			return err
-		}
-		for i := 0; i < arity.VectorLength(); i++ {
-			child, ok := e.Children[uint8(i)]
-			if !ok {
-				continue
-			}
-			if len(child) != int(sz) {
-				return fmt.Errorf("wrong data size. Expected %s, got %d", sz.String(), len(child))
-			}
-			if _, err = w.Write(child); err != nil {
-				return err
-			}
-		}
-	}
-	return nil
+	return (*trie_hash.ProofElement)(e).Write(w, arity, int(sz))
 }
 
 func (e *ProofElement) Read(r io.Reader, arity trie.PathArity, sz HashSize) error {
-	var err error
-	var encodedPathFragment []byte
-	if encodedPathFragment, err = trie.ReadBytes16(r); err != nil {
-		return err
-	}
-	if e.PathFragment, err = trie.DecodeToUnpackedBytes(encodedPathFragment, arity); err != nil {
-		return err
-	}
-	var idx uint16
-	if err := trie.ReadUint16(r, &idx); err != nil {
-		return err
-	}
-	e.ChildIndex = int(idx)
-	var smallFlags byte
-	if smallFlags, err = trie.ReadByte(r); err != nil {
-		return err
-	}
-	if smallFlags&hasTerminalValueFlag != 0 {
-		if e.Terminal, err = trie.ReadBytes8(r); err != nil {
-			return err
-		}
-	} else {
-		e.Terminal = nil
-	}
-	e.Children = make(map[byte][]byte)
-	if smallFlags&hasChildrenFlag != 0 {
-		var flags [32]byte
-		if _, err = r.Read(flags[:]); err != nil {
-			return err
-		}
-		for i := 0; i < arity.NumChildren(); i++ {
-			ib := uint8(i)
-			if flags[i/8]&(0x1<<(i%8)) != 0 {
-				e.Children[ib] = make([]byte, sz)
-				if _, err = r.Read(e.Children[ib]); err != nil {
-					return err
-				}
-			}
-		}
-	}
-	return nil
+	return (*trie_hash.ProofElement)(e).Read(r, arity, int(sz))
+}
+
+// SubstateProof returns proof which links commitment of the sub-state of all keys with the prefix to the root.
+// The last element of the proof path is the node covering the prefix, so its commitment is the sub-state root
+// (see trie.SubstateRoot). If there are no keys with the prefix, the proof is a proof of absence of the sub-state.
+// The prefix must be aligned to the path arity (see trie.IsAlignedPrefix)
+func (m *CommitmentModel) SubstateProof(prefix []byte, tr trie.NodeStore) *Proof {
+	trie.Assert(trie.IsAlignedPrefix(prefix, tr.PathArity()), "trie_blake2b::SubstateProof: prefix is not aligned to %s",
+		tr.PathArity().String())
+	return m.Proof(prefix, tr)
 }
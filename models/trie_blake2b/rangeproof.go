@@ -0,0 +1,169 @@
+package trie_blake2b
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/iotaledger/trie.go/trie"
+)
+
+// RangeProof is a proof that the list of keys is exactly the set of all keys of the trie between Start and End,
+// inclusive. It contains the boundary paths of Start and End and all nodes in between,
+// so any key omitted from the list or injected into it is detected by the verifier.
+// Only nodes outside the range are represented by their commitments
+type RangeProof struct {
+	PathArity trie.PathArity
+	HashSize  HashSize
+	// unpacked bounds of the range. Nil End means the range is not bounded from above
+	Start []byte
+	End   []byte
+	// Root is nil if the trie is empty
+	Root *MultiProofNode
+}
+
+func RangeProofFromBytes(data []byte) (*RangeProof, error) {
+	ret := &RangeProof{}
+	rdr := bytes.NewReader(data)
+	if err := ret.Read(rdr); err != nil {
+		return nil, err
+	}
+	if rdr.Len() != 0 {
+		return nil, trie.ErrNotAllBytesConsumed
+	}
+	return ret, nil
+}
+
+// RangeProof creates proof of all keys between start and end, inclusive. Nil end means all keys from start
+func (m *CommitmentModel) RangeProof(start, end []byte, tr trie.NodeStore) *RangeProof {
+	keys := [][]byte{start}
+	if end != nil {
+		keys = append(keys, end)
+	}
+	trie.IterateFrom(tr, start, func(k []byte, _ trie.TCommitment) bool {
+		if end != nil && bytes.Compare(k, end) > 0 {
+			return false
+		}
+		keys = append(keys, k)
+		return true
+	})
+	ret := &RangeProof{
+		PathArity: tr.PathArity(),
+		HashSize:  m.hashSize,
+		Start:     trie.UnpackBytes(start, tr.PathArity()),
+		// the proof consists of the paths of bounds and of all keys in between
+		Root: m.MultiProof(keys, tr).Root,
+	}
+	if end != nil {
+		ret.End = trie.UnpackBytes(end, tr.PathArity())
+	}
+	return ret
+}
+
+func (p *RangeProof) Bytes() []byte {
+	return trie.MustBytes(p)
+}
+
+func (p *RangeProof) Write(w io.Writer) error {
+	var err error
+	if err = trie.WriteByte(w, byte(p.PathArity)); err != nil {
+		return err
+	}
+	if err = trie.WriteByte(w, byte(p.HashSize)); err != nil {
+		return err
+	}
+	encodedStart, err := trie.EncodeUnpackedBytes(p.Start, p.PathArity)
+	if err != nil {
+		return err
+	}
+	if err = trie.WriteBytes16(w, encodedStart); err != nil {
+		return err
+	}
+	if p.End == nil {
+		if err = trie.WriteByte(w, 0); err != nil {
+			return err
+		}
+	} else {
+		if err = trie.WriteByte(w, 1); err != nil {
+			return err
+		}
+		encodedEnd, err := trie.EncodeUnpackedBytes(p.End, p.PathArity)
+		if err != nil {
+			return err
+		}
+		if err = trie.WriteBytes16(w, encodedEnd); err != nil {
+			return err
+		}
+	}
+	if p.Root == nil {
+		return trie.WriteByte(w, 0)
+	}
+	if err = trie.WriteByte(w, 1); err != nil {
+		return err
+	}
+	return p.Root.Write(w, p.PathArity, p.HashSize)
+}
+
+func (p *RangeProof) Read(r io.Reader) error {
+	b, err := trie.ReadByte(r)
+	if err != nil {
+		return err
+	}
+	p.PathArity = trie.PathArity(b)
+
+	b, err = trie.ReadByte(r)
+	if err != nil {
+		return err
+	}
+	p.HashSize = HashSize(b)
+	if p.HashSize != HashSize256 && p.HashSize != HashSize160 {
+		return errors.New("wrong hash size")
+	}
+	encoded, err := trie.ReadBytes16(r)
+	if err != nil {
+		return err
+	}
+	if p.Start, err = trie.DecodeToUnpackedBytes(encoded, p.PathArity); err != nil {
+		return err
+	}
+	if b, err = trie.ReadByte(r); err != nil {
+		return err
+	}
+	p.End = nil
+	switch b {
+	case 0:
+	case 1:
+		if encoded, err = trie.ReadBytes16(r); err != nil {
+			return err
+		}
+		if p.End, err = trie.DecodeToUnpackedBytes(encoded, p.PathArity); err != nil {
+			return err
+		}
+		if p.End == nil {
+			p.End = []byte{}
+		}
+	default:
+		return errors.New("wrong end flag")
+	}
+	if b, err = trie.ReadByte(r); err != nil {
+		return err
+	}
+	p.Root = nil
+	switch b {
+	case 0:
+		return nil
+	case 1:
+		p.Root = newMultiProofNode()
+		return p.Root.Read(r, p.PathArity, p.HashSize)
+	}
+	return errors.New("wrong root flag")
+}
+
+func (p *RangeProof) String() string {
+	end := "<none>"
+	if p.End != nil {
+		end = fmt.Sprintf("%x", p.End)
+	}
+	return fmt.Sprintf("RangeProof( %s, %s, start: %x, end: %s )", p.PathArity, p.HashSize, p.Start, end)
+}
@@ -0,0 +1,138 @@
+package trie_blake2b_verify
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"golang.org/x/xerrors"
+)
+
+// ValidateMulti checks the multi-key proof against the provided root commitment.
+// It also checks that the proof contains enough nodes to prove inclusion or absence of each key
+func ValidateMulti(p *trie_blake2b.MultiProof, rootBytes []byte) error {
+	if p.Root == nil {
+		if len(rootBytes) != 0 {
+			return xerrors.New("proof is empty")
+		}
+		return nil
+	}
+	c, err := hashMultiProofNode(p.Root, p.PathArity, p.HashSize)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(c, rootBytes) {
+		return xerrors.New("invalid proof: commitment not equal to the root")
+	}
+	for _, key := range p.Keys {
+		if _, err = terminalInMultiProof(p, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MustKeysWithTerminals returns keys and terminal commitments the multi-key proof is about.
+// Terminal commitment of the key is nil if the proof is a proof of absence of the key.
+// It does not verify the proof, so this function should be used only after ValidateMulti()
+func MustKeysWithTerminals(p *trie_blake2b.MultiProof) ([][]byte, [][]byte) {
+	terminals := make([][]byte, len(p.Keys))
+	for i, key := range p.Keys {
+		t, err := terminalInMultiProof(p, key)
+		if err != nil {
+			panic(err)
+		}
+		terminals[i] = t
+	}
+	return p.Keys, terminals
+}
+
+// ValidateMultiWithValues checks the multi-key proof and checks if the proof commits to the specific values
+// of all keys. Nil value means the key must be absent in the state
+func ValidateMultiWithValues(p *trie_blake2b.MultiProof, rootBytes []byte, values [][]byte) error {
+	if len(values) != len(p.Keys) {
+		return xerrors.New("number of values is not equal to the number of keys")
+	}
+	if err := ValidateMulti(p, rootBytes); err != nil {
+		return err
+	}
+	_, terminals := MustKeysWithTerminals(p)
+	for i := range values {
+		if len(values[i]) == 0 {
+			if terminals[i] != nil {
+				return fmt.Errorf("key #%d is present in the state", i)
+			}
+			continue
+		}
+		if terminals[i] == nil {
+			return fmt.Errorf("key #%d is not present in the state", i)
+		}
+		if !bytes.Equal(trie_blake2b.CommitToDataRaw(values[i], p.HashSize), terminals[i]) {
+			return fmt.Errorf("key #%d does not correspond to the given value", i)
+		}
+	}
+	return nil
+}
+
+// terminalInMultiProof follows the unpacked key in the proof. Returns terminal commitment of the key or nil
+// if the proof proves absence of the key. Returns error if the proof is not complete for the key
+func terminalInMultiProof(p *trie_blake2b.MultiProof, key []byte) ([]byte, error) {
+	n := p.Root
+	if n == nil {
+		return nil, nil
+	}
+	keyIdx := 0
+	for {
+		tail := key[keyIdx:]
+		if bytes.Equal(tail, n.PathFragment) {
+			if len(n.Terminal) == 0 {
+				return nil, nil
+			}
+			return n.Terminal, nil
+		}
+		if !bytes.HasPrefix(tail, n.PathFragment) {
+			// path fragment diverges from the key
+			return nil, nil
+		}
+		childIndex := tail[len(n.PathFragment)]
+		next, ok := n.Next[childIndex]
+		if !ok {
+			if _, ok = n.Children[childIndex]; ok {
+				return nil, fmt.Errorf("wrong proof: the proof does not contain path of the key '%x'", key)
+			}
+			// empty child slot
+			return nil, nil
+		}
+		keyIdx += len(n.PathFragment) + 1
+		n = next
+	}
+}
+
+func hashMultiProofNode(n *trie_blake2b.MultiProofNode, arity trie.PathArity, sz trie_blake2b.HashSize) ([]byte, error) {
+	hashes := make([][]byte, arity.VectorLength())
+	for idx, c := range n.Children {
+		if !arity.IsChildIndex(int(idx)) {
+			return nil, fmt.Errorf("wrong proof: wrong child index %d", idx)
+		}
+		hashes[idx] = c
+	}
+	for idx, next := range n.Next {
+		if !arity.IsChildIndex(int(idx)) {
+			return nil, fmt.Errorf("wrong proof: wrong child index %d", idx)
+		}
+		if _, ok := n.Children[idx]; ok {
+			return nil, fmt.Errorf("wrong proof: unexpected commitment at child index %d", idx)
+		}
+		c, err := hashMultiProofNode(next, arity, sz)
+		if err != nil {
+			return nil, err
+		}
+		hashes[idx] = c
+	}
+	if len(n.Terminal) > 0 {
+		hashes[arity.TerminalCommitmentIndex()] = n.Terminal
+	}
+	hashes[arity.PathFragmentCommitmentIndex()] = trie_blake2b.CommitToDataRaw(n.PathFragment, sz)
+	return trie_blake2b.HashTheVector(hashes, arity, sz), nil
+}
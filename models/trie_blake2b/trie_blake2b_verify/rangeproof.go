@@ -0,0 +1,121 @@
+package trie_blake2b_verify
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+	"golang.org/x/xerrors"
+)
+
+// ValidateRange checks the range proof against the provided root commitment and checks if keys with values
+// are exactly all key/value pairs committed in the trie between the bounds of the proof, in ascending order of keys.
+// Keys are packed. The value equal to its key is accepted also if the trie commits to the unpacked key instead
+// of the value, i.e. the trie uses key commitment optimization
+func ValidateRange(p *trie_blake2b.RangeProof, rootBytes []byte, keys, values [][]byte) error {
+	if len(keys) != len(values) {
+		return xerrors.New("number of values is not equal to the number of keys")
+	}
+	if p.End != nil && bytes.Compare(p.Start, p.End) > 0 {
+		return xerrors.New("wrong range: start is greater than end")
+	}
+	if p.Root == nil {
+		if len(rootBytes) != 0 {
+			return xerrors.New("proof is empty")
+		}
+		if len(keys) != 0 {
+			return xerrors.New("keys are not present in the empty state")
+		}
+		return nil
+	}
+	c, err := hashMultiProofNode(p.Root, p.PathArity, p.HashSize)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(c, rootBytes) {
+		return xerrors.New("invalid proof: commitment not equal to the root")
+	}
+	unpackedKeys, terminals, err := terminalsInRange(p)
+	if err != nil {
+		return err
+	}
+	if len(unpackedKeys) != len(keys) {
+		return fmt.Errorf("expected %d keys in the range, got %d", len(unpackedKeys), len(keys))
+	}
+	for i := range keys {
+		key := trie.UnpackBytes(keys[i], p.PathArity)
+		if !bytes.Equal(key, unpackedKeys[i]) {
+			return fmt.Errorf("key #%d '%x' is not expected in the range", i, keys[i])
+		}
+		if bytes.Equal(trie_blake2b.CommitToDataRaw(values[i], p.HashSize), terminals[i]) {
+			continue
+		}
+		if !bytes.Equal(keys[i], values[i]) || !bytes.Equal(trie_blake2b.CommitToDataRaw(key, p.HashSize), terminals[i]) {
+			return fmt.Errorf("key #%d does not correspond to the given value", i)
+		}
+	}
+	return nil
+}
+
+// MustKeysWithTerminalsInRange returns unpacked keys and terminal commitments of all keys in the range of the proof.
+// It does not verify the proof, so this function should be used only after ValidateRange()
+func MustKeysWithTerminalsInRange(p *trie_blake2b.RangeProof) ([][]byte, [][]byte) {
+	keys, terminals, err := terminalsInRange(p)
+	if err != nil {
+		panic(err)
+	}
+	return keys, terminals
+}
+
+// terminalsInRange collects terminals of the proof in the range in ascending order of keys.
+// Returns error if the proof does not contain some subtree with keys in the range
+func terminalsInRange(p *trie_blake2b.RangeProof) ([][]byte, [][]byte, error) {
+	keys := make([][]byte, 0)
+	terminals := make([][]byte, 0)
+	if p.Root == nil {
+		return keys, terminals, nil
+	}
+	var collect func(n *trie_blake2b.MultiProofNode, key []byte) error
+	collect = func(n *trie_blake2b.MultiProofNode, key []byte) error {
+		full := trie.Concat(key, n.PathFragment)
+		if len(n.Terminal) > 0 && inRange(p, full) {
+			keys = append(keys, full)
+			terminals = append(terminals, n.Terminal)
+		}
+		for i := range n.Children {
+			if mayIntersectRange(p, trie.Concat(full, i)) {
+				return fmt.Errorf("wrong proof: the proof does not contain all nodes of the range")
+			}
+		}
+		indices := make([]int, 0, len(n.Next))
+		for i := range n.Next {
+			indices = append(indices, int(i))
+		}
+		sort.Ints(indices)
+		for _, i := range indices {
+			if err := collect(n.Next[byte(i)], trie.Concat(full, byte(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := collect(p.Root, nil); err != nil {
+		return nil, nil, err
+	}
+	return keys, terminals, nil
+}
+
+func inRange(p *trie_blake2b.RangeProof, unpackedKey []byte) bool {
+	return bytes.Compare(unpackedKey, p.Start) >= 0 && (p.End == nil || bytes.Compare(unpackedKey, p.End) <= 0)
+}
+
+// mayIntersectRange returns true if some keys with the unpacked prefix may be in the range
+func mayIntersectRange(p *trie_blake2b.RangeProof, unpackedPrefix []byte) bool {
+	if bytes.Compare(unpackedPrefix, p.Start) < 0 && !bytes.HasPrefix(p.Start, unpackedPrefix) {
+		// all keys with the prefix are less than the start
+		return false
+	}
+	return p.End == nil || bytes.Compare(unpackedPrefix, p.End) <= 0
+}
@@ -0,0 +1,74 @@
+package trie_blake2b_verify
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"golang.org/x/xerrors"
+)
+
+// ValidateSubstate checks the sub-state proof against the provided root commitment and checks if
+// subRootBytes is the commitment to the sub-state of all keys with the prefix the proof is about.
+// Empty subRootBytes means the proof must prove there are no keys with the prefix in the state
+func ValidateSubstate(p *trie_blake2b.Proof, rootBytes, subRootBytes []byte) error {
+	if err := Validate(p, rootBytes); err != nil {
+		return err
+	}
+	subRoot, err := substateRoot(p)
+	if err != nil {
+		return err
+	}
+	if len(subRootBytes) == 0 {
+		if subRoot != nil {
+			return xerrors.New("sub-state is present in the state")
+		}
+		return nil
+	}
+	if subRoot == nil {
+		return xerrors.New("sub-state is not present in the state")
+	}
+	if !bytes.Equal(subRoot, subRootBytes) {
+		return xerrors.New("invalid proof: commitment not equal to the sub-state root")
+	}
+	return nil
+}
+
+// MustSubstateRoot returns commitment to the sub-state the proof is about or nil if the proof is proof of absence.
+// It does not verify the proof, so this function should be used only after Validate()
+func MustSubstateRoot(p *trie_blake2b.Proof) []byte {
+	ret, err := substateRoot(p)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// substateRoot returns commitment of the last node in the proof if the node covers the prefix
+func substateRoot(p *trie_blake2b.Proof) ([]byte, error) {
+	if len(p.Path) == 0 {
+		return nil, nil
+	}
+	keyIdx := 0
+	for _, e := range p.Path[:len(p.Path)-1] {
+		keyIdx += len(e.PathFragment) + 1
+	}
+	if keyIdx > len(p.Key) {
+		return nil, xerrors.New("wrong proof: proof path out of key bounds")
+	}
+	last := p.Path[len(p.Path)-1]
+	tail := p.Key[keyIdx:]
+	switch {
+	case bytes.HasPrefix(last.PathFragment, tail):
+		// all keys of the node have the prefix
+		return CommitmentToTheTerminalNode(p), nil
+	case bytes.HasPrefix(tail, last.PathFragment):
+		// the prefix continues below the node, so the child must be absent
+		childIndex := tail[len(last.PathFragment)]
+		if _, ok := last.Children[childIndex]; ok {
+			return nil, fmt.Errorf("wrong proof: proof path does not end at the sub-state. Child index %d", childIndex)
+		}
+	}
+	// the path fragment diverges from the prefix
+	return nil, nil
+}
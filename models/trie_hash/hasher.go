@@ -0,0 +1,80 @@
+package trie_hash
+
+import (
+	"crypto/sha256"
+
+	"github.com/iotaledger/trie.go/trie"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hasher is a hash function used by the CommitmentModel
+type Hasher interface {
+	// Hash returns hash of the data, Size() bytes long
+	Hash(data []byte) []byte
+	// Size is the size of the hash in bytes. It can't be bigger than 32
+	Size() int
+	// Name is a short name of the hash function, used in the ShortName of the model
+	Name() string
+}
+
+// MaxHashSize is the maximum size of the hash supported by the CommitmentModel
+const MaxHashSize = 32
+
+type hasher struct {
+	name    string
+	size    int
+	hashFun func(data []byte) []byte
+}
+
+// NewHasher creates a Hasher from the hash function, e.g. a Poseidon-friendly hash
+func NewHasher(name string, size int, hashFun func(data []byte) []byte) Hasher {
+	trie.Assert(size > 0 && size <= MaxHashSize, "trie_hash::NewHasher: wrong hash size %d", size)
+	return &hasher{
+		name:    name,
+		size:    size,
+		hashFun: hashFun,
+	}
+}
+
+func (h *hasher) Hash(data []byte) []byte {
+	return h.hashFun(data)
+}
+
+func (h *hasher) Size() int {
+	return h.size
+}
+
+func (h *hasher) Name() string {
+	return h.name
+}
+
+var (
+	// HasherBlake2b160 is the hasher of trie_blake2b with HashSize160
+	HasherBlake2b160 = NewHasher("b2b", 20, func(data []byte) []byte {
+		ret := trie.Blake2b160(data)
+		return ret[:]
+	})
+	// HasherBlake2b256 is the hasher of trie_blake2b with HashSize256
+	HasherBlake2b256 = NewHasher("b2b", 32, func(data []byte) []byte {
+		ret := blake2b.Sum256(data)
+		return ret[:]
+	})
+	HasherSHA256 = NewHasher("sha256", 32, func(data []byte) []byte {
+		ret := sha256.Sum256(data)
+		return ret[:]
+	})
+	HasherSHA3 = NewHasher("sha3", 32, func(data []byte) []byte {
+		ret := sha3.Sum256(data)
+		return ret[:]
+	})
+	// HasherKeccak256 is the legacy Keccak-256, used by Ethereum
+	HasherKeccak256 = NewHasher("keccak", 32, func(data []byte) []byte {
+		h := sha3.NewLegacyKeccak256()
+		h.Write(data)
+		return h.Sum(nil)
+	})
+)
+
+// AllHashers are hashers implemented in the package
+var AllHashers = []Hasher{HasherBlake2b160, HasherBlake2b256, HasherSHA256, HasherSHA3, HasherKeccak256}
@@ -0,0 +1,284 @@
+// Package trie_hash implements trie.CommitmentModel based on the pluggable hash function.
+// trie_blake2b is the model with blake2b hashers
+package trie_hash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/iotaledger/trie.go/trie"
+)
+
+// terminalCommitment commits to the data of arbitrary size.
+// if isCostlyCommitment == true, len(bytes) is the hash size
+// otherwise it is not hashed value, len(bytes) <= hash size
+type terminalCommitment struct {
+	bytes              []byte
+	isCostlyCommitment bool
+}
+
+// vectorCommitment is a hash of the vector elements
+type vectorCommitment []byte
+
+// CommitmentModel provides commitment model implementation for the 256+ trie
+type CommitmentModel struct {
+	hasher                         Hasher
+	arity                          trie.PathArity
+	valueSizeOptimizationThreshold int
+}
+
+// New creates new CommitmentModel with the hash function.
+// Parameter valueSizeOptimizationThreshold has the same meaning as in trie_blake2b.New
+func New(arity trie.PathArity, hasher Hasher, valueSizeOptimizationThreshold ...int) *CommitmentModel {
+	trie.Assert(hasher.Size() > 0 && hasher.Size() <= MaxHashSize, "trie_hash::New: wrong hash size %d", hasher.Size())
+	t := 0
+	if len(valueSizeOptimizationThreshold) > 0 {
+		t = valueSizeOptimizationThreshold[0]
+	}
+	return &CommitmentModel{
+		hasher:                         hasher,
+		arity:                          arity,
+		valueSizeOptimizationThreshold: t,
+	}
+}
+
+func (m *CommitmentModel) PathArity() trie.PathArity {
+	return m.arity
+}
+
+func (m *CommitmentModel) Hasher() Hasher {
+	return m.hasher
+}
+
+func (m *CommitmentModel) EqualCommitments(c1, c2 trie.Serializable) bool {
+	if equals, conclusive := trie.CheckNils(c1, c2); conclusive {
+		return equals
+	}
+	// both not nils
+	if t1, ok1 := c1.(*terminalCommitment); ok1 {
+		if t2, ok2 := c2.(*terminalCommitment); ok2 {
+			return bytes.Equal(t1.bytes, t2.bytes)
+		}
+	}
+	if v1, ok1 := c1.(vectorCommitment); ok1 {
+		if v2, ok2 := c2.(vectorCommitment); ok2 {
+			return bytes.Equal(v1, v2)
+		}
+	}
+	return false
+}
+
+// UpdateNodeCommitment computes update to the node data and, optionally, updates existing commitment
+// For the hash commitment delta just means computing the hash of data
+func (m *CommitmentModel) UpdateNodeCommitment(mutate *trie.NodeData, childUpdates map[byte]trie.VCommitment, _ bool, newTerminalUpdate trie.TCommitment, update *trie.VCommitment) {
+	deleted := make([]byte, 0, 256)
+	for i, upd := range childUpdates {
+		mutate.ChildCommitments[i] = upd
+		if upd == nil {
+			// if update == nil, it means child commitment must be removed
+			deleted = append(deleted, i)
+		}
+	}
+	for _, i := range deleted {
+		delete(mutate.ChildCommitments, i)
+	}
+	mutate.Terminal = newTerminalUpdate // for hash commitment just replace
+	if len(mutate.ChildCommitments) == 0 && mutate.Terminal == nil {
+		return
+	}
+	if update != nil {
+		*update = (vectorCommitment)(HashTheVector(m.makeHashVector(mutate), m.arity, m.hasher))
+	}
+}
+
+// CalcNodeCommitment computes commitment of the node
+func (m *CommitmentModel) CalcNodeCommitment(par *trie.NodeData) trie.VCommitment {
+	if len(par.ChildCommitments) == 0 && par.Terminal == nil {
+		return nil
+	}
+	return vectorCommitment(HashTheVector(m.makeHashVector(par), m.arity, m.hasher))
+}
+
+func (m *CommitmentModel) CommitToData(data []byte) trie.TCommitment {
+	if len(data) == 0 {
+		// empty slice -> no data (deleted)
+		return nil
+	}
+	return &terminalCommitment{
+		bytes:              CommitToDataRaw(data, m.hasher),
+		isCostlyCommitment: len(data) > m.valueSizeOptimizationThreshold,
+	}
+}
+
+func (m *CommitmentModel) Description() string {
+	return fmt.Sprintf("trie commitment model implementation based on %s %d bytes hash, arity: %s, terminal optimization threshold: %d",
+		m.hasher.Name(), m.hasher.Size(), m.arity, m.valueSizeOptimizationThreshold)
+}
+
+// ShortName is the same as in trie_blake2b for blake2b hashers
+func (m *CommitmentModel) ShortName() string {
+	return fmt.Sprintf("%s_%s_HashSize(%d)", m.hasher.Name(), m.arity, m.hasher.Size()*8)
+}
+
+// NewTerminalCommitment creates empty terminal commitment
+func (m *CommitmentModel) NewTerminalCommitment() trie.TCommitment {
+	return &terminalCommitment{
+		bytes: make([]byte, 0, m.hasher.Size()),
+	}
+}
+
+// NewVectorCommitment create empty vector commitment
+func (m *CommitmentModel) NewVectorCommitment() trie.VCommitment {
+	return vectorCommitment(make([]byte, m.hasher.Size()))
+}
+
+func (m *CommitmentModel) ForceStoreTerminalWithNode(c trie.TCommitment) bool {
+	return c.(*terminalCommitment).isCostlyCommitment
+}
+
+// TerminalBytes returns the committed data or its hash, i.e. the terminal element of the node vector
+func TerminalBytes(c trie.TCommitment) []byte {
+	return c.(*terminalCommitment).bytes
+}
+
+// CommitToDataRaw commits to data. Data not longer than the hash is committed as is
+func CommitToDataRaw(data []byte, h Hasher) []byte {
+	if len(data) <= h.Size() {
+		ret := make([]byte, len(data))
+		copy(ret, data)
+		return ret
+	}
+	return h.Hash(data)
+}
+
+// makeHashVector makes the node vector to be hashed. Missing children are nil
+func (m *CommitmentModel) makeHashVector(nodeData *trie.NodeData) [][]byte {
+	hashes := make([][]byte, m.arity.VectorLength())
+	for i, c := range nodeData.ChildCommitments {
+		trie.Assert(int(i) < m.arity.VectorLength(), "int(i)<m.arity.VectorLength()")
+		hashes[i] = c.Bytes()
+	}
+	if nodeData.Terminal != nil {
+		hashes[m.arity.TerminalCommitmentIndex()] = nodeData.Terminal.(*terminalCommitment).bytes
+	}
+	hashes[m.arity.PathFragmentCommitmentIndex()] = CommitToDataRaw(nodeData.PathFragment, m.hasher)
+	return hashes
+}
+
+// HashTheVector hashes the vector, each element is padded to the hash size + 1 bytes
+func HashTheVector(hashes [][]byte, arity trie.PathArity, h Hasher) []byte {
+	msz := h.Size() + 1
+	buf := make([]byte, arity.VectorLength()*msz)
+	for i, c := range hashes {
+		if c == nil {
+			continue
+		}
+		pos := i * msz
+		copy(buf[pos:pos+msz], c)
+	}
+	return h.Hash(buf)
+}
+
+// *vectorCommitment implements trie_go.VCommitment
+var _ trie.VCommitment = &vectorCommitment{}
+
+func (v vectorCommitment) Bytes() []byte {
+	return trie.MustBytes(v)
+}
+
+func (v vectorCommitment) Read(r io.Reader) error {
+	_, err := r.Read(v)
+	return err
+}
+
+func (v vectorCommitment) Write(w io.Writer) error {
+	_, err := w.Write(v)
+	return err
+}
+
+func (v vectorCommitment) String() string {
+	return hex.EncodeToString(v)
+}
+
+func (v vectorCommitment) Clone() trie.VCommitment {
+	if len(v) == 0 {
+		return nil
+	}
+	ret := make([]byte, len(v))
+	copy(ret, v)
+	return vectorCommitment(ret)
+}
+
+func (v vectorCommitment) Update(delta trie.VCommitment) {
+	m, ok := delta.(vectorCommitment)
+	if !ok {
+		panic("hash commitment expected")
+	}
+	copy(v, m)
+}
+
+// *terminalCommitment implements trie_go.TCommitment
+var _ trie.TCommitment = &terminalCommitment{}
+
+const (
+	sizeMask             = uint8(0x3F)
+	costlyCommitmentMask = ^sizeMask
+)
+
+func (t *terminalCommitment) Write(w io.Writer) error {
+	trie.Assert(len(t.bytes) <= MaxHashSize, "len(t.bytes) <= MaxHashSize")
+	l := byte(len(t.bytes))
+	if t.isCostlyCommitment {
+		l |= costlyCommitmentMask
+	}
+	if err := trie.WriteByte(w, l); err != nil {
+		return err
+	}
+	_, err := w.Write(t.bytes)
+	return err
+}
+
+func (t *terminalCommitment) Read(r io.Reader) error {
+	var err error
+	var l byte
+	if l, err = trie.ReadByte(r); err != nil {
+		return err
+	}
+	t.isCostlyCommitment = (l & costlyCommitmentMask) != 0
+	l &= sizeMask
+
+	if l > MaxHashSize {
+		return fmt.Errorf("wrong data size")
+	}
+	if l > 0 {
+		t.bytes = make([]byte, l)
+
+		n, err := r.Read(t.bytes)
+		if err != nil {
+			return err
+		}
+		if n != int(l) {
+			return errors.New("bad data length")
+		}
+	}
+	return nil
+}
+
+func (t *terminalCommitment) Bytes() []byte {
+	return trie.MustBytes(t)
+}
+
+func (t *terminalCommitment) String() string {
+	return hex.EncodeToString(t.bytes)
+}
+
+func (t *terminalCommitment) Clone() trie.TCommitment {
+	if t == nil {
+		return nil
+	}
+	ret := *t
+	return &ret
+}
@@ -0,0 +1,264 @@
+package trie_hash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/iotaledger/trie.go/trie"
+)
+
+// Proof is a model-specific proof of inclusion. The hasher is not serialized, only the size of the hash.
+// With blake2b hashers it is the proof of trie_blake2b
+type Proof struct {
+	PathArity trie.PathArity
+	Hasher    Hasher
+	Key       []byte
+	Path      []*ProofElement
+}
+
+type ProofElement struct {
+	PathFragment []byte
+	Children     map[byte][]byte
+	Terminal     []byte
+	ChildIndex   int
+}
+
+// ProofFromBytes parses the proof created with the hasher
+func ProofFromBytes(data []byte, hasher Hasher) (*Proof, error) {
+	ret := &Proof{Hasher: hasher}
+	rdr := bytes.NewReader(data)
+	if err := ret.Read(rdr); err != nil {
+		return nil, err
+	}
+	if rdr.Len() != 0 {
+		return nil, trie.ErrNotAllBytesConsumed
+	}
+	return ret, nil
+}
+
+// Proof converts generic proof path to the Merkle proof path
+func (m *CommitmentModel) Proof(key []byte, tr trie.NodeStore) *Proof {
+	unpackedKey := trie.UnpackBytes(key, tr.PathArity())
+	proofGeneric := trie.GetProofGeneric(tr, unpackedKey)
+	if proofGeneric == nil {
+		return nil
+	}
+	ret := &Proof{
+		PathArity: tr.PathArity(),
+		Hasher:    m.hasher,
+		Key:       proofGeneric.Key,
+		Path:      make([]*ProofElement, len(proofGeneric.Path)),
+	}
+	var elemKeyPosition int
+	var isLast bool
+	var childIndex int
+
+	for i, k := range proofGeneric.Path {
+		node, ok := tr.GetNode(k)
+		if !ok {
+			panic(fmt.Errorf("can't find node key '%x'", k))
+		}
+		isLast = i == len(proofGeneric.Path)-1
+		if !isLast {
+			elemKeyPosition += len(node.PathFragment())
+			childIndex = int(unpackedKey[elemKeyPosition])
+			elemKeyPosition++
+		} else {
+			switch proofGeneric.Ending {
+			case trie.EndingTerminal:
+				childIndex = m.arity.TerminalCommitmentIndex()
+			case trie.EndingExtend, trie.EndingSplit:
+				childIndex = m.arity.PathFragmentCommitmentIndex()
+			default:
+				panic("wrong ending code")
+			}
+		}
+		em := &ProofElement{
+			PathFragment: node.PathFragment(),
+			Children:     make(map[byte][]byte),
+			Terminal:     nil,
+			ChildIndex:   childIndex,
+		}
+		if node.Terminal() != nil {
+			em.Terminal = node.Terminal().(*terminalCommitment).bytes
+		}
+		for idx, v := range node.ChildCommitments() {
+			if int(idx) == childIndex {
+				// skipping the commitment which must come from the next child
+				continue
+			}
+			em.Children[idx] = v.(vectorCommitment)
+		}
+		ret.Path[i] = em
+	}
+	return ret
+}
+
+func (p *Proof) Bytes() []byte {
+	return trie.MustBytes(p)
+}
+
+func (p *Proof) Write(w io.Writer) error {
+	var err error
+	if err = trie.WriteByte(w, byte(p.PathArity)); err != nil {
+		return err
+	}
+	if err = trie.WriteByte(w, byte(p.Hasher.Size())); err != nil {
+		return err
+	}
+	encodedKey, err := trie.EncodeUnpackedBytes(p.Key, p.PathArity)
+	if err != nil {
+		return err
+	}
+	if err = trie.WriteBytes16(w, encodedKey); err != nil {
+		return err
+	}
+	if err = trie.WriteUint16(w, uint16(len(p.Path))); err != nil {
+		return err
+	}
+	for _, e := range p.Path {
+		if err = e.Write(w, p.PathArity, p.Hasher.Size()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read reads the proof. The Hasher of the proof must be set
+func (p *Proof) Read(r io.Reader) error {
+	trie.Assert(p.Hasher != nil, "trie_hash::Proof::Read: hasher must be set")
+	b, err := trie.ReadByte(r)
+	if err != nil {
+		return err
+	}
+	p.PathArity = trie.PathArity(b)
+
+	b, err = trie.ReadByte(r)
+	if err != nil {
+		return err
+	}
+	if int(b) != p.Hasher.Size() {
+		return fmt.Errorf("wrong hash size %d, expected %d", b, p.Hasher.Size())
+	}
+
+	var encodedKey []byte
+	if encodedKey, err = trie.ReadBytes16(r); err != nil {
+		return err
+	}
+	if p.Key, err = trie.DecodeToUnpackedBytes(encodedKey, p.PathArity); err != nil {
+		return err
+	}
+	var size uint16
+	if err = trie.ReadUint16(r, &size); err != nil {
+		return err
+	}
+	p.Path = make([]*ProofElement, size)
+	for i := range p.Path {
+		p.Path[i] = &ProofElement{}
+		if err = p.Path[i].Read(r, p.PathArity, p.Hasher.Size()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	hasTerminalValueFlag = 0x01
+	hasChildrenFlag      = 0x02
+)
+
+func (e *ProofElement) Write(w io.Writer, arity trie.PathArity, hashSize int) error {
+	encodedPathFragment, err := trie.EncodeUnpackedBytes(e.PathFragment, arity)
+	if err != nil {
+		return err
+	}
+	if err = trie.WriteBytes16(w, encodedPathFragment); err != nil {
+		return err
+	}
+	if err = trie.WriteUint16(w, uint16(e.ChildIndex)); err != nil {
+		return err
+	}
+	var smallFlags byte
+	if e.Terminal != nil {
+		smallFlags = hasTerminalValueFlag
+	}
+	// compress children flags 32 bytes (if any)
+	var flags [32]byte
+	for i := range e.Children {
+		flags[i/8] |= 0x1 << (i % 8)
+		smallFlags |= hasChildrenFlag
+	}
+	if err := trie.WriteByte(w, smallFlags); err != nil {
+		return err
+	}
+	// write terminal commitment if any
+	if smallFlags&hasTerminalValueFlag != 0 {
+		if err = trie.WriteBytes8(w, e.Terminal); err != nil {
+			return err
+		}
+	}
+	// write child commitments if any
+	if smallFlags&hasChildrenFlag != 0 {
+		if _, err = w.Write(flags[:]); err != nil {
+			return err
+		}
+		for i := 0; i < arity.VectorLength(); i++ {
+			child, ok := e.Children[uint8(i)]
+			if !ok {
+				continue
+			}
+			if len(child) != hashSize {
+				return fmt.Errorf("wrong data size. Expected %d, got %d", hashSize, len(child))
+			}
+			if _, err = w.Write(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *ProofElement) Read(r io.Reader, arity trie.PathArity, hashSize int) error {
+	var err error
+	var encodedPathFragment []byte
+	if encodedPathFragment, err = trie.ReadBytes16(r); err != nil {
+		return err
+	}
+	if e.PathFragment, err = trie.DecodeToUnpackedBytes(encodedPathFragment, arity); err != nil {
+		return err
+	}
+	var idx uint16
+	if err := trie.ReadUint16(r, &idx); err != nil {
+		return err
+	}
+	e.ChildIndex = int(idx)
+	var smallFlags byte
+	if smallFlags, err = trie.ReadByte(r); err != nil {
+		return err
+	}
+	if smallFlags&hasTerminalValueFlag != 0 {
+		if e.Terminal, err = trie.ReadBytes8(r); err != nil {
+			return err
+		}
+	} else {
+		e.Terminal = nil
+	}
+	e.Children = make(map[byte][]byte)
+	if smallFlags&hasChildrenFlag != 0 {
+		var flags [32]byte
+		if _, err = r.Read(flags[:]); err != nil {
+			return err
+		}
+		for i := 0; i < arity.NumChildren(); i++ {
+			ib := uint8(i)
+			if flags[i/8]&(0x1<<(i%8)) != 0 {
+				e.Children[ib] = make([]byte, hashSize)
+				if _, err = r.Read(e.Children[ib]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package trie_kzg_bn256
 
-import "go.dedis.ch/kyber/v3"
+import (
+	"bytes"
+
+	"go.dedis.ch/kyber/v3"
+)
 
 // commit commits to vector vect[0], ...., vect[D-1]
 // it is [f(s)]1 where f is polynomial  in evaluation (Lagrange) form,
@@ -121,3 +125,137 @@ func (sd *TrustedSetup) commitAll(vect []kyber.Scalar) (kyber.Point, []kyber.Poi
 	}
 	return retC, retPi
 }
+
+// opening is a claim that the polynomial committed with c has value at the domain point with the index
+// vect is the vector of polynomial values in evaluation form. Only needed by the prover
+type opening struct {
+	c     kyber.Point
+	vect  []kyber.Scalar
+	index int
+	value kyber.Scalar
+}
+
+// proveMulti aggregates openings of different polynomials at different points of the domain into one opening.
+// It follows the multi-point evaluation scheme:
+// - r is the Fiat-Shamir challenge of all openings
+// - g(X) = sum<i>(r^i * (f<i>(X) - y<i>) / (X - z<i>)), D = [g(s)]1
+// - t is the Fiat-Shamir challenge of r and D
+// - h(X) = sum<i>(r^i * f<i>(X) / (t - z<i>))
+// - pi = [(h(s) - g(s) - y) / (s - t)]1 where y = h(t) - g(t) = sum<i>(r^i * y<i> / (t - z<i>))
+// Returns D and pi
+func (sd *TrustedSetup) proveMulti(openings []*opening) (kyber.Point, kyber.Point) {
+	rPowers := sd.challengePowers(openings)
+	g := make([]kyber.Scalar, sd.D)
+	q := sd.Suite.G1().Scalar()
+	for j := range g {
+		g[j] = sd.Suite.G1().Scalar().Zero()
+		for i, o := range openings {
+			sd.qPoly(o.vect, o.index, j, o.value, q)
+			q.Mul(q, rPowers[i])
+			g[j].Add(g[j], q)
+		}
+	}
+	d := sd.commit(g)
+	t := sd.challengeT(rPowers[1], d)
+	coeffs, y := sd.multiCoefficients(openings, rPowers, t)
+
+	e := sd.Suite.G1().Scalar()
+	quotient := make([]kyber.Scalar, sd.D)
+	for j := range quotient {
+		// h(domain<j>)
+		h := sd.Suite.G1().Scalar().Zero()
+		for i, o := range openings {
+			if o.vect[j] == nil {
+				continue
+			}
+			e.Mul(coeffs[i], o.vect[j])
+			h.Add(h, e)
+		}
+		quotient[j] = h.Sub(h, g[j])
+		quotient[j].Sub(quotient[j], y)
+		e.Sub(sd.Domain[j], t)
+		quotient[j].Div(quotient[j], e)
+	}
+	return d, sd.commit(quotient)
+}
+
+// verifyMulti verifies aggregated opening pi with commitment d to the aggregated quotient polynomial.
+// Only commitments, indices and values are used from openings
+func (sd *TrustedSetup) verifyMulti(openings []*opening, d, pi kyber.Point) bool {
+	if len(openings) == 0 {
+		return false
+	}
+	rPowers := sd.challengePowers(openings)
+	t := sd.challengeT(rPowers[1], d)
+	coeffs, y := sd.multiCoefficients(openings, rPowers, t)
+
+	// e = sum<i>(r^i/(t - z<i>) * C<i>) - D - [y]1
+	e := sd.Suite.G1().Point().Null()
+	elem := sd.Suite.G1().Point()
+	for i, o := range openings {
+		elem.Mul(coeffs[i], o.c)
+		e.Add(e, elem)
+	}
+	e.Sub(e, d)
+	e.Sub(e, elem.Mul(y, nil))
+	// [s - t]2 = [s - domain<0>]2 + [domain<0> - t]2
+	st := sd.Suite.G2().Scalar().Sub(sd.Domain[0], t)
+	s2 := sd.Suite.G2().Point().Mul(st, nil)
+	s2.Add(s2, sd.Diff2[0])
+
+	p1 := sd.Suite.Pair(pi, s2)
+	p2 := sd.Suite.Pair(e, sd.Suite.G2().Point().Base())
+	return p1.Equal(p2)
+}
+
+// multiCoefficients returns r^i / (t - z<i>) for each opening and y = sum<i>(r^i * y<i> / (t - z<i>))
+func (sd *TrustedSetup) multiCoefficients(openings []*opening, rPowers []kyber.Scalar, t kyber.Scalar) ([]kyber.Scalar, kyber.Scalar) {
+	coeffs := make([]kyber.Scalar, len(openings))
+	y := sd.Suite.G1().Scalar().Zero()
+	e := sd.Suite.G1().Scalar()
+	for i, o := range openings {
+		coeffs[i] = sd.Suite.G1().Scalar().Sub(t, sd.Domain[o.index])
+		coeffs[i].Div(rPowers[i], coeffs[i])
+		e.Mul(coeffs[i], o.value)
+		y.Add(y, e)
+	}
+	return coeffs, y
+}
+
+// challengePowers returns powers 1, r, r^2, ... of the Fiat-Shamir challenge r calculated from all openings.
+// At least 2 powers are returned
+func (sd *TrustedSetup) challengePowers(openings []*opening) []kyber.Scalar {
+	var buf bytes.Buffer
+	for _, o := range openings {
+		if _, err := o.c.MarshalTo(&buf); err != nil {
+			panic(err)
+		}
+		buf.Write([]byte{byte(o.index), byte(o.index >> 8)})
+		if _, err := o.value.MarshalTo(&buf); err != nil {
+			panic(err)
+		}
+	}
+	r := scalarFromBytes(sd.Suite.G1().Scalar(), buf.Bytes())
+	n := len(openings)
+	if n < 2 {
+		n = 2
+	}
+	ret := make([]kyber.Scalar, n)
+	ret[0] = sd.Suite.G1().Scalar().One()
+	for i := 1; i < n; i++ {
+		ret[i] = sd.Suite.G1().Scalar().Mul(ret[i-1], r)
+	}
+	return ret
+}
+
+// challengeT returns the Fiat-Shamir challenge t which is the evaluation point of the aggregated polynomial
+func (sd *TrustedSetup) challengeT(r kyber.Scalar, d kyber.Point) kyber.Scalar {
+	var buf bytes.Buffer
+	if _, err := r.MarshalTo(&buf); err != nil {
+		panic(err)
+	}
+	if _, err := d.MarshalTo(&buf); err != nil {
+		panic(err)
+	}
+	return scalarFromBytes(sd.Suite.G1().Scalar(), buf.Bytes())
+}
@@ -254,9 +254,13 @@ func makeVector(n *trie.NodeData, ts *TrustedSetup, ret *[258]kyber.Scalar) {
 	if n.Terminal != nil {
 		ret[256] = n.Terminal.(*terminalCommitment).Scalar
 	}
-	h := blake2b.Sum256(n.PathFragment)
-	ret[257] = ts.Suite.G1().Scalar()
-	scalarFromBytes(ret[257], h[:])
+	ret[257] = scalarFromPathFragment(ts.Suite.G1().Scalar(), n.PathFragment)
+}
+
+// scalarFromPathFragment makes a scalar which represents path fragment in the vector
+func scalarFromPathFragment(ret kyber.Scalar, pathFragment []byte) kyber.Scalar {
+	h := blake2b.Sum256(pathFragment)
+	return scalarFromBytes(ret, h[:])
 }
 
 // scalarFromPoint hashes the point and make a scalar from hash
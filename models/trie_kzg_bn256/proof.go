@@ -35,7 +35,30 @@ type ProofOfInclusion struct {
 
 // ProofOfPath is a proof of some existing path in the state, which also proves absence of some key
 type ProofOfPath struct {
-	// TODO not implemented
+	// key of the proof
+	Key []byte
+	// path of proof elements. The last element proves that the trie commits to something else
+	// at the place where it would commit to the key if the key would be present
+	Path []*ProofOfPathElement
+}
+
+// ProofOfPathElement is an element of the ProofOfPath. In addition to the opening of the child commitment it
+// contains the path fragment of the node with the proof of it, so that the path can be checked against the key
+type ProofOfPathElement struct {
+	// commitment to the vector (node)
+	C kyber.Point
+	// path fragment of the node
+	PathFragment []byte
+	// proof of the commitment to the path fragment at the position 257 of the committed vector
+	PathFragmentProof kyber.Point
+	// index of the vector element. In all elements except the last one it is the index of the child commitment
+	// to the next vector in the path. In the last element it is either the index of the empty child slot (the key
+	// extends the path), 256 (the key points to the node without terminal) or 257 (the path fragment of the node
+	// diverges from the key)
+	VectorIndex uint16
+	// proof of the child commitment at the position VectorIndex. In the last element it proves the slot is empty.
+	// Not used if VectorIndex == 257
+	Proof kyber.Point
 }
 
 func ProofOfInclusionFromBytes(data []byte) (*ProofOfInclusion, error) {
@@ -60,6 +83,10 @@ func (m *CommitmentModel) ProofOfInclusion(key []byte, tr trie.NodeStore) (*Proo
 		// key is not present in the state
 		return nil, false
 	}
+	if n, ok := tr.GetNode(proofGeneric.Path[len(proofGeneric.Path)-1]); !ok || n.Terminal() == nil {
+		// the key points to the node without terminal, i.e. it is not present in the state
+		return nil, false
+	}
 	// key is present in the state
 	ret := &ProofOfInclusion{
 		Key:      proofGeneric.Key,
@@ -107,8 +134,229 @@ func (m *CommitmentModel) ProofOfInclusion(key []byte, tr trie.NodeStore) (*Proo
 // ProofOfPath returns proof of path along the key, if key is absent. If key is present, it returns nil, false,
 // The proof of path can be used as a proof of absence of the key in the state, i.e. to prove that something else is
 // committed in the state instead of what should be committed if the key would be present
+// Returns nil, false also if the trie is empty
 func (m *CommitmentModel) ProofOfPath(key []byte, tr trie.NodeStore) (*ProofOfPath, bool) {
-	panic("implement me")
+	trie.Assert(tr.PathArity() == trie.PathArity256, "for KZG commitment model only 256-ary trie is supported")
+
+	proofGeneric := trie.GetProofGeneric(tr, key)
+	if proofGeneric == nil || len(proofGeneric.Path) == 0 {
+		// the state is empty
+		return nil, false
+	}
+	if proofGeneric.Ending == trie.EndingTerminal {
+		if n, ok := tr.GetNode(proofGeneric.Path[len(proofGeneric.Path)-1]); ok && n.Terminal() != nil {
+			// key is present in the state
+			return nil, false
+		}
+	}
+	ret := &ProofOfPath{
+		Key:  proofGeneric.Key,
+		Path: make([]*ProofOfPathElement, len(proofGeneric.Path)),
+	}
+	proofLength := len(proofGeneric.Path)
+	for i, k := range proofGeneric.Path {
+		n, ok := tr.GetNode(k)
+		trie.Assert(ok, "can't find node with key '%x'", k)
+
+		nodeData := &trie.NodeData{
+			PathFragment:     n.PathFragment(),
+			ChildCommitments: n.ChildCommitments(),
+			Terminal:         n.Terminal(),
+		}
+		elem := &ProofOfPathElement{
+			C:                 m.calcNodeCommitment(nodeData).Point,
+			PathFragment:      n.PathFragment(),
+			PathFragmentProof: m.calcProof(nodeData, 257),
+		}
+		switch {
+		case i < proofLength-1:
+			nextKey := proofGeneric.Path[i+1]
+			elem.VectorIndex = uint16(nextKey[len(nextKey)-1])
+		case proofGeneric.Ending == trie.EndingTerminal:
+			// the key points to the node without terminal
+			elem.VectorIndex = 256
+		case proofGeneric.Ending == trie.EndingExtend:
+			// the child slot along the key is empty
+			elem.VectorIndex = uint16(key[len(k)+len(n.PathFragment())])
+		default:
+			// trie.EndingSplit: the path fragment diverges from the key
+			elem.VectorIndex = 257
+		}
+		if elem.VectorIndex != 257 {
+			elem.Proof = m.calcProof(nodeData, int(elem.VectorIndex))
+		}
+		ret.Path[i] = elem
+	}
+	return ret, true
+}
+
+func ProofOfPathFromBytes(data []byte) (*ProofOfPath, error) {
+	ret := &ProofOfPath{}
+	rdr := bytes.NewReader(data)
+	if err := ret.Read(rdr); err != nil {
+		return nil, err
+	}
+	if rdr.Len() != 0 {
+		return nil, trie.ErrNotAllBytesConsumed
+	}
+	return ret, nil
+}
+
+func (p *ProofOfPath) Bytes() []byte {
+	return trie.MustBytes(p)
+}
+
+// Validate checks the proof of path against the provided root commitment.
+// Valid proof of path means the key is absent in the state committed by the root
+func (p *ProofOfPath) Validate(root trie.VCommitment) error {
+	if len(p.Path) == 0 {
+		return xerrors.New("proof path is empty")
+	}
+	if !equalCommitments(root, &vectorCommitment{Point: p.Path[0].C}) {
+		return xerrors.New("provided commitment and commitment to the first element are not equal")
+	}
+	keyIdx := 0
+	for i, e := range p.Path {
+		if !Model.verify(e.C, e.PathFragmentProof, scalarFromPathFragment(Model.Suite.G1().Scalar(), e.PathFragment), 257) {
+			return xerrors.Errorf("proof of the path fragment is invalid at path position %d", i)
+		}
+		tail := p.Key[keyIdx:]
+		isPrefix := bytes.HasPrefix(tail, e.PathFragment)
+		if i < len(p.Path)-1 {
+			if !isPrefix || len(tail) <= len(e.PathFragment) {
+				return xerrors.Errorf("proof path does not follow the key at path position %d", i)
+			}
+			if e.VectorIndex != uint16(tail[len(e.PathFragment)]) {
+				return xerrors.Errorf("wrong child index at path position %d", i)
+			}
+			val := scalarFromPoint(Model.Suite.G1().Scalar(), p.Path[i+1].C)
+			if !Model.verify(e.C, e.Proof, val, int(e.VectorIndex)) {
+				return xerrors.Errorf("proof is invalid at path position %d", i)
+			}
+			keyIdx += len(e.PathFragment) + 1
+			continue
+		}
+		// last element
+		switch e.VectorIndex {
+		case 257:
+			if isPrefix {
+				return xerrors.New("path fragment of the last element does not diverge from the key")
+			}
+			return nil
+		case 256:
+			if !bytes.Equal(tail, e.PathFragment) {
+				return xerrors.New("empty terminal of the last element does not correspond to the key")
+			}
+			if !Model.verify(e.C, e.Proof, Model.ZeroG1, 256) {
+				return xerrors.New("proof of the empty terminal is invalid")
+			}
+			return nil
+		}
+		if !isPrefix || len(tail) <= len(e.PathFragment) || e.VectorIndex != uint16(tail[len(e.PathFragment)]) {
+			return xerrors.New("empty child slot of the last element does not follow the key")
+		}
+		if !Model.verify(e.C, e.Proof, Model.ZeroG1, int(e.VectorIndex)) {
+			return xerrors.New("proof of the empty child slot is invalid")
+		}
+	}
+	return nil
+}
+
+func (p *ProofOfPath) Write(w io.Writer) error {
+	if err := trie.WriteBytes16(w, p.Key); err != nil {
+		return err
+	}
+	if err := trie.WriteUint16(w, uint16(len(p.Path))); err != nil {
+		return err
+	}
+	for _, e := range p.Path {
+		if err := e.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ProofOfPath) Read(r io.Reader) error {
+	var err error
+	if p.Key, err = trie.ReadBytes16(r); err != nil {
+		return err
+	}
+	var size uint16
+	if err = trie.ReadUint16(r, &size); err != nil {
+		return err
+	}
+	p.Path = make([]*ProofOfPathElement, size)
+	for i := range p.Path {
+		p.Path[i] = &ProofOfPathElement{}
+		if err = p.Path[i].Read(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *ProofOfPathElement) Write(w io.Writer) error {
+	if _, err := e.C.MarshalTo(w); err != nil {
+		return err
+	}
+	if err := trie.WriteBytes16(w, e.PathFragment); err != nil {
+		return err
+	}
+	if _, err := e.PathFragmentProof.MarshalTo(w); err != nil {
+		return err
+	}
+	if err := trie.WriteUint16(w, e.VectorIndex); err != nil {
+		return err
+	}
+	if e.VectorIndex != 257 {
+		if _, err := e.Proof.MarshalTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *ProofOfPathElement) Read(r io.Reader) error {
+	var err error
+	e.C = Model.Suite.G1().Point()
+	if _, err = e.C.UnmarshalFrom(r); err != nil {
+		return err
+	}
+	if e.PathFragment, err = trie.ReadBytes16(r); err != nil {
+		return err
+	}
+	e.PathFragmentProof = Model.Suite.G1().Point()
+	if _, err = e.PathFragmentProof.UnmarshalFrom(r); err != nil {
+		return err
+	}
+	if err = trie.ReadUint16(r, &e.VectorIndex); err != nil {
+		return err
+	}
+	if e.VectorIndex > 257 {
+		return xerrors.Errorf("wrong vector index %d", e.VectorIndex)
+	}
+	e.Proof = nil
+	if e.VectorIndex != 257 {
+		e.Proof = Model.Suite.G1().Point()
+		if _, err = e.Proof.UnmarshalFrom(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ProofOfPath) String() string {
+	ret := fmt.Sprintf("KZG PROOF OF PATH: key: %s\n", string(p.Key))
+	for i, e := range p.Path {
+		ret += fmt.Sprintf("%d:\n%s\n", i, e.String())
+	}
+	return ret
+}
+
+func (e *ProofOfPathElement) String() string {
+	return fmt.Sprintf("     C: %s\n     pf: %x\n     Ppf: %s\n     idx: %d\n     P: %s",
+		e.C, e.PathFragment, e.PathFragmentProof, e.VectorIndex, e.Proof)
 }
 
 func (p *ProofOfInclusion) Bytes() []byte {
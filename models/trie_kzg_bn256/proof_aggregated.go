@@ -0,0 +1,336 @@
+package trie_kzg_bn256
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/iotaledger/trie.go/trie"
+	"go.dedis.ch/kyber/v3"
+	"golang.org/x/xerrors"
+)
+
+// AggregatedProof is a proof of inclusion of one or several keys. All KZG openings along the paths
+// of all keys are aggregated into one multi-point opening, so the proof contains only two curve points
+// in addition to the commitments and path fragments of the nodes along the paths. Validation needs only 2 pairings.
+// Path fragments are opened at the position 257 of each node, so each key is bound to its path
+type AggregatedProof struct {
+	// keys of the proof
+	Keys [][]byte
+	// commitments to the terminal values of keys
+	Terminals []kyber.Scalar
+	// commitments to the nodes along the paths. Each node is included once, the first one is the root
+	Commitments []kyber.Point
+	// path fragments of the nodes, one for each commitment in Commitments
+	PathFragments [][]byte
+	// path of proof elements for each key
+	Paths [][]AggregatedProofElement
+	// commitment to the aggregated quotient polynomial
+	D kyber.Point
+	// proof of the aggregated opening
+	Proof kyber.Point
+}
+
+type AggregatedProofElement struct {
+	// index of the node commitment in Commitments
+	CommitmentIndex uint16
+	// index of the vector element. 256 mean terminal
+	// If 0 <= VectorIndex <= 255 the value is the commitment to the next vector in the path
+	VectorIndex uint16
+}
+
+func AggregatedProofFromBytes(data []byte) (*AggregatedProof, error) {
+	ret := &AggregatedProof{}
+	rdr := bytes.NewReader(data)
+	if err := ret.Read(rdr); err != nil {
+		return nil, err
+	}
+	if rdr.Len() != 0 {
+		return nil, trie.ErrNotAllBytesConsumed
+	}
+	return ret, nil
+}
+
+// AggregatedProof returns aggregated proof of inclusion of all keys.
+// Returns nil, false if at least one of keys is not present in the state
+func (m *CommitmentModel) AggregatedProof(keys [][]byte, tr trie.NodeStore) (*AggregatedProof, bool) {
+	trie.Assert(tr.PathArity() == trie.PathArity256, "for KZG commitment model only 256-ary trie is supported")
+	if len(keys) == 0 {
+		return nil, false
+	}
+	ret := &AggregatedProof{
+		Keys:      make([][]byte, len(keys)),
+		Terminals: make([]kyber.Scalar, len(keys)),
+		Paths:     make([][]AggregatedProofElement, len(keys)),
+	}
+	// index of the node commitment by the node key
+	nodeIndex := make(map[string]uint16)
+	vectors := make([][]kyber.Scalar, 0)
+	for i, key := range keys {
+		proofGeneric := trie.GetProofGeneric(tr, key)
+		if proofGeneric == nil || len(proofGeneric.Path) == 0 || proofGeneric.Ending != trie.EndingTerminal {
+			// key is not present in the state
+			return nil, false
+		}
+		ret.Keys[i] = proofGeneric.Key
+		ret.Paths[i] = make([]AggregatedProofElement, len(proofGeneric.Path))
+		for j, k := range proofGeneric.Path {
+			idx, ok := nodeIndex[string(k)]
+			if !ok {
+				n, ok := tr.GetNode(k)
+				trie.Assert(ok, "can't find node with key '%x'", k)
+				nodeData := &trie.NodeData{
+					PathFragment:     n.PathFragment(),
+					ChildCommitments: n.ChildCommitments(),
+					Terminal:         n.Terminal(),
+				}
+				var vect [258]kyber.Scalar
+				makeVector(nodeData, &m.TrustedSetup, &vect)
+				idx = uint16(len(vectors))
+				nodeIndex[string(k)] = idx
+				vectors = append(vectors, vect[:])
+				ret.Commitments = append(ret.Commitments, m.TrustedSetup.commit(vect[:]))
+				ret.PathFragments = append(ret.PathFragments, n.PathFragment())
+			}
+			ret.Paths[i][j].CommitmentIndex = idx
+			if j == len(proofGeneric.Path)-1 {
+				ret.Paths[i][j].VectorIndex = 256
+			} else {
+				nextKey := proofGeneric.Path[j+1]
+				ret.Paths[i][j].VectorIndex = uint16(nextKey[len(nextKey)-1])
+			}
+		}
+		lastIdx := ret.Paths[i][len(ret.Paths[i])-1].CommitmentIndex
+		if vectors[lastIdx][256] == nil {
+			// the key points to the node without terminal
+			return nil, false
+		}
+		ret.Terminals[i] = m.Suite.G1().Scalar().Set(vectors[lastIdx][256])
+	}
+	openings, err := ret.openings(vectors)
+	trie.Assert(err == nil, "AggregatedProof: %v", err)
+	ret.D, ret.Proof = m.TrustedSetup.proveMulti(openings)
+	return ret, true
+}
+
+// openings collects unique openings along all paths, including openings of path fragments of all nodes
+// along the paths. Returns error if paths are inconsistent or do not follow the keys
+// Vectors of the committed nodes are only provided by the prover, the verifier passes nil
+func (p *AggregatedProof) openings(vectors [][]kyber.Scalar) ([]*opening, error) {
+	if len(p.Keys) == 0 || len(p.Keys) != len(p.Terminals) || len(p.Keys) != len(p.Paths) {
+		return nil, xerrors.New("wrong number of keys, terminals or paths")
+	}
+	if len(p.PathFragments) != len(p.Commitments) {
+		return nil, xerrors.New("number of path fragments is not equal to the number of commitments")
+	}
+	ret := make([]*opening, 0)
+	already := make(map[[2]uint16]*opening)
+	add := func(idx, vectorIndex uint16, value kyber.Scalar) bool {
+		k := [2]uint16{idx, vectorIndex}
+		if o, ok := already[k]; ok {
+			return o.value.Equal(value)
+		}
+		o := &opening{
+			c:     p.Commitments[idx],
+			index: int(vectorIndex),
+			value: value,
+		}
+		if vectors != nil {
+			o.vect = vectors[idx]
+		}
+		already[k] = o
+		ret = append(ret, o)
+		return true
+	}
+	for i, path := range p.Paths {
+		if len(path) == 0 {
+			return nil, xerrors.Errorf("path #%d is empty", i)
+		}
+		if path[0].CommitmentIndex != 0 {
+			return nil, xerrors.Errorf("path #%d does not start at the root", i)
+		}
+		keyIdx := 0
+		for j, e := range path {
+			if int(e.CommitmentIndex) >= len(p.Commitments) {
+				return nil, xerrors.Errorf("wrong commitment index in path #%d at position %d", i, j)
+			}
+			pathFragment := p.PathFragments[e.CommitmentIndex]
+			tail := p.Keys[i][keyIdx:]
+			if !bytes.HasPrefix(tail, pathFragment) {
+				return nil, xerrors.Errorf("path #%d does not follow the key at position %d", i, j)
+			}
+			if !add(e.CommitmentIndex, 257, scalarFromPathFragment(Model.Suite.G1().Scalar(), pathFragment)) {
+				return nil, xerrors.Errorf("inconsistent path fragment in path #%d at position %d", i, j)
+			}
+			var value kyber.Scalar
+			if j == len(path)-1 {
+				if e.VectorIndex != 256 {
+					return nil, xerrors.Errorf("path #%d must end with the terminal", i)
+				}
+				if len(tail) != len(pathFragment) {
+					return nil, xerrors.Errorf("path #%d does not end at the key", i)
+				}
+				value = p.Terminals[i]
+			} else {
+				if e.VectorIndex >= 256 {
+					return nil, xerrors.Errorf("wrong child index in path #%d at position %d", i, j)
+				}
+				if len(tail) <= len(pathFragment) || e.VectorIndex != uint16(tail[len(pathFragment)]) {
+					return nil, xerrors.Errorf("wrong child index in path #%d at position %d", i, j)
+				}
+				next := path[j+1].CommitmentIndex
+				if int(next) >= len(p.Commitments) {
+					return nil, xerrors.Errorf("wrong commitment index in path #%d at position %d", i, j+1)
+				}
+				value = scalarFromPoint(Model.Suite.G1().Scalar(), p.Commitments[next])
+				keyIdx += len(pathFragment) + 1
+			}
+			if !add(e.CommitmentIndex, e.VectorIndex, value) {
+				return nil, xerrors.Errorf("inconsistent value in path #%d at position %d", i, j)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (p *AggregatedProof) Bytes() []byte {
+	return trie.MustBytes(p)
+}
+
+// Validate checks the proof against the provided root commitment
+// if 'values' are specified, checks if commitments to values are terminals of respective keys
+func (p *AggregatedProof) Validate(root trie.VCommitment, values ...[]byte) error {
+	if len(values) > 0 {
+		if len(values) != len(p.Keys) || len(values) != len(p.Terminals) {
+			return xerrors.New("number of values is not equal to the number of keys")
+		}
+		for i := range values {
+			ct := commitToData(values[i], Model.Suite)
+			if !equalCommitments(ct, &terminalCommitment{Scalar: p.Terminals[i]}) {
+				return xerrors.Errorf("terminal commitment of the key #%d not equal to the provided value", i)
+			}
+		}
+	}
+	if len(p.Commitments) == 0 {
+		return xerrors.New("proof is empty")
+	}
+	if !equalCommitments(root, &vectorCommitment{Point: p.Commitments[0]}) {
+		return xerrors.New("provided commitment and commitment to the first element are not equal")
+	}
+	openings, err := p.openings(nil)
+	if err != nil {
+		return err
+	}
+	if !Model.verifyMulti(openings, p.D, p.Proof) {
+		return xerrors.New("aggregated proof is invalid")
+	}
+	return nil
+}
+
+func (p *AggregatedProof) Write(w io.Writer) error {
+	if err := trie.WriteUint16(w, uint16(len(p.Keys))); err != nil {
+		return err
+	}
+	for i := range p.Keys {
+		if err := trie.WriteBytes16(w, p.Keys[i]); err != nil {
+			return err
+		}
+		if _, err := p.Terminals[i].MarshalTo(w); err != nil {
+			return err
+		}
+		if err := trie.WriteUint16(w, uint16(len(p.Paths[i]))); err != nil {
+			return err
+		}
+		for _, e := range p.Paths[i] {
+			if err := trie.WriteUint16(w, e.CommitmentIndex); err != nil {
+				return err
+			}
+			if err := trie.WriteUint16(w, e.VectorIndex); err != nil {
+				return err
+			}
+		}
+	}
+	if err := trie.WriteUint16(w, uint16(len(p.Commitments))); err != nil {
+		return err
+	}
+	for i, c := range p.Commitments {
+		if _, err := c.MarshalTo(w); err != nil {
+			return err
+		}
+		if err := trie.WriteBytes16(w, p.PathFragments[i]); err != nil {
+			return err
+		}
+	}
+	if _, err := p.D.MarshalTo(w); err != nil {
+		return err
+	}
+	if _, err := p.Proof.MarshalTo(w); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *AggregatedProof) Read(r io.Reader) error {
+	var err error
+	var size uint16
+	if err = trie.ReadUint16(r, &size); err != nil {
+		return err
+	}
+	p.Keys = make([][]byte, size)
+	p.Terminals = make([]kyber.Scalar, size)
+	p.Paths = make([][]AggregatedProofElement, size)
+	for i := range p.Keys {
+		if p.Keys[i], err = trie.ReadBytes16(r); err != nil {
+			return err
+		}
+		p.Terminals[i] = Model.Suite.G1().Scalar()
+		if _, err = p.Terminals[i].UnmarshalFrom(r); err != nil {
+			return err
+		}
+		var pathLen uint16
+		if err = trie.ReadUint16(r, &pathLen); err != nil {
+			return err
+		}
+		p.Paths[i] = make([]AggregatedProofElement, pathLen)
+		for j := range p.Paths[i] {
+			if err = trie.ReadUint16(r, &p.Paths[i][j].CommitmentIndex); err != nil {
+				return err
+			}
+			if err = trie.ReadUint16(r, &p.Paths[i][j].VectorIndex); err != nil {
+				return err
+			}
+		}
+	}
+	if err = trie.ReadUint16(r, &size); err != nil {
+		return err
+	}
+	p.Commitments = make([]kyber.Point, size)
+	p.PathFragments = make([][]byte, size)
+	for i := range p.Commitments {
+		p.Commitments[i] = Model.Suite.G1().Point()
+		if _, err = p.Commitments[i].UnmarshalFrom(r); err != nil {
+			return err
+		}
+		if p.PathFragments[i], err = trie.ReadBytes16(r); err != nil {
+			return err
+		}
+	}
+	p.D = Model.Suite.G1().Point()
+	if _, err = p.D.UnmarshalFrom(r); err != nil {
+		return err
+	}
+	p.Proof = Model.Suite.G1().Point()
+	if _, err = p.Proof.UnmarshalFrom(r); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *AggregatedProof) String() string {
+	ret := fmt.Sprintf("KZG AGGREGATED PROOF: keys: %d, commitments: %d\n", len(p.Keys), len(p.Commitments))
+	for i := range p.Keys {
+		ret += fmt.Sprintf("   key: %s, term: %s, path: %+v\n", string(p.Keys[i]), p.Terminals[i], p.Paths[i])
+	}
+	ret += fmt.Sprintf("   D: %s\n   P: %s", p.D, p.Proof)
+	return ret
+}
@@ -239,3 +239,34 @@ func TestStaticTrustedSetup(t *testing.T) {
 	tr.Update(nil, []byte("kuku"))
 	tr.Commit()
 }
+
+func TestValidateMulti(t *testing.T) {
+	suite := bn256.NewSuite()
+	secret := suite.G1().Scalar().Pick(random.New())
+	tr, err := TrustedSetupFromSecretNaturalDomain(suite, D, secret)
+	require.NoError(t, err)
+
+	rnd := random.New()
+	openings := make([]*opening, 0)
+	for k := 0; k < 3; k++ {
+		vect := make([]kyber.Scalar, D)
+		for i := range vect {
+			if i%(k+2) == 0 {
+				vect[i] = tr.Suite.G1().Scalar().Pick(rnd)
+			}
+		}
+		c := tr.commit(vect)
+		for _, idx := range []int{0, 6, 7, 256} {
+			v := vect[idx]
+			if v == nil {
+				v = tr.ZeroG1
+			}
+			openings = append(openings, &opening{c: c, vect: vect, index: idx, value: v})
+		}
+	}
+	d, pi := tr.proveMulti(openings)
+	require.True(t, tr.verifyMulti(openings, d, pi))
+
+	openings[5].value = tr.Suite.G1().Scalar().Pick(rnd)
+	require.False(t, tr.verifyMulti(openings, d, pi))
+}
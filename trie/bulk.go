@@ -0,0 +1,354 @@
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Bulk build of the trie from the stream of key/value pairs sorted by keys.
+// Nodes are built bottom-up in one pass: the builder keeps only the nodes on the path to the last key.
+// When the next key diverges from the path, nodes below the divergence point are final: they are written
+// to the trie store and replaced by their commitments in the parent. The resulting trie is the same as the one
+// built by Update with the same key/value pairs
+
+// BuildTrie builds the trie from the stream of key/value pairs sorted by keys in ascending order and writes
+// its nodes to the trie store. Returns the root commitment, nil for the empty trie.
+// Pairs with empty values are skipped. Returns ErrUnsortedStream if keys are not strictly ascending.
+// Values are not written, they must be stored in the value store of the trie by the caller.
+// Unsorted stream can be sorted with SortKVStream
+func BuildTrie(model CommitmentModel, sorted KVStreamIterator, trieStore KVWriter, optimizeKeyCommitments ...bool) (VCommitment, error) {
+	b := &trieBuilder{
+		m:         model,
+		arity:     model.PathArity(),
+		trieStore: trieStore,
+		stack:     make([]*builderNode, 0),
+	}
+	if len(optimizeKeyCommitments) > 0 {
+		b.optimizeKeyCommitments = optimizeKeyCommitments[0]
+	}
+	var err error
+	errIter := sorted.Iterate(func(k, v []byte) bool {
+		err = b.add(k, v)
+		return err == nil
+	})
+	if err == nil {
+		err = errIter
+	}
+	if err != nil {
+		return nil, fmt.Errorf("trie::BuildTrie: %w", err)
+	}
+	return b.finish(), nil
+}
+
+type trieBuilder struct {
+	m                      CommitmentModel
+	arity                  PathArity
+	trieStore              KVWriter
+	optimizeKeyCommitments bool
+	// open nodes on the path to the last key in ascending order of their full paths
+	stack []*builderNode
+	// last key, packed and unpacked
+	lastKey         []byte
+	lastUnpackedKey []byte
+	hasLastKey      bool
+}
+
+// builderNode is an open node of the trie builder
+type builderNode struct {
+	// length of the unpacked full path of the node, i.e. key of the node and its path fragment
+	depth int
+	n     NodeData
+}
+
+func (b *trieBuilder) add(key, value []byte) error {
+	if b.hasLastKey && bytes.Compare(b.lastKey, key) >= 0 {
+		return fmt.Errorf("key '%s' follows key '%s': %w", hex.EncodeToString(key), hex.EncodeToString(b.lastKey), ErrUnsortedStream)
+	}
+	b.lastKey = Concat(key)
+	b.hasLastKey = true
+
+	var c TCommitment
+	if b.optimizeKeyCommitments && bytes.Equal(key, value) {
+		c = b.m.CommitToData(UnpackBytes(value, b.arity))
+	} else {
+		c = b.m.CommitToData(value)
+	}
+	if c == nil {
+		return nil
+	}
+	unpackedKey := UnpackBytes(b.lastKey, b.arity)
+	if len(b.stack) > 0 {
+		b.closeBelow(len(commonPrefix(b.lastUnpackedKey, unpackedKey)))
+	}
+	b.lastUnpackedKey = unpackedKey
+	b.stack = append(b.stack, &builderNode{
+		depth: len(unpackedKey),
+		n: NodeData{
+			ChildCommitments: make(map[byte]VCommitment),
+			Terminal:         c,
+		},
+	})
+	return nil
+}
+
+// closeBelow closes all open nodes deeper than 'depth'. If there is no open node with the full path
+// of length 'depth', it is created as a parent of closed nodes
+func (b *trieBuilder) closeBelow(depth int) {
+	for {
+		top := b.stack[len(b.stack)-1]
+		if top.depth <= depth {
+			return
+		}
+		b.stack = b.stack[:len(b.stack)-1]
+		if len(b.stack) > 0 && b.stack[len(b.stack)-1].depth >= depth {
+			b.closeNode(top, b.stack[len(b.stack)-1])
+			continue
+		}
+		parent := &builderNode{
+			depth: depth,
+			n:     *NewNodeData(),
+		}
+		b.closeNode(top, parent)
+		b.stack = append(b.stack, parent)
+		return
+	}
+}
+
+// closeNode writes the node and puts its commitment to the parent
+func (b *trieBuilder) closeNode(nb, parent *builderNode) {
+	childIndex := b.lastUnpackedKey[parent.depth]
+	key := b.lastUnpackedKey[:parent.depth+1]
+	nb.n.PathFragment = b.lastUnpackedKey[parent.depth+1 : nb.depth]
+	parent.n.ChildCommitments[childIndex] = b.writeNode(key, &nb.n)
+}
+
+// finish closes all open nodes and writes the root node. Returns the root commitment
+func (b *trieBuilder) finish() VCommitment {
+	if len(b.stack) == 0 {
+		return nil
+	}
+	b.closeBelow(b.stack[0].depth)
+	root := b.stack[0]
+	root.n.PathFragment = b.lastUnpackedKey[:root.depth]
+	return b.writeNode(nil, &root.n)
+}
+
+func (b *trieBuilder) writeNode(unpackedKey []byte, n *NodeData) VCommitment {
+	bn := newBufferedNode(unpackedKey)
+	bn.n = *n
+	b.trieStore.Set(mustEncodeUnpackedBytes(unpackedKey, b.arity), bn.Bytes(b.m, b.arity, b.optimizeKeyCommitments))
+	return b.m.CalcNodeCommitment(n)
+}
+
+//----------------------------------------------------------------------------
+// external sort of the key/value stream
+
+// SortedKVStream is a KVStreamIterator of key/value pairs sorted by keys. Pairs are kept in the
+// sorted runs in temporary files and merged while iterating. The last value of the duplicated key wins.
+// The stream can be iterated many times. Close removes temporary files
+type SortedKVStream struct {
+	fnames []string
+}
+
+// SortedKVStream implements KVStreamIterator interface
+var _ KVStreamIterator = &SortedKVStream{}
+
+// SortKVStream sorts the stream externally on disk. The stream is split into runs of at most
+// maxChunkBytes bytes of keys and values, which are sorted in memory and written to temporary files in the directory
+// 'dir' (os.TempDir() if empty)
+func SortKVStream(it KVStreamIterator, dir string, maxChunkBytes int) (*SortedKVStream, error) {
+	ret := &SortedKVStream{fnames: make([]string, 0)}
+	chunk := make([]kvPair, 0)
+	chunkBytes := 0
+	var err error
+	errIter := it.Iterate(func(k, v []byte) bool {
+		chunk = append(chunk, kvPair{key: Concat(k), value: Concat(v)})
+		chunkBytes += len(k) + len(v)
+		if chunkBytes < maxChunkBytes {
+			return true
+		}
+		err = ret.writeRun(chunk, dir)
+		chunk = chunk[:0]
+		chunkBytes = 0
+		return err == nil
+	})
+	if err == nil {
+		err = errIter
+	}
+	if err == nil && len(chunk) > 0 {
+		err = ret.writeRun(chunk, dir)
+	}
+	if err != nil {
+		_ = ret.Close()
+		return nil, fmt.Errorf("trie::SortKVStream: %w", err)
+	}
+	return ret, nil
+}
+
+type kvPair struct {
+	key   []byte
+	value []byte
+}
+
+// writeRun sorts the chunk and writes it to the new temporary file. Duplicated keys are removed
+func (s *SortedKVStream) writeRun(chunk []kvPair, dir string) (err error) {
+	sort.SliceStable(chunk, func(i, j int) bool {
+		return bytes.Compare(chunk[i].key, chunk[j].key) < 0
+	})
+	file, err := os.CreateTemp(dir, "kvsort-*.bin")
+	if err != nil {
+		return err
+	}
+	s.fnames = append(s.fnames, file.Name())
+	defer func() {
+		if errClose := file.Close(); err == nil {
+			err = errClose
+		}
+	}()
+	bw := bufio.NewWriter(file)
+	w := NewBinaryStreamWriter(bw)
+	for i := range chunk {
+		if i+1 < len(chunk) && bytes.Equal(chunk[i].key, chunk[i+1].key) {
+			continue
+		}
+		if err = w.Write(chunk[i].key, chunk[i].value); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Iterate merges sorted runs
+func (s *SortedKVStream) Iterate(fun func(k, v []byte) bool) error {
+	runs := make([]*sortedRun, 0, len(s.fnames))
+	defer func() {
+		for _, r := range runs {
+			_ = r.file.Close()
+		}
+	}()
+	for i, fname := range s.fnames {
+		file, err := os.Open(fname)
+		if err != nil {
+			return err
+		}
+		r := &sortedRun{index: i, file: file, r: fullReader{bufio.NewReader(file)}}
+		runs = append(runs, r)
+	}
+	// runs without pairs are closed by the deferred function
+	merge := make(runHeap, 0, len(runs))
+	for _, r := range runs {
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			merge = append(merge, r)
+		}
+	}
+	heap.Init(&merge)
+	for merge.Len() > 0 {
+		key, value := merge[0].key, merge[0].value
+		if err := merge.advance(); err != nil {
+			return err
+		}
+		// runs with the same key are popped in the order of runs, so the last value wins
+		for merge.Len() > 0 && bytes.Equal(merge[0].key, key) {
+			value = merge[0].value
+			if err := merge.advance(); err != nil {
+				return err
+			}
+		}
+		if !fun(key, value) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Close removes temporary files
+func (s *SortedKVStream) Close() error {
+	var ret error
+	for _, fname := range s.fnames {
+		if err := os.Remove(fname); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	s.fnames = nil
+	return ret
+}
+
+// sortedRun is a reader of the sorted run with the current key/value pair
+type sortedRun struct {
+	index int
+	file  *os.File
+	r     io.Reader
+	key   []byte
+	value []byte
+}
+
+func (r *sortedRun) next() (bool, error) {
+	var err error
+	if r.key, err = ReadBytes16(r.r); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	if r.value, err = ReadBytes32(r.r); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// fullReader reads exactly len(p) bytes. Buffered reader may return less, which ReadBytes16 and ReadBytes32 do not expect
+type fullReader struct {
+	r io.Reader
+}
+
+func (f fullReader) Read(p []byte) (int, error) {
+	return io.ReadFull(f.r, p)
+}
+
+// runHeap orders runs by current keys, then by indices of runs
+type runHeap []*sortedRun
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].key, h[j].key); c != 0 {
+		return c < 0
+	}
+	return h[i].index < h[j].index
+}
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*sortedRun)) }
+
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	ret := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return ret
+}
+
+// advance moves the run with the least key to the next pair or removes it from the heap if it is exhausted
+func (h *runHeap) advance() error {
+	ok, err := (*h)[0].next()
+	if err != nil {
+		return err
+	}
+	if ok {
+		heap.Fix(h, 0)
+	} else {
+		heap.Pop(h)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// CheckIssueKind is the kind of inconsistency found by Check
+type CheckIssueKind byte
+
+const (
+	// CheckCorruptedNode node can't be decoded or its key in the trie store is not a valid encoded key
+	CheckCorruptedNode = CheckIssueKind(iota)
+	// CheckMissingNode parent has commitment to the child, but the child node is not in the store
+	CheckMissingNode
+	// CheckMissingValue terminal of the node must be taken from the value store, but the value is not there
+	CheckMissingValue
+	// CheckWrongCommitment commitment of the child in the parent is not equal to the commitment of the child node
+	CheckWrongCommitment
+	// CheckEmptyNode node commits neither to a terminal nor to children
+	CheckEmptyNode
+	// CheckUnmergedNode node without terminal has only one child. Such nodes are always merged with the child
+	CheckUnmergedNode
+	// CheckOrphanedNode node is in the trie store, but it is not reachable from the root
+	CheckOrphanedNode
+	// CheckUnreachableNode node is in the trie store, but it is in the subtree of the node which can't be read,
+	// so it is not known if the node is reachable from the root
+	CheckUnreachableNode
+)
+
+var checkIssueNames = []string{
+	"corrupted node", "missing node", "missing value", "wrong commitment", "empty node", "unmerged node", "orphaned node",
+	"unreachable node",
+}
+
+func (k CheckIssueKind) String() string {
+	if int(k) < len(checkIssueNames) {
+		return checkIssueNames[k]
+	}
+	return "unknown issue"
+}
+
+// CheckIssue is one inconsistency of the trie
+type CheckIssue struct {
+	Kind CheckIssueKind
+	// Key is the unpacked key of the node. For CheckCorruptedNode with the wrong key it is the key in the trie store
+	Key []byte
+	// Err details of the issue, if any
+	Err error
+}
+
+func (i *CheckIssue) String() string {
+	ret := fmt.Sprintf("%s: key '%s'", i.Kind, hex.EncodeToString(i.Key))
+	if i.Err != nil {
+		ret += ": " + i.Err.Error()
+	}
+	return ret
+}
+
+// CheckReport is the result of Check
+type CheckReport struct {
+	// Root is the commitment computed from the root node. nil if the trie is empty
+	Root VCommitment
+	// NumNodes number of nodes reachable from the root
+	NumNodes int
+	// NumTerminals number of committed keys
+	NumTerminals int
+	// NumStoredNodes number of records in the trie store
+	NumStoredNodes int
+	Issues         []*CheckIssue
+}
+
+// IsConsistent is true if no issues were found
+func (r *CheckReport) IsConsistent() bool {
+	return len(r.Issues) == 0
+}
+
+// NumIssues returns number of issues of the kind
+func (r *CheckReport) NumIssues(kind CheckIssueKind) int {
+	ret := 0
+	for _, i := range r.Issues {
+		if i.Kind == kind {
+			ret++
+		}
+	}
+	return ret
+}
+
+func (r *CheckReport) String() string {
+	ret := make([]string, 0, len(r.Issues)+1)
+	ret = append(ret, fmt.Sprintf("root: %v, nodes: %d, terminals: %d, stored nodes: %d, issues: %d",
+		r.Root, r.NumNodes, r.NumTerminals, r.NumStoredNodes, len(r.Issues)))
+	for _, i := range r.Issues {
+		ret = append(ret, "    "+i.String())
+	}
+	return strings.Join(ret, "\n")
+}
+
+// Check walks the trie from the root, recomputes commitment of every node and checks it against the commitment
+// in the parent. It also checks invariants of the trie which are maintained by updates.
+// Then all keys of the trie store are scanned for nodes, unreachable from the root.
+// The node store must be in committed state and must read nodes from the trieStore, e.g. TrieReader.
+// Missing terminal values are reported only if the node store has the value store. Nodes in the subtree of the
+// node which can't be read are not checked, they are reported as unreachable, not as orphaned.
+// To find orphaned nodes, the node of each stored key is looked up from the root again, so memory taken by Check
+// does not grow with the size of the trie, at the cost of reading nodes along the path of each key.
+// Check does not repair anything, see RepairTrie
+func Check(tr NodeStore, trieStore KVIterator) *CheckReport {
+	c := &checker{
+		tr:         tr,
+		m:          tr.Model(),
+		unreadable: make(map[string]struct{}),
+		report:     &CheckReport{Issues: make([]*CheckIssue, 0)},
+	}
+	if n, ok, err := c.getNode(nil); ok && err == nil {
+		c.report.Root = c.checkNode(nil, n)
+	}
+	arity := tr.PathArity()
+	trieStore.Iterate(func(k, _ []byte) bool {
+		c.report.NumStoredNodes++
+		unpackedKey, err := DecodeToUnpackedBytes(k, arity)
+		if err != nil {
+			c.issue(CheckCorruptedNode, k, fmt.Errorf("wrong key in the trie store: %v", err))
+			return true
+		}
+		switch {
+		case c.isReached(unpackedKey):
+		case c.isBelowUnreadable(unpackedKey):
+			c.issue(CheckUnreachableNode, unpackedKey, nil)
+		default:
+			c.issue(CheckOrphanedNode, unpackedKey, nil)
+		}
+		return true
+	})
+	return c.report
+}
+
+type checker struct {
+	tr NodeStore
+	m  CommitmentModel
+	// keys of nodes which can't be read
+	unreadable map[string]struct{}
+	report     *CheckReport
+}
+
+func (c *checker) issue(kind CheckIssueKind, key []byte, err error) {
+	c.report.Issues = append(c.report.Issues, &CheckIssue{
+		Kind: kind,
+		Key:  Concat(key),
+		Err:  err,
+	})
+}
+
+// getNode reads the node. Errors are reported as issues
+func (c *checker) getNode(key []byte) (Node, bool, error) {
+	n, ok, err := getNodeErrFrom(c.tr, key)
+	switch {
+	case errors.Is(err, ErrMissingValue):
+		c.issue(CheckMissingValue, key, err)
+	case err != nil:
+		c.issue(CheckCorruptedNode, key, err)
+	}
+	if err != nil {
+		c.unreadable[string(key)] = struct{}{}
+	}
+	return n, ok, err
+}
+
+// isReached is true if the node with the key is reached by the walk from the root, i.e. each node along the key
+// has the child commitment at the index the key continues with
+func (c *checker) isReached(key []byte) bool {
+	var nodeKey []byte
+	for len(nodeKey) < len(key) {
+		n, ok, err := getNodeErrFrom(c.tr, nodeKey)
+		if err != nil || !ok {
+			return false
+		}
+		pathEnd := len(nodeKey) + len(n.PathFragment())
+		if pathEnd >= len(key) || !bytes.Equal(key[len(nodeKey):pathEnd], n.PathFragment()) {
+			return false
+		}
+		if _, ok = n.ChildCommitments()[key[pathEnd]]; !ok {
+			return false
+		}
+		nodeKey = key[:pathEnd+1]
+	}
+	return true
+}
+
+// isBelowUnreadable is true if the key is in the subtree of the node which can't be read.
+// Keys of all nodes in the subtree have the key of the node as a prefix
+func (c *checker) isBelowUnreadable(key []byte) bool {
+	for i := 0; i < len(key); i++ {
+		if _, ok := c.unreadable[string(key[:i])]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNode checks the subtree and returns recomputed commitment of the node
+func (c *checker) checkNode(key []byte, n Node) VCommitment {
+	c.report.NumNodes++
+	if n.Terminal() != nil {
+		c.report.NumTerminals++
+	}
+	children := n.ChildCommitments()
+	switch {
+	case n.Terminal() == nil && len(children) == 0:
+		c.issue(CheckEmptyNode, key, nil)
+	case n.Terminal() == nil && len(children) == 1:
+		c.issue(CheckUnmergedNode, key, nil)
+	}
+	for i := 0; i < c.tr.PathArity().NumChildren(); i++ {
+		committed, ok := children[byte(i)]
+		if !ok {
+			continue
+		}
+		ck := childKey(n, byte(i))
+		child, ok, err := c.getNode(ck)
+		if err != nil {
+			continue
+		}
+		if !ok {
+			c.issue(CheckMissingNode, ck, nil)
+			continue
+		}
+		if !c.m.EqualCommitments(committed, c.checkNode(ck, child)) {
+			c.issue(CheckWrongCommitment, ck, nil)
+		}
+	}
+	return c.m.CalcNodeCommitment(&NodeData{
+		PathFragment:     n.PathFragment(),
+		ChildCommitments: children,
+		Terminal:         n.Terminal(),
+	})
+}
+
+// getNodeErrFrom reads the node from the NodeStore. If the store can't return errors, panics with the error are recovered
+func getNodeErrFrom(tr NodeStore, key []byte) (n Node, ok bool, err error) {
+	if r, canErr := tr.(interface {
+		GetNodeErr(unpackedKey []byte) (Node, bool, error)
+	}); canErr {
+		return r.GetNodeErr(key)
+	}
+	defer catchError(&err)
+	n, ok = tr.GetNode(key)
+	return n, ok, nil
+}
+
+// repairSortChunkBytes is the size of the sorted run of key/value pairs while repairing the trie
+const repairSortChunkBytes = 64 * 1024 * 1024
+
+// RepairTrie rebuilds the trie from the value store. The new trie is built with BuildTrie into the staging file
+// in the directory 'tmpDir' (os.TempDir() if empty). Only if the build succeeds, all records of the trie store
+// are deleted and replaced with the nodes of the new trie, so the trie store is not changed if the build fails.
+// Key/value pairs are sorted externally with temporary files in the same directory.
+// Returns the root commitment of the rebuilt trie.
+// The replacement is not atomic: if the trie store panics or reading of the staging file fails during the
+// replacement, the trie store is left partially repaired and the repair must be repeated.
+// Tries and readers on top of the trie store must be re-created after the repair
+func RepairTrie(model CommitmentModel, valueStore KVIterator, trieStore KVStore, tmpDir string, optimizeKeyCommitments ...bool) (VCommitment, error) {
+	sorted, err := SortKVStream(kvIteratorStream{valueStore}, tmpDir, repairSortChunkBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = sorted.Close() }()
+
+	staging, err := os.CreateTemp(tmpDir, "repair-*.bin")
+	if err != nil {
+		return nil, fmt.Errorf("trie::RepairTrie: %w", err)
+	}
+	defer func() {
+		_ = staging.Close()
+		_ = os.Remove(staging.Name())
+	}()
+	bw := bufio.NewWriter(staging)
+	stagingWriter := &kvStreamWriterAdaptor{w: NewBinaryStreamWriter(bw)}
+	root, err := BuildTrie(model, sorted, stagingWriter, optimizeKeyCommitments...)
+	if err == nil {
+		err = stagingWriter.err
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		_, err = staging.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("trie::RepairTrie: %w", err)
+	}
+
+	clearStore(trieStore)
+	err = NewBinaryStreamIterator(fullReader{bufio.NewReader(staging)}).Iterate(func(k, v []byte) bool {
+		trieStore.Set(k, v)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("trie::RepairTrie: trie store is partially repaired: %w", err)
+	}
+	return root, nil
+}
+
+// kvStreamWriterAdaptor adapts KVStreamWriter to KVWriter. The first error is kept, next writes are ignored
+type kvStreamWriterAdaptor struct {
+	w   KVStreamWriter
+	err error
+}
+
+func (a *kvStreamWriterAdaptor) Set(key, value []byte) {
+	if a.err == nil {
+		a.err = a.w.Write(key, value)
+	}
+}
+
+// kvIteratorStream adapts KVIterator to KVStreamIterator
+type kvIteratorStream struct {
+	KVIterator
+}
+
+func (s kvIteratorStream) Iterate(fun func(k, v []byte) bool) error {
+	s.KVIterator.Iterate(fun)
+	return nil
+}
@@ -0,0 +1,335 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ContentAddressedStore is an alternative layout of the trie in the key/value store: nodes are stored under
+// hashes of their records instead of the keys of nodes. The record of the node contains the node with all
+// its commitments and record hashes of its children, so the node with the key is found by following record hashes
+// from the root. Identical subtrees are stored only once, so tries with different roots share all common nodes.
+// Each node read from the store is checked against its record hash and its commitment.
+//
+// The trie is used with the ContentAddressedStore as with any other KVReader:
+// - the Trie is created on top of LatestTrieStore and persisted with Persist instead of PersistMutations
+// - the TrieReader of any persisted root is created on top of TrieStore
+//
+// Records are keyed by hashes and not by commitments, because the commitment model may be not binding.
+// For example, trie_blake2b pads short path fragments and short terminal values with zeros, so different nodes
+// may have equal commitments. If different persisted tries have equal root commitments, TrieStore returns
+// the last persisted one.
+//
+// Nodes are never deleted from the store
+type ContentAddressedStore struct {
+	m     CommitmentModel
+	store KVStore
+}
+
+const (
+	// record hash and commitment of the root persisted last
+	contentAddressedRootKey = byte(0)
+	// records of nodes by record hashes
+	contentAddressedNodePrefix = byte(1)
+	// record hashes of persisted roots by root commitments
+	contentAddressedRootIndexPrefix = byte(2)
+)
+
+const contentAddressedHashSize = blake2b.Size256
+
+func NewContentAddressedStore(model CommitmentModel, store KVStore) *ContentAddressedStore {
+	return &ContentAddressedStore{
+		m:     model,
+		store: store,
+	}
+}
+
+// Root returns the root commitment persisted last. Returns nil if the trie is empty
+func (s *ContentAddressedStore) Root() VCommitment {
+	data := s.store.Get([]byte{contentAddressedRootKey})
+	if len(data) == 0 {
+		return nil
+	}
+	Assert(len(data) > contentAddressedHashSize, "trie::ContentAddressedStore: wrong root record")
+	ret := s.m.NewVectorCommitment()
+	err := ret.Read(bytes.NewReader(data[contentAddressedHashSize:]))
+	Assert(err == nil, "trie::ContentAddressedStore: wrong root record: %v", err)
+	return ret
+}
+
+// Persist stores all nodes of the committed trie under hashes of their records and makes the root of the trie
+// the last root. Returns the root commitment. The trie must be created on top of LatestTrieStore.
+// The cache of the trie is cleared
+func (s *ContentAddressedStore) Persist(tr *Trie) VCommitment {
+	root := RootCommitment(tr)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	Assert(tr.nodeStore.spill == nil, "trie::ContentAddressedStore::Persist: memory-bounded mode is not supported")
+
+	// records of unchanged nodes are found in the trie persisted last
+	prev := &contentAddressedReader{s: s, latest: true}
+	// children have longer keys, so their records are made before records of parents
+	keys := make([]string, 0, len(tr.nodeStore.nodeCache))
+	for k := range tr.nodeStore.nodeCache {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+	recordHashes := make(map[string][]byte)
+	for _, k := range keys {
+		n := tr.nodeStore.nodeCache[k]
+		childHashes := make([][]byte, 0, len(n.n.ChildCommitments))
+		for _, i := range sortedChildIndices(&n.n) {
+			ck := string(childKey(n, i))
+			h, ok := recordHashes[ck]
+			if !ok {
+				ref, err := prev.find([]byte(ck))
+				Assert(err == nil && ref != nil, "trie::ContentAddressedStore::Persist: can't find child '%s': %v",
+					hex.EncodeToString([]byte(ck)), err)
+				h = ref.recordHash
+			}
+			childHashes = append(childHashes, h)
+		}
+		record := makeContentAddressedRecord(&n.n, tr.PathArity(), childHashes)
+		h := blake2b.Sum256(record)
+		recordHashes[k] = h[:]
+		s.store.Set(s.nodeKey(h[:]), record)
+		tr.nodeStore.markPersisted(k)
+	}
+	for k := range tr.nodeStore.deleted {
+		tr.nodeStore.markPersisted(k)
+	}
+	if root == nil {
+		s.store.Set([]byte{contentAddressedRootKey}, nil)
+	} else {
+		rootHash, ok := recordHashes[""]
+		if !ok {
+			// the root did not change
+			ref, err := prev.find(nil)
+			Assert(err == nil && ref != nil, "trie::ContentAddressedStore::Persist: can't find the root: %v", err)
+			rootHash = ref.recordHash
+		}
+		s.store.Set([]byte{contentAddressedRootKey}, Concat(rootHash, root.Bytes()))
+		s.store.Set(s.rootIndexKey(root.Bytes()), rootHash)
+	}
+	tr.clearCache()
+	return root
+}
+
+// TrieStore returns KVReader of the trie nodes with the root. Nil root means empty trie
+func (s *ContentAddressedStore) TrieStore(root VCommitment) KVReader {
+	ret := &contentAddressedReader{s: s}
+	if root != nil {
+		ret.reset(s.store.Get(s.rootIndexKey(root.Bytes())), root.Bytes())
+	}
+	return ret
+}
+
+// LatestTrieStore returns KVReader of the trie nodes which always reads the last persisted root.
+// It is used to create the updatable Trie
+func (s *ContentAddressedStore) LatestTrieStore() KVReader {
+	return &contentAddressedReader{s: s, latest: true}
+}
+
+func (s *ContentAddressedStore) nodeKey(recordHash []byte) []byte {
+	return Concat(contentAddressedNodePrefix, recordHash)
+}
+
+func (s *ContentAddressedStore) rootIndexKey(commitmentBytes []byte) []byte {
+	return Concat(contentAddressedRootIndexPrefix, commitmentBytes)
+}
+
+// makeContentAddressedRecord serializes the node with all commitments, so that each node can be read independently
+// of its key, followed by record hashes of children in the order of child indices
+func makeContentAddressedRecord(n *NodeData, arity PathArity, childHashes [][]byte) []byte {
+	var buf bytes.Buffer
+	err := n.Write(&buf, arity, false, false)
+	Assert(err == nil, "trie::makeContentAddressedRecord: %v", err)
+	var ret bytes.Buffer
+	err = WriteBytesVarint(&ret, buf.Bytes())
+	Assert(err == nil, "trie::makeContentAddressedRecord: %v", err)
+	for _, h := range childHashes {
+		ret.Write(h)
+	}
+	return ret.Bytes()
+}
+
+func sortedChildIndices(n *NodeData) []byte {
+	ret := make([]byte, 0, len(n.ChildCommitments))
+	for i := range n.ChildCommitments {
+		ret = append(ret, i)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+// contentAddressedRef is the record hash of the node and the commitment the node must have
+type contentAddressedRef struct {
+	recordHash []byte
+	commitment []byte
+}
+
+// contentAddressedReader is KVReader of the trie nodes by the encoded key of the node.
+// It is shared by the Trie and readers of its committed state, so it is safe for concurrent use
+type contentAddressedReader struct {
+	s      *ContentAddressedStore
+	latest bool
+	// protects refs and root
+	mutex sync.RWMutex
+	// references to nodes by unpacked keys of nodes, found so far for the root
+	refs map[string]*contentAddressedRef
+	// record hash and commitment of the root. Empty commitment means empty trie
+	root contentAddressedRef
+}
+
+func (r *contentAddressedReader) reset(rootHash, rootCommitment []byte) {
+	root := contentAddressedRef{recordHash: rootHash, commitment: rootCommitment}
+	r.root = root
+	r.refs = map[string]*contentAddressedRef{"": &root}
+}
+
+// state returns the root and references found so far for it. In the latest mode it switches to the last persisted root.
+// The map is replaced by the reset, so references of the old root may be added to the returned map after the reset
+func (r *contentAddressedReader) state() (contentAddressedRef, map[string]*contentAddressedRef) {
+	if r.latest {
+		var rootHash, rootCommitment []byte
+		if data := r.s.store.Get([]byte{contentAddressedRootKey}); len(data) > 0 {
+			Assert(len(data) > contentAddressedHashSize, "trie::contentAddressedReader: wrong root record")
+			rootHash, rootCommitment = data[:contentAddressedHashSize], data[contentAddressedHashSize:]
+		}
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if !bytes.Equal(rootHash, r.root.recordHash) || !bytes.Equal(rootCommitment, r.root.commitment) {
+			r.reset(rootHash, rootCommitment)
+		}
+		return r.root, r.refs
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.root, r.refs
+}
+
+func (r *contentAddressedReader) getRef(refs map[string]*contentAddressedRef, unpackedKey []byte) (*contentAddressedRef, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ret, ok := refs[string(unpackedKey)]
+	return ret, ok
+}
+
+func (r *contentAddressedReader) setRef(refs map[string]*contentAddressedRef, unpackedKey []byte, ref *contentAddressedRef) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	refs[string(unpackedKey)] = ref
+}
+
+// contentAddressedReader implements KVReaderWithErr, so that integrity errors are passed to the *Err functions
+var _ KVReaderWithErr = &contentAddressedReader{}
+
+func (r *contentAddressedReader) Get(key []byte) []byte {
+	ret, err := r.GetErr(key)
+	if err != nil {
+		panicStoreError(err)
+	}
+	return ret
+}
+
+func (r *contentAddressedReader) Has(key []byte) bool {
+	return len(r.Get(key)) > 0
+}
+
+func (r *contentAddressedReader) HasErr(key []byte) (bool, error) {
+	ret, err := r.GetErr(key)
+	return len(ret) > 0, err
+}
+
+func (r *contentAddressedReader) GetErr(key []byte) ([]byte, error) {
+	unpackedKey, err := DecodeToUnpackedBytes(key, r.s.m.PathArity())
+	if err != nil {
+		return nil, fmt.Errorf("trie::contentAddressedReader: wrong key '%s': %v", hex.EncodeToString(key), err)
+	}
+	ref, err := r.find(unpackedKey)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+	data, _, _, err := r.getNode(unpackedKey, ref)
+	return data, err
+}
+
+// find returns reference to the node with the unpacked key. Returns nil if there is no such node
+func (r *contentAddressedReader) find(unpackedKey []byte) (*contentAddressedRef, error) {
+	root, refs := r.state()
+	if len(root.commitment) == 0 {
+		return nil, nil
+	}
+	// start from the closest node with known reference
+	nodeKey := unpackedKey
+	ref, ok := r.getRef(refs, nodeKey)
+	for !ok {
+		nodeKey = nodeKey[:len(nodeKey)-1]
+		ref, ok = r.getRef(refs, nodeKey)
+	}
+	for len(nodeKey) < len(unpackedKey) {
+		_, n, childHashes, err := r.getNode(nodeKey, ref)
+		if err != nil {
+			return nil, err
+		}
+		tail := unpackedKey[len(nodeKey):]
+		if len(tail) <= len(n.PathFragment) || !bytes.HasPrefix(tail, n.PathFragment) {
+			return nil, nil
+		}
+		childIndex := tail[len(n.PathFragment)]
+		child, ok := n.ChildCommitments[childIndex]
+		if !ok {
+			return nil, nil
+		}
+		nodeKey = unpackedKey[:len(nodeKey)+len(n.PathFragment)+1]
+		ref = &contentAddressedRef{recordHash: childHashes[childIndex], commitment: child.Bytes()}
+		r.setRef(refs, nodeKey, ref)
+	}
+	return ref, nil
+}
+
+// getNode reads the node by the reference and checks its integrity. Returns serialized node, the node
+// and record hashes of children by child indices
+func (r *contentAddressedReader) getNode(unpackedKey []byte, ref *contentAddressedRef) ([]byte, *NodeData, map[byte][]byte, error) {
+	if len(ref.recordHash) == 0 {
+		return nil, nil, nil, fmt.Errorf("trie::contentAddressedReader: commitment '%s': %w", hex.EncodeToString(ref.commitment), ErrMissingNode)
+	}
+	record := r.s.store.Get(r.s.nodeKey(ref.recordHash))
+	if len(record) == 0 {
+		return nil, nil, nil, fmt.Errorf("trie::contentAddressedReader: record '%s': %w", hex.EncodeToString(ref.recordHash), ErrMissingNode)
+	}
+	if h := blake2b.Sum256(record); !bytes.Equal(h[:], ref.recordHash) {
+		return nil, nil, nil, fmt.Errorf("trie::contentAddressedReader: record does not match hash '%s': %w", hex.EncodeToString(ref.recordHash), ErrCorruptedNode)
+	}
+	rdr := bytes.NewReader(record)
+	data, err := ReadBytesVarint(rdr)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("trie::contentAddressedReader: record '%s', err: '%v': %w", hex.EncodeToString(ref.recordHash), err, ErrCorruptedNode)
+	}
+	n, err := NodeDataFromBytes(r.s.m, data, unpackedKey, r.s.m.PathArity(), nil)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("trie::contentAddressedReader: record '%s', err: '%v': %w", hex.EncodeToString(ref.recordHash), err, ErrCorruptedNode)
+	}
+	if rdr.Len() != len(n.ChildCommitments)*contentAddressedHashSize {
+		return nil, nil, nil, fmt.Errorf("trie::contentAddressedReader: record '%s', wrong number of children: %w", hex.EncodeToString(ref.recordHash), ErrCorruptedNode)
+	}
+	childHashes := make(map[byte][]byte, len(n.ChildCommitments))
+	for _, i := range sortedChildIndices(n) {
+		h := make([]byte, contentAddressedHashSize)
+		_, _ = rdr.Read(h)
+		childHashes[i] = h
+	}
+	if !bytes.Equal(r.s.m.CalcNodeCommitment(n).Bytes(), ref.commitment) {
+		return nil, nil, nil, fmt.Errorf("trie::contentAddressedReader: node does not match commitment '%s': %w", hex.EncodeToString(ref.commitment), ErrCorruptedNode)
+	}
+	return data, n, childHashes, nil
+}
@@ -0,0 +1,171 @@
+package trie
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Diff compares two committed states of the trie and calls 'f' for each key which was added, modified or deleted
+// on the way from 'from' to 'to'. Keys are packed and come in the lexicographical order.
+// 'oldC' is nil for the added key, 'newC' is nil for the deleted key.
+// Both tries are walked at once. Subtrees with equal child commitments are skipped, so the cost of the diff
+// depends on the size of the difference, not on the size of the state.
+// Both node stores must be committed and have the same model. Diff stops if 'f' returns false
+func Diff(from, to NodeStore, f func(k []byte, oldC, newC TCommitment) bool) {
+	Assert(from.PathArity() == to.PathArity(), "trie::Diff: path arities differ: %s != %s", from.PathArity(), to.PathArity())
+	rootFrom, rootTo := RootCommitment(from), RootCommitment(to)
+	if rootFrom != nil && rootTo != nil && to.Model().EqualCommitments(rootFrom, rootTo) {
+		return
+	}
+	d := &differ{
+		m:     to.Model(),
+		arity: to.PathArity(),
+		f:     f,
+	}
+	d.diff(d.cursor(from, nil), d.cursor(to, nil))
+}
+
+// DiffReaders is the Diff of two tries, persisted in the same store under different roots,
+// for example two versions of the VersionedStore
+func DiffReaders(model CommitmentModel, from, to KVReader, f func(k []byte, oldC, newC TCommitment) bool) {
+	Diff(NewTrieReader(model, from, nil), NewTrieReader(model, to, nil), f)
+}
+
+type differ struct {
+	m     CommitmentModel
+	arity PathArity
+	f     func(k []byte, oldC, newC TCommitment) bool
+}
+
+// diffCursor points to the position in the trie inside the path fragment of the node:
+// the position is the key of the node concatenated with first 'depth' elements of the path fragment
+type diffCursor struct {
+	tr    NodeStore
+	n     Node
+	depth int
+}
+
+// cursor returns cursor at the beginning of the node or nil if there is no node with the key
+func (d *differ) cursor(tr NodeStore, unpackedKey []byte) *diffCursor {
+	n, ok := tr.GetNode(unpackedKey)
+	if !ok {
+		return nil
+	}
+	return &diffCursor{tr: tr, n: n}
+}
+
+func (c *diffCursor) rest() []byte {
+	return c.n.PathFragment()[c.depth:]
+}
+
+func (c *diffCursor) position() []byte {
+	return Concat(c.n.Key(), c.n.PathFragment()[:c.depth])
+}
+
+// terminal at the position of the cursor
+func (c *diffCursor) terminal() TCommitment {
+	if len(c.rest()) > 0 {
+		return nil
+	}
+	return c.n.Terminal()
+}
+
+// indices of children at the position of the cursor in ascending order
+func (c *diffCursor) indices() []int {
+	if rest := c.rest(); len(rest) > 0 {
+		return []int{int(rest[0])}
+	}
+	ret := make([]int, 0, len(c.n.ChildCommitments()))
+	for i := range c.n.ChildCommitments() {
+		ret = append(ret, int(i))
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// child returns cursor at the child position and the commitment of the child if it is known.
+// Returns nil cursor if there is no such child
+func (d *differ) child(c *diffCursor, i byte) (*diffCursor, VCommitment) {
+	if c == nil {
+		return nil, nil
+	}
+	if rest := c.rest(); len(rest) > 0 {
+		if rest[0] != i {
+			return nil, nil
+		}
+		return &diffCursor{tr: c.tr, n: c.n, depth: c.depth + 1}, nil
+	}
+	commitment, ok := c.n.ChildCommitments()[i]
+	if !ok {
+		return nil, nil
+	}
+	key := childKey(c.n, i)
+	ret := d.cursor(c.tr, key)
+	if ret == nil {
+		panic(fmt.Errorf("trie::Diff: key: '%s': %w", hex.EncodeToString(key), ErrMissingNode))
+	}
+	return ret, commitment
+}
+
+// diff compares subtrees at the same position. Returns false if the diff was interrupted
+func (d *differ) diff(a, b *diffCursor) bool {
+	switch {
+	case a == nil && b == nil:
+		return true
+	case a == nil:
+		return d.report(b, func(k []byte, c TCommitment) bool { return d.f(k, nil, c) })
+	case b == nil:
+		return d.report(a, func(k []byte, c TCommitment) bool { return d.f(k, c, nil) })
+	}
+	ta, tb := a.terminal(), b.terminal()
+	if ta != nil || tb != nil {
+		if ta == nil || tb == nil || !d.m.EqualCommitments(ta, tb) {
+			if !d.f(d.packedKey(a.position()), ta, tb) {
+				return false
+			}
+		}
+	}
+	for _, i := range mergeIndices(a.indices(), b.indices()) {
+		ca, commitmentA := d.child(a, byte(i))
+		cb, commitmentB := d.child(b, byte(i))
+		if commitmentA != nil && commitmentB != nil && d.m.EqualCommitments(commitmentA, commitmentB) {
+			continue
+		}
+		if !d.diff(ca, cb) {
+			return false
+		}
+	}
+	return true
+}
+
+// report calls 'f' for all keys of the subtree the cursor points to
+func (d *differ) report(c *diffCursor, f func(k []byte, c TCommitment) bool) bool {
+	// all keys of the node start with the position of the cursor
+	return iterateNode(c.tr, c.n.Key(), f)
+}
+
+func (d *differ) packedKey(unpackedKey []byte) []byte {
+	ret, err := PackUnpackedBytes(unpackedKey, d.arity)
+	Assert(err == nil, "trie::Diff: err: %v, key: '%s'", err, hex.EncodeToString(unpackedKey))
+	return ret
+}
+
+// mergeIndices merges two sorted lists of indices without duplicates
+func mergeIndices(a, b []int) []int {
+	ret := make([]int, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0] < b[0]):
+			ret = append(ret, a[0])
+			a = a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			ret = append(ret, b[0])
+			b = b[1:]
+		default:
+			ret = append(ret, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return ret
+}
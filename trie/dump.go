@@ -0,0 +1,225 @@
+package trie
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// TrieDump is a deterministic representation of the trie or of its part for visualisation and debugging.
+// Nodes come in depth-first order, children of each node in the order of child indices.
+// Keys and path fragments are unpacked and hex-encoded, commitments are hex-encoded bytes
+type TrieDump struct {
+	Model string      `json:"model"`
+	Arity string      `json:"arity"`
+	Nodes []*DumpNode `json:"nodes"`
+}
+
+// DumpNode is a node of the TrieDump
+type DumpNode struct {
+	Key          string `json:"key"`
+	PathFragment string `json:"pathFragment"`
+	Terminal     string `json:"terminal,omitempty"`
+	// Commitment of the node. Empty if the node is dirty, because commitments of dirty nodes are not up-to-date
+	Commitment string       `json:"commitment,omitempty"`
+	Children   []*DumpChild `json:"children,omitempty"`
+	// Dirty is true for nodes of the Trie, modified since the last commit
+	Dirty bool `json:"dirty,omitempty"`
+}
+
+// DumpChild is a child of the DumpNode
+type DumpChild struct {
+	Index byte   `json:"index"`
+	Key   string `json:"key"`
+	// Commitment to the child in the parent. Empty for new children which were not committed yet
+	Commitment string `json:"commitment,omitempty"`
+	// Modified is true if the child was modified since the last commit
+	Modified bool `json:"modified,omitempty"`
+	// Missing is true if the parent commits to the child, but the child node is not in the store
+	Missing bool `json:"missing,omitempty"`
+}
+
+// DumpTrie collects all nodes of the trie reachable from the root.
+// If the node store is the Trie, uncommitted nodes are marked dirty
+func DumpTrie(tr NodeStore) *TrieDump {
+	ret := newTrieDump(tr)
+	var walk func(key []byte)
+	walk = func(key []byte) {
+		n, ok := dumpNode(tr, key)
+		if !ok {
+			return
+		}
+		ret.Nodes = append(ret.Nodes, n.node)
+		for _, ch := range n.node.Children {
+			if !ch.Missing {
+				walk(n.childKeys[ch.Index])
+			}
+		}
+	}
+	walk(nil)
+	return ret
+}
+
+// DumpPath collects nodes on the path from the root to the key, for example nodes of the proof of the key.
+// The key is packed
+func DumpPath(tr NodeStore, key []byte) *TrieDump {
+	ret := newTrieDump(tr)
+	path, _, _ := proofPath(tr, UnpackBytes(key, tr.PathArity()))
+	for _, k := range path {
+		n, ok := dumpNode(tr, k)
+		Assert(ok, "trie::DumpPath: missing node '%s'", hex.EncodeToString(k))
+		ret.Nodes = append(ret.Nodes, n.node)
+	}
+	return ret
+}
+
+func newTrieDump(tr NodeStore) *TrieDump {
+	return &TrieDump{
+		Model: tr.Model().Description(),
+		Arity: tr.PathArity().String(),
+		Nodes: make([]*DumpNode, 0),
+	}
+}
+
+// WriteJSON writes the dump as indented JSON
+func (d *TrieDump) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// WriteDOT writes the dump as a Graphviz digraph. Dirty nodes are filled, missing children are dashed
+func (d *TrieDump) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph trie {\n")
+	fmt.Fprintf(&b, "\tlabel=%s;\n", dotQuote(fmt.Sprintf("%s, %s", d.Model, d.Arity)))
+	b.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, n := range d.Nodes {
+		lines := []string{
+			"key: " + n.Key,
+			"pf: " + n.PathFragment,
+		}
+		if n.Terminal != "" {
+			lines = append(lines, "term: "+n.Terminal)
+		}
+		if n.Commitment != "" {
+			lines = append(lines, "C: "+n.Commitment)
+		}
+		attrs := ""
+		if n.Dirty {
+			attrs = ", style=filled, fillcolor=\"lightyellow\""
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s%s];\n", dotNodeID(n.Key), dotQuote(strings.Join(lines, "\n")), attrs)
+	}
+	for _, n := range d.Nodes {
+		for _, ch := range n.Children {
+			label := fmt.Sprintf("%d", ch.Index)
+			if ch.Commitment != "" {
+				label += ": " + ch.Commitment
+			}
+			attrs := ""
+			switch {
+			case ch.Missing:
+				fmt.Fprintf(&b, "\t%s [label=\"missing\", style=dashed];\n", dotNodeID(ch.Key))
+				attrs = ", style=dashed"
+			case ch.Modified:
+				attrs = ", color=\"orange\""
+			}
+			fmt.Fprintf(&b, "\t%s -> %s [label=%s%s];\n", dotNodeID(n.Key), dotNodeID(ch.Key), dotQuote(label), attrs)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotNodeID(key string) string {
+	return dotQuote("k" + key)
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	return "\"" + strings.ReplaceAll(s, "\n", "\\n") + "\""
+}
+
+// dumpedNode is the DumpNode with unpacked keys of children
+type dumpedNode struct {
+	node      *DumpNode
+	childKeys map[byte][]byte
+}
+
+// dumpNode reads the node and its children. Nodes of the Trie are read under the lock
+func dumpNode(tr NodeStore, key []byte) (*dumpedNode, bool) {
+	if t, ok := tr.(*Trie); ok {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		n, ok := t.nodeStore.getNode(key)
+		if !ok {
+			return nil, false
+		}
+		return newDumpedNode(t.unlocked(), n, n.isModified(t.Model()), n.modifiedChildren), true
+	}
+	n, ok := tr.GetNode(key)
+	if !ok {
+		return nil, false
+	}
+	return newDumpedNode(tr, n, false, nil), true
+}
+
+func newDumpedNode(tr NodeStore, n Node, dirty bool, modifiedChildren map[byte]struct{}) *dumpedNode {
+	m := tr.Model()
+	ret := &dumpedNode{
+		node: &DumpNode{
+			Key:          hex.EncodeToString(n.Key()),
+			PathFragment: hex.EncodeToString(n.PathFragment()),
+			Dirty:        dirty,
+		},
+		childKeys: make(map[byte][]byte),
+	}
+	if n.Terminal() != nil {
+		ret.node.Terminal = hex.EncodeToString(n.Terminal().Bytes())
+	}
+	if !dirty {
+		ret.node.Commitment = hex.EncodeToString(m.CalcNodeCommitment(&NodeData{
+			PathFragment:     n.PathFragment(),
+			ChildCommitments: n.ChildCommitments(),
+			Terminal:         n.Terminal(),
+		}).Bytes())
+	}
+	children := n.ChildCommitments()
+	indices := make([]int, 0, len(children)+len(modifiedChildren))
+	for i := range children {
+		indices = append(indices, int(i))
+	}
+	for i := range modifiedChildren {
+		if _, ok := children[i]; !ok {
+			indices = append(indices, int(i))
+		}
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		ck := childKey(n, byte(i))
+		_, modified := modifiedChildren[byte(i)]
+		_, exists := tr.GetNode(ck)
+		if !exists && modified {
+			// the child is deleted since the last commit
+			continue
+		}
+		ch := &DumpChild{
+			Index:    byte(i),
+			Key:      hex.EncodeToString(ck),
+			Modified: modified,
+			Missing:  !exists,
+		}
+		if c := children[byte(i)]; c != nil {
+			ch.Commitment = hex.EncodeToString(c.Bytes())
+		}
+		ret.node.Children = append(ret.node.Children, ch)
+		ret.childKeys[byte(i)] = ck
+	}
+	return ret
+}
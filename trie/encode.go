@@ -10,9 +10,113 @@ var (
 	ErrEmpty            = errors.New("encoded key16 can't be empty")
 	ErrWrongFormat      = errors.New("encoded key16 wrong format")
 	ErrWrongBinaryValue = errors.New("key2 byte must be 1 or 0")
-	ErrWrongArity       = errors.New("arity value must be 1, 15 or 255")
+	ErrWrongDigit       = errors.New("element of the unpacked key must not be bigger than arity")
+	ErrWrongArity       = errors.New("arity + 1 must be a power of 2")
 )
 
+// Unpacked keys are sequences of elements of the bit width of the arity, each element in a separate byte.
+// Bytes are unpacked and elements are packed as big-endian bit streams. If the bit width does not divide 8,
+// the bit stream of the bytes is padded with zero bits to the whole number of elements, and vice versa
+
+// unpackBits splits the bit stream of src into elements of 'width' bits.
+// 16-ary and binary keys are on the hot path, they have specialised loops
+func unpackBits(dst, src []byte, width int) []byte {
+	switch width {
+	case 4:
+		return unpack16(dst, src)
+	case 1:
+		return unpack2(dst, src)
+	}
+	mask := uint(1)<<width - 1
+	var acc uint
+	numBits := 0
+	for _, c := range src {
+		acc = acc<<8 | uint(c)
+		numBits += 8
+		for numBits >= width {
+			numBits -= width
+			dst = append(dst, byte(acc>>numBits&mask))
+		}
+		acc &= 1<<numBits - 1
+	}
+	if numBits > 0 {
+		dst = append(dst, byte(acc<<(width-numBits)&mask))
+	}
+	return dst
+}
+
+// packBits places elements of 'width' bits into the bit stream. The last byte is padded with zero bits
+func packBits(dst, src []byte, width int) ([]byte, error) {
+	switch width {
+	case 4:
+		return pack16(dst, src)
+	case 1:
+		return pack2(dst, src)
+	}
+	var acc uint
+	numBits := 0
+	for _, c := range src {
+		if int(c) >= 1<<width {
+			return nil, wrongElementError(width)
+		}
+		acc = acc<<width | uint(c)
+		numBits += width
+		for numBits >= 8 {
+			numBits -= 8
+			dst = append(dst, byte(acc>>numBits))
+		}
+		acc &= 1<<numBits - 1
+	}
+	if numBits > 0 {
+		dst = append(dst, byte(acc<<(8-numBits)))
+	}
+	return dst, nil
+}
+
+func wrongElementError(width int) error {
+	switch width {
+	case 4:
+		return ErrWrongNibble
+	case 1:
+		return ErrWrongBinaryValue
+	}
+	return ErrWrongDigit
+}
+
+// paddingUnit is the unit of the number of padding bits in the first byte of the encoded key.
+// If the width divides 8, padding is counted in elements, as in original 16-ary and binary encodings
+func paddingUnit(width int) int {
+	if 8%width == 0 {
+		return width
+	}
+	return 1
+}
+
+// encodeBits packs elements and prefixes them with the size of padding
+func encodeBits(unpacked []byte, width int) ([]byte, error) {
+	padding := (8 - len(unpacked)*width%8) % 8
+	ret := append(make([]byte, 0, (len(unpacked)*width+7)/8+1), byte(padding/paddingUnit(width)))
+	return packBits(ret, unpacked, width)
+}
+
+// decodeBits decodes elements. Padding bits must be 0
+func decodeBits(data []byte, width int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrEmpty
+	}
+	padding := int(data[0]) * paddingUnit(width)
+	numBits := (len(data)-1)*8 - padding
+	if padding >= 8 || numBits < 0 || numBits%width != 0 {
+		return nil, ErrWrongFormat
+	}
+	if padding > 0 && data[len(data)-1]&byte(1<<padding-1) != 0 {
+		// enforce padding with 0
+		return nil, ErrWrongFormat
+	}
+	ret := unpackBits(make([]byte, 0, (len(data)-1)*8/width+1), data[1:], width)
+	return ret[:numBits/width], nil
+}
+
 // unpack16 src places each 4 bit nibble into separate byte
 func unpack16(dst, src []byte) []byte {
 	for _, c := range src {
@@ -25,53 +129,36 @@ func unpack16(dst, src []byte) []byte {
 // the 4 lower bit in the last byte remains 0
 func pack16(dst, src []byte) ([]byte, error) {
 	for i := 0; i < len(src); i += 2 {
+		c := src[i]
+		if i+1 < len(src) {
+			if src[i+1] > 0x0F {
+				return nil, ErrWrongNibble
+			}
+			c = c<<4 | src[i+1]
+		} else {
+			c <<= 4
+		}
 		if src[i] > 0x0F {
 			return nil, ErrWrongNibble
 		}
-		c := src[i] << 4
-		if i+1 < len(src) {
-			c |= src[i+1]
-		}
 		dst = append(dst, c)
 	}
 	return dst, nil
 }
 
-// encode16 packs nibbles and prefixes it with number of excess bytes (0 or 1)
+// encode16 packs nibbles and prefixes it with number of excess nibbles (0 or 1)
 func encode16(k16 []byte) ([]byte, error) {
-	ret := append(make([]byte, 0, len(k16)/2+1), byte(len(k16)%2))
-	return pack16(ret, k16)
+	return encodeBits(k16, 4)
 }
 
 func decode16(data []byte) ([]byte, error) {
-	if len(data) == 0 {
-		return nil, ErrEmpty
-	}
-	if data[0] > 1 {
-		return nil, ErrWrongFormat
-	}
-	ret := make([]byte, 0, len(data)*2)
-	ret = unpack16(ret, data[1:])
-	if data[0] == 1 && ret[len(ret)-1] != 0 {
-		// enforce padding with 0
-		return nil, ErrWrongFormat
-	}
-	// cut the excess byte
-	ret = ret[:len(ret)-int(data[0])]
-	return ret, nil
+	return decodeBits(data, 4)
 }
 
 // unpack2 src places each bit into separate byte. Bigendian
 func unpack2(dst, src []byte) []byte {
 	for _, c := range src {
-		for i := 7; i >= 0; i-- {
-			m := byte(0x01) << i
-			if c&(m) != 0 {
-				dst = append(dst, 1)
-			} else {
-				dst = append(dst, 0)
-			}
-		}
+		dst = append(dst, c>>7, c>>6&1, c>>5&1, c>>4&1, c>>3&1, c>>2&1, c>>1&1, c&1)
 	}
 	return dst
 }
@@ -80,10 +167,7 @@ func unpack2(dst, src []byte) []byte {
 func pack2(dst, src []byte) ([]byte, error) {
 	for i := 0; i < len(src); i += 8 {
 		c := byte(0)
-		for j := 0; j < 8; j++ {
-			if i+j >= len(src) {
-				break
-			}
+		for j := 0; j < 8 && i+j < len(src); j++ {
 			switch src[i+j] {
 			case 1:
 				c |= 0x80 >> j
@@ -99,84 +183,93 @@ func pack2(dst, src []byte) ([]byte, error) {
 
 // encode2 packs binary values and prefixes it with number of padded bits
 func encode2(k2 []byte) ([]byte, error) {
-	padded := byte(len(k2) % 8)
-	if padded != 0 {
-		padded = 8 - padded
-	}
-	ret := append(make([]byte, 0, len(k2)/8+1), padded)
-	return pack2(ret, k2)
+	return encodeBits(k2, 1)
 }
 
 // decode2 decodes to bit array
 func decode2(data []byte) ([]byte, error) {
-	if len(data) == 0 {
-		return nil, ErrEmpty
-	}
-	if data[0] > 7 {
-		return nil, ErrWrongFormat
-	}
-	ret := make([]byte, 0, len(data)*8)
-	ret = unpack2(ret, data[1:])
-	Assert(len(ret) >= int(data[0]), "trie::decode2:: assert failed: len(ret) >= int(data[0]), data: %s",
-		hex.EncodeToString(data))
-	// enforce the last data[0] elements are 0
-	for j := len(ret) - int(data[0]); j < len(ret); j++ {
-		if ret[j] != 0 {
-			return nil, ErrWrongFormat
-		}
-	}
-	ret = ret[:len(ret)-int(data[0])]
-	return ret, nil
+	return decodeBits(data, 1)
 }
 
 func UnpackBytes(src []byte, arity PathArity) []byte {
-	switch arity {
-	case PathArity256:
+	if arity == PathArity256 {
 		return src
-	case PathArity16:
-		return unpack16(make([]byte, 0, 2*len(src)), src)
-	case PathArity2:
-		return unpack2(make([]byte, 0, 8*len(src)), src)
 	}
-	panic(ErrWrongArity)
+	Assert(arity.IsValid(), "trie::UnpackBytes: %v", ErrWrongArity)
+	width := arity.BitWidth()
+	return unpackBits(make([]byte, 0, (len(src)*8+width-1)/width), src, width)
+}
+
+// unpackPrefix unpacks the prefix of packed keys. If the bit width of the arity does not divide 8, the last element
+// of the unpacked prefix may be incomplete: only its 'lastBits' high bits belong to the prefix, the rest is padding.
+// Then 'unpacked' contains only whole elements and the incomplete element is returned as 'last'.
+// 'lastBits' == 0 means all elements of the unpacked prefix are whole
+func unpackPrefix(prefix []byte, arity PathArity) (unpacked []byte, last byte, lastBits int) {
+	unpacked = UnpackBytes(prefix, arity)
+	if arity == PathArity256 {
+		return unpacked, 0, 0
+	}
+	lastBits = len(prefix) * 8 % arity.BitWidth()
+	if lastBits == 0 {
+		return unpacked, 0, 0
+	}
+	return unpacked[:len(unpacked)-1], unpacked[len(unpacked)-1], lastBits
+}
+
+// matchesLastBits checks if the high 'lastBits' bits of the element 'e' are equal to those of the incomplete element 'last'
+func matchesLastBits(e, last byte, lastBits int, arity PathArity) bool {
+	shift := arity.BitWidth() - lastBits
+	return e>>shift == last>>shift
+}
+
+// IsAlignedPrefix checks if the unpacked prefix consists of whole elements, i.e. it is a prefix of unpacked keys
+// of all keys with the prefix. It is always true if the bit width of the arity divides 8
+func IsAlignedPrefix(prefix []byte, arity PathArity) bool {
+	_, _, lastBits := unpackPrefix(prefix, arity)
+	return lastBits == 0
 }
 
 func EncodeUnpackedBytes(unpacked []byte, arity PathArity) ([]byte, error) {
 	if len(unpacked) == 0 {
 		return nil, nil
 	}
-	switch arity {
-	case PathArity256:
+	if arity == PathArity256 {
 		return unpacked, nil
-	case PathArity16:
-		return encode16(unpacked)
-	case PathArity2:
-		return encode2(unpacked)
 	}
-	return nil, ErrWrongArity
+	if !arity.IsValid() {
+		return nil, ErrWrongArity
+	}
+	return encodeBits(unpacked, arity.BitWidth())
 }
 
+// PackUnpackedBytes packs the unpacked key back to bytes. If the bit width of the arity does not divide 8,
+// the bits of the last incomplete byte are the padding of the unpacked key and are cut off
 func PackUnpackedBytes(unpacked []byte, arity PathArity) ([]byte, error) {
 	if len(unpacked) == 0 {
 		return nil, nil
 	}
-	switch arity {
-	case PathArity256:
+	if arity == PathArity256 {
 		return unpacked, nil
-	case PathArity16:
-		ret, err := encode16(unpacked)
-		if err != nil {
-			return nil, err
+	}
+	if !arity.IsValid() {
+		return nil, ErrWrongArity
+	}
+	width := arity.BitWidth()
+	ret, err := packBits(make([]byte, 0, (len(unpacked)*width+7)/8), unpacked, width)
+	if err != nil {
+		return nil, err
+	}
+	if 8%width != 0 && len(unpacked)*width%8 != 0 {
+		if ret[len(ret)-1] != 0 {
+			return nil, ErrWrongFormat
 		}
-		return ret[1:], nil
-	case PathArity2:
-		ret, err := encode2(unpacked)
-		if err != nil {
-			return nil, err
+		ret = ret[:len(ret)-1]
+		if len(unpacked) != (len(ret)*8+width-1)/width {
+			// not an unpacked key of whole bytes
+			return nil, ErrWrongFormat
 		}
-		return ret[1:], nil
 	}
-	return nil, ErrWrongArity
+	return ret, nil
 }
 
 func mustEncodeUnpackedBytes(unpacked []byte, arity PathArity) []byte {
@@ -190,13 +283,11 @@ func DecodeToUnpackedBytes(encoded []byte, arity PathArity) ([]byte, error) {
 	if len(encoded) == 0 {
 		return nil, nil
 	}
-	switch arity {
-	case PathArity256:
+	if arity == PathArity256 {
 		return encoded, nil
-	case PathArity16:
-		return decode16(encoded)
-	case PathArity2:
-		return decode2(encoded)
 	}
-	return nil, ErrWrongArity
+	if !arity.IsValid() {
+		return nil, ErrWrongArity
+	}
+	return decodeBits(encoded, arity.BitWidth())
 }
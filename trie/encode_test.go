@@ -148,3 +148,40 @@ func TestCheckingKey(t *testing.T) {
 	require.NoError(t, err)
 	require.EqualValues(t, unpackedBinBack, unpackedBin)
 }
+
+func TestKeysAllArities(t *testing.T) {
+	keys := [][]byte{nil, {0}, {0xff}, {0x31, 0x32, 0x33, 0x34, 0x35}, {0x80, 0, 0, 0, 0, 0, 0, 0x01}}
+	for _, arity := range AllPathArity {
+		for _, key := range keys {
+			unpacked := UnpackBytes(key, arity)
+			for _, e := range unpacked {
+				require.True(t, arity.IsChildIndex(int(e)))
+			}
+			packed, err := PackUnpackedBytes(unpacked, arity)
+			require.NoError(t, err)
+			require.EqualValues(t, string(key), string(packed))
+
+			for i := 0; i <= len(unpacked); i++ {
+				enc, err := EncodeUnpackedBytes(unpacked[:i], arity)
+				require.NoError(t, err)
+				dec, err := DecodeToUnpackedBytes(enc, arity)
+				require.NoError(t, err)
+				require.EqualValues(t, string(unpacked[:i]), string(dec))
+			}
+		}
+		_, err := EncodeUnpackedBytes([]byte{byte(arity.NumChildren())}, arity)
+		if arity != PathArity256 {
+			require.Error(t, err)
+		}
+	}
+}
+
+func TestWrongArity(t *testing.T) {
+	for _, a := range []PathArity{0, 5, 100, 254} {
+		require.False(t, a.IsValid())
+		_, err := EncodeUnpackedBytes([]byte{0}, a)
+		require.ErrorIs(t, err, ErrWrongArity)
+	}
+	require.EqualValues(t, "PathArity32", PathArity32.String())
+	require.EqualValues(t, 5, PathArity32.BitWidth())
+}
@@ -1,7 +1,87 @@
 package trie
 
-import "golang.org/x/xerrors"
+import (
+	"errors"
+	"runtime"
+
+	"golang.org/x/xerrors"
+)
 
 var (
 	ErrNotAllBytesConsumed = xerrors.New("serialization error: not all bytes were consumed")
+	// ErrCorruptedNode node data in the store can't be decoded
+	ErrCorruptedNode = xerrors.New("corrupted trie node")
+	// ErrMissingNode node expected to be in the trie can't be found in the store
+	ErrMissingNode = xerrors.New("missing trie node")
+	// ErrMissingValue terminal value of the node expected to be in the value store can't be found
+	ErrMissingValue = xerrors.New("missing terminal value")
+	// ErrTruncatedSnapshot snapshot ends unexpectedly
+	ErrTruncatedSnapshot = xerrors.New("truncated snapshot")
+	// ErrInvalidSnapshot snapshot does not match the model or its content does not match commitments
+	ErrInvalidSnapshot = xerrors.New("invalid snapshot")
+	// ErrUnsortedStream keys of the key/value stream are not in strictly ascending order
+	ErrUnsortedStream = xerrors.New("key/value stream is not sorted")
+	// ErrUnknownNodeFormat node is serialized in the format which is not in the registry
+	ErrUnknownNodeFormat = xerrors.New("unknown node serialization format")
 )
+
+// storeError is the error of the trie or value store, raised as a panic on the way from the store
+// to the *Err functions of the trie
+type storeError struct {
+	err error
+}
+
+func (e *storeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *storeError) Unwrap() error {
+	return e.err
+}
+
+// panicStoreError raises the error of the store as a panic, which is recovered by catchError
+func panicStoreError(err error) {
+	panic(&storeError{err: err})
+}
+
+// recoverableErrors are errors of the trie which are raised as panics with the error value and recovered by catchError
+var recoverableErrors = []error{ErrCorruptedNode, ErrMissingNode, ErrMissingValue}
+
+// catchError recovers from the panic raised with the error of the store or one of recoverableErrors and returns
+// the error through the pointer. Any other panic, e.g. runtime.Error, is propagated
+func catchError(err *error) {
+	if r := recover(); r != nil {
+		*err = recoveredError(r, false)
+	}
+}
+
+// catchWriterError same as catchError, but also recovers from any error, except runtime.Error, raised
+// by the KVWriter. Writers panic with the error of the store, as hive.go adaptor does
+func catchWriterError(err *error) {
+	if r := recover(); r != nil {
+		*err = recoveredError(r, true)
+	}
+}
+
+// recoveredError returns error of the recovered panic or propagates the panic
+func recoveredError(r interface{}, writerErrors bool) error {
+	if e, ok := r.(*storeError); ok {
+		return e.err
+	}
+	e, ok := r.(error)
+	if !ok {
+		panic(r)
+	}
+	if _, isRuntime := e.(runtime.Error); isRuntime {
+		panic(r)
+	}
+	for _, target := range recoverableErrors {
+		if errors.Is(e, target) {
+			return e
+		}
+	}
+	if writerErrors {
+		return e
+	}
+	panic(r)
+}
@@ -0,0 +1,171 @@
+package trie
+
+import (
+	"encoding/hex"
+	"sort"
+)
+
+// Iterate iterates all keys committed in the trie in the lexicographical order of keys.
+// For each key it calls 'f' with the packed key and the terminal commitment to its value.
+// The values themselves are not part of the trie, they must be taken from the value store if needed.
+// Iteration stops if 'f' returns false
+func (tr *TrieReader) Iterate(f func(k []byte, c TCommitment) bool) {
+	IteratePrefix(tr, nil, f)
+}
+
+// IteratePrefix iterates in the lexicographical order all keys with the given prefix. See Iterate
+func (tr *TrieReader) IteratePrefix(prefix []byte, f func(k []byte, c TCommitment) bool) {
+	IteratePrefix(tr, prefix, f)
+}
+
+// IteratePrefix iterates committed keys with the prefix in any NodeStore.
+// The node store must be in committed state, i.e. Trie must be committed before iterating
+func IteratePrefix(tr NodeStore, prefix []byte, f func(k []byte, c TCommitment) bool) {
+	for _, key := range findNodesWithPrefix(tr, prefix) {
+		if !iterateNode(tr, key, f) {
+			return
+		}
+	}
+}
+
+// IterateFrom iterates in the lexicographical order all keys which are equal or greater than 'start'. See Iterate
+func (tr *TrieReader) IterateFrom(start []byte, f func(k []byte, c TCommitment) bool) {
+	IterateFrom(tr, start, f)
+}
+
+// IterateFrom iterates committed keys starting from 'start' in any NodeStore.
+// Subtrees with keys less than 'start' are skipped without reading
+func IterateFrom(tr NodeStore, start []byte, f func(k []byte, c TCommitment) bool) {
+	iterateNodeFrom(tr, nil, UnpackBytes(start, tr.PathArity()), f)
+}
+
+// iterateNodeFrom iterates keys of the node subtree starting from the unpacked key.
+// Returns false if iteration was interrupted
+func iterateNodeFrom(tr NodeStore, key, unpackedStart []byte, f func(k []byte, c TCommitment) bool) bool {
+	n, ok := tr.GetNode(key)
+	if !ok {
+		return true
+	}
+	full := Concat(key, n.PathFragment())
+	prefix := commonPrefix(full, unpackedStart)
+	switch {
+	case len(prefix) == len(unpackedStart):
+		// all keys of the node start with 'start'
+		return iterateNode(tr, key, f)
+	case len(prefix) < len(full):
+		if full[len(prefix)] > unpackedStart[len(prefix)] {
+			return iterateNode(tr, key, f)
+		}
+		// all keys of the node are less than 'start'
+		return true
+	}
+	// 'full' is a proper prefix of 'start', the terminal of the node is less than 'start'
+	children := n.ChildCommitments()
+	indices := make([]int, 0, len(children))
+	for i := range children {
+		indices = append(indices, int(i))
+	}
+	sort.Ints(indices)
+	startIndex := int(unpackedStart[len(full)])
+	for _, i := range indices {
+		switch {
+		case i == startIndex:
+			if !iterateNodeFrom(tr, childKey(n, byte(i)), unpackedStart, f) {
+				return false
+			}
+		case i > startIndex:
+			if !iterateNode(tr, childKey(n, byte(i)), f) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// findNodeWithPrefix returns key of the upmost node, which covers all keys with the unpacked prefix.
+// Returns false if there are no such keys in the trie
+func findNodeWithPrefix(tr NodeStore, unpackedPrefix []byte) ([]byte, bool) {
+	var key []byte
+	for {
+		n, ok := tr.GetNode(key)
+		if !ok {
+			return nil, false
+		}
+		full := Concat(key, n.PathFragment())
+		if len(full) >= len(unpackedPrefix) {
+			if len(commonPrefix(full, unpackedPrefix)) != len(unpackedPrefix) {
+				return nil, false
+			}
+			return key, true
+		}
+		if len(commonPrefix(full, unpackedPrefix)) != len(full) {
+			return nil, false
+		}
+		key = childKey(n, unpackedPrefix[len(full)])
+	}
+}
+
+// findNodesWithPrefix returns keys of the upmost nodes, whose subtrees contain exactly the keys with the prefix,
+// in the lexicographical order. If the last element of the unpacked prefix is incomplete, keys with the prefix
+// may be spread among several children of the node which covers whole elements of the prefix
+func findNodesWithPrefix(tr NodeStore, prefix []byte) [][]byte {
+	unpackedPrefix, last, lastBits := unpackPrefix(prefix, tr.PathArity())
+	key, ok := findNodeWithPrefix(tr, unpackedPrefix)
+	if !ok {
+		return nil
+	}
+	if lastBits == 0 {
+		return [][]byte{key}
+	}
+	n, ok := tr.GetNode(key)
+	if !ok {
+		return nil
+	}
+	full := Concat(key, n.PathFragment())
+	if len(full) > len(unpackedPrefix) {
+		if !matchesLastBits(full[len(unpackedPrefix)], last, lastBits, tr.PathArity()) {
+			return nil
+		}
+		return [][]byte{key}
+	}
+	indices := make([]int, 0)
+	for i := range n.ChildCommitments() {
+		if matchesLastBits(i, last, lastBits, tr.PathArity()) {
+			indices = append(indices, int(i))
+		}
+	}
+	sort.Ints(indices)
+	ret := make([][]byte, len(indices))
+	for i, idx := range indices {
+		ret[i] = childKey(n, byte(idx))
+	}
+	return ret
+}
+
+// iterateNode recursively iterates the subtree of the node in the lexicographical order.
+// Returns false if iteration was interrupted
+func iterateNode(tr NodeStore, key []byte, f func(k []byte, c TCommitment) bool) bool {
+	n, ok := tr.GetNode(key)
+	if !ok {
+		return true
+	}
+	if n.Terminal() != nil {
+		packedKey, err := PackUnpackedBytes(Concat(key, n.PathFragment()), tr.PathArity())
+		Assert(err == nil, "trie::iterateNode: err: %v, key: '%s'", err, hex.EncodeToString(key))
+		if !f(packedKey, n.Terminal()) {
+			return false
+		}
+	}
+	children := n.ChildCommitments()
+	indices := make([]int, 0, len(children))
+	for i := range children {
+		indices = append(indices, int(i))
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		if !iterateNode(tr, childKey(n, byte(i)), f) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package trie
+
+// Journal of buffered mutations. After the savepoint, the previous state of each node and each key of the buffered
+// trie is recorded before its first modification. RollbackTo restores recorded states in reverse order.
+// Savepoints are valid until the next commit, because the commit rewrites commitments of the buffered nodes
+
+type journal struct {
+	// id of the first savepoint in the journal
+	firstID    int
+	savepoints []savepoint
+	entries    []journalEntry
+	// keys and nodes recorded since the last savepoint
+	keys  map[string]struct{}
+	nodes map[*bufferedNode]struct{}
+}
+
+type savepoint struct {
+	numEntries    int
+	bufferedBytes int
+}
+
+// journalEntry records either the previous state of the key or the previous state of the node
+type journalEntry struct {
+	isKey bool
+	key   string
+	// for the key: node in the buffer under the key, nil if absent. For the node: the recorded node
+	node *bufferedNode
+	// previous state of the node
+	state bufferedNode
+	// previous deletion marks of the key
+	deleted            bool
+	deletedSinceCommit bool
+}
+
+func newJournal(firstID int) *journal {
+	return &journal{
+		firstID:    firstID,
+		savepoints: make([]savepoint, 0),
+		entries:    make([]journalEntry, 0),
+		keys:       make(map[string]struct{}),
+		nodes:      make(map[*bufferedNode]struct{}),
+	}
+}
+
+func (j *journal) active() bool {
+	return len(j.savepoints) > 0
+}
+
+// savepoint adds the new savepoint and returns its id
+func (sc *nodeStoreBuffered) savepoint() int {
+	j := sc.journal
+	j.savepoints = append(j.savepoints, savepoint{
+		numEntries:    len(j.entries),
+		bufferedBytes: sc.bufferedBytes,
+	})
+	j.keys = make(map[string]struct{})
+	j.nodes = make(map[*bufferedNode]struct{})
+	return j.firstID + len(j.savepoints) - 1
+}
+
+// rollbackTo restores the state of the buffered trie at the savepoint. The savepoint remains valid,
+// savepoints after it are discarded
+func (sc *nodeStoreBuffered) rollbackTo(id int) {
+	j := sc.journal
+	idx := id - j.firstID
+	Assert(0 <= idx && idx < len(j.savepoints), "trie::RollbackTo: unknown or discarded savepoint %d", id)
+	sp := j.savepoints[idx]
+	for i := len(j.entries) - 1; i >= sp.numEntries; i-- {
+		e := &j.entries[i]
+		if !e.isKey {
+			*e.node = e.state
+			continue
+		}
+		if e.node != nil {
+			sc.nodeCache[e.key] = e.node
+		} else {
+			delete(sc.nodeCache, e.key)
+		}
+		setMark(sc.deleted, e.key, e.deleted)
+		setMark(sc.deletedSinceCommit, e.key, e.deletedSinceCommit)
+	}
+	sc.bufferedBytes = sp.bufferedBytes
+	j.entries = j.entries[:sp.numEntries]
+	j.savepoints = j.savepoints[:idx+1]
+	j.keys = make(map[string]struct{})
+	j.nodes = make(map[*bufferedNode]struct{})
+}
+
+// releaseSavepoint removes the last savepoint with the id. If 'rollback', mutations made after it are undone first.
+// Journal entries recorded after the released savepoint belong to the previous savepoint, if any.
+// Does nothing if the savepoint was discarded, e.g. by the spill
+func (sc *nodeStoreBuffered) releaseSavepoint(id int, rollback bool) {
+	j := sc.journal
+	idx := id - j.firstID
+	if idx < 0 || idx != len(j.savepoints)-1 {
+		return
+	}
+	if rollback {
+		sc.rollbackTo(id)
+	}
+	if idx == 0 {
+		sc.discardSavepoints()
+		return
+	}
+	j.savepoints = j.savepoints[:idx]
+}
+
+// mutateOrRollback runs the mutation of the locked trie. If the mutation panics, e.g. with the error of the store,
+// buffered mutations made by it are rolled back before the panic is propagated
+func (tr *Trie) mutateOrRollback(mutate func()) {
+	id := tr.nodeStore.savepoint()
+	rollback := true
+	defer func() {
+		tr.nodeStore.releaseSavepoint(id, rollback)
+	}()
+	mutate()
+	rollback = false
+}
+
+// discardSavepoints makes all savepoints invalid. Ids of savepoints are not reused
+func (sc *nodeStoreBuffered) discardSavepoints() {
+	if !sc.journal.active() {
+		return
+	}
+	sc.journal = newJournal(sc.journal.firstID + len(sc.journal.savepoints))
+}
+
+// journalKey records the state of the key before its first modification after the last savepoint
+func (sc *nodeStoreBuffered) journalKey(key string) {
+	j := sc.journal
+	if !j.active() {
+		return
+	}
+	if _, already := j.keys[key]; already {
+		return
+	}
+	j.keys[key] = struct{}{}
+	_, deleted := sc.deleted[key]
+	_, deletedSinceCommit := sc.deletedSinceCommit[key]
+	j.entries = append(j.entries, journalEntry{
+		isKey:              true,
+		key:                key,
+		node:               sc.nodeCache[key],
+		deleted:            deleted,
+		deletedSinceCommit: deletedSinceCommit,
+	})
+}
+
+// journalNode records the state of the buffered node before it can be modified first time after the last savepoint
+func (sc *nodeStoreBuffered) journalNode(n *bufferedNode) {
+	j := sc.journal
+	if !j.active() {
+		return
+	}
+	if _, already := j.nodes[n]; already {
+		return
+	}
+	j.nodes[n] = struct{}{}
+	// child commitments are modified in place only by the commit, other fields are replaced
+	state := *n
+	state.modifiedChildren = make(map[byte]struct{}, len(n.modifiedChildren))
+	for k := range n.modifiedChildren {
+		state.modifiedChildren[k] = struct{}{}
+	}
+	j.entries = append(j.entries, journalEntry{
+		node:  n,
+		state: state,
+	})
+}
+
+func setMark(marks map[string]struct{}, key string, mark bool) {
+	if mark {
+		marks[key] = struct{}{}
+	} else {
+		delete(marks, key)
+	}
+}
+
+// Savepoint marks the current state of buffered mutations and returns id of the savepoint.
+// RollbackTo undoes all mutations made after the savepoint without copying the trie.
+// Commit and ClearCache discard all savepoints, so the commit can't be rolled back.
+// In the memory-bounded mode savepoints are also discarded by each spill
+func (tr *Trie) Savepoint() int {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	return tr.nodeStore.savepoint()
+}
+
+// RollbackTo undoes all Update and Delete calls made after the savepoint. The savepoint remains valid,
+// savepoints taken after it are discarded. Panics if the savepoint was discarded
+func (tr *Trie) RollbackTo(id int) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.nodeStore.rollbackTo(id)
+}
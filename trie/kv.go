@@ -20,6 +20,53 @@ type KVReader interface {
 	Has(key []byte) bool // for performance
 }
 
+// KVReaderWithErr is a key/value reader which returns backend errors instead of panicking.
+// If the KVReader provided to the trie also implements KVReaderWithErr, the trie uses it
+// and passes errors to the caller of the *Err functions. UpdateErr, DeleteErr and DeletePrefixErr roll back
+// the failed mutation, while after the error of CommitErr the trie must be cleared with ClearCache,
+// losing mutations which were not persisted
+type KVReaderWithErr interface {
+	// GetErr retrieves value by key. Returned nil means absence of the key
+	GetErr(key []byte) ([]byte, error)
+	// HasErr checks presence of the key in the key/value store
+	HasErr(key []byte) (bool, error)
+}
+
+// kvReaderWithErrAdaptor makes KVReader from KVReaderWithErr. Get and Has panic on errors,
+// which are recovered by the *Err functions of the trie
+type kvReaderWithErrAdaptor struct {
+	KVReaderWithErr
+}
+
+// NewKVReaderWithErrAdaptor wraps KVReaderWithErr into KVReader, which can be provided to the trie
+func NewKVReaderWithErrAdaptor(r KVReaderWithErr) KVReader {
+	return kvReaderWithErrAdaptor{r}
+}
+
+func (a kvReaderWithErrAdaptor) Get(key []byte) []byte {
+	ret, err := a.GetErr(key)
+	if err != nil {
+		panicStoreError(err)
+	}
+	return ret
+}
+
+func (a kvReaderWithErrAdaptor) Has(key []byte) bool {
+	ret, err := a.HasErr(key)
+	if err != nil {
+		panicStoreError(err)
+	}
+	return ret
+}
+
+// getErr reads the key from the store. Uses KVReaderWithErr if the store implements it
+func getErr(store KVReader, key []byte) ([]byte, error) {
+	if s, ok := store.(KVReaderWithErr); ok {
+		return s.GetErr(key)
+	}
+	return store.Get(key), nil
+}
+
 // KVWriter is a key/value writer
 type KVWriter interface {
 	// Set writes new or updates existing key with the value.
@@ -0,0 +1,215 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// MigrationStats is the result of MigrateNodeFormat
+type MigrationStats struct {
+	// Root commitment of the trie, the same before and after the migration
+	Root VCommitment
+	// NumNodes total number of nodes in the trie store
+	NumNodes int
+	// NumMigrated number of nodes rewritten to the new format
+	NumMigrated int
+}
+
+// MigrateNodeFormat rewrites all nodes of the trie store from the format 'from' to the format from+1.
+// Nodes which are already in the target or newer format are left as they are, so the migration can be repeated
+// if interrupted and the store may contain nodes written by the trie after the upgrade.
+// Each rewritten node is decoded back and its commitment is checked against the commitment of the original node.
+// Rewritten nodes are staged in the scratch store and Check does not collect keys of the trie, so that memory
+// does not grow with the size of the trie store.
+// The scratch store must be empty, e.g. a temporary database. It is emptied before return.
+// Before rewritten nodes are written to the trie store, the whole migrated trie is checked with Check
+// and its root commitment is compared with the root commitment before the migration.
+// If the check fails, the trie store is not changed.
+// The trie store must contain only nodes of the trie, keyed by the encoded unpacked keys, as written by
+// Trie.PersistMutations. The value store is needed only if terminals of some nodes are taken from it
+func MigrateNodeFormat(model CommitmentModel, trieStore KVStore, valueStore KVReader, scratch KVStore, from NodeFormat) (*MigrationStats, error) {
+	to := from + 1
+	if !from.IsKnown() || !to.IsKnown() {
+		return nil, fmt.Errorf("can't migrate from %s to %s: %w", from, to, ErrUnknownNodeFormat)
+	}
+	if !isEmptyStore(scratch) {
+		return nil, fmt.Errorf("can't migrate from %s to %s: scratch store is not empty", from, to)
+	}
+	defer clearStore(scratch)
+
+	arity := model.PathArity()
+	rootBefore, err := migrationRoot(model, trieStore, valueStore, mustEncodeUnpackedBytes(nil, arity))
+	if err != nil {
+		return nil, err
+	}
+	ret := &MigrationStats{}
+	view := &migrationView{
+		store:    trieStore,
+		rewrites: scratch,
+	}
+	trieStore.Iterate(func(k, v []byte) bool {
+		ret.NumNodes++
+		var data []byte
+		if data, err = migrateNode(model, k, v, valueStore, from, to); err != nil {
+			return false
+		}
+		if data != nil {
+			scratch.Set(k, data)
+			ret.NumMigrated++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := Check(NewTrieReader(model, view, valueStore), view)
+	for _, issue := range report.Issues {
+		switch issue.Kind {
+		case CheckCorruptedNode, CheckMissingNode, CheckMissingValue, CheckWrongCommitment, CheckUnreachableNode:
+			return nil, fmt.Errorf("migrated trie is inconsistent: %s: %w", issue, ErrCorruptedNode)
+		}
+	}
+	if !model.EqualCommitments(rootBefore, report.Root) {
+		return nil, fmt.Errorf("root commitment changed after migration from %s to %s: %w", from, to, ErrCorruptedNode)
+	}
+	scratch.Iterate(func(k, data []byte) bool {
+		trieStore.Set(k, data)
+		return true
+	})
+	ret.Root = report.Root
+	return ret, nil
+}
+
+// migrationView is the trie store as it will be after the rewritten nodes are written
+type migrationView struct {
+	store    KVStore
+	rewrites KVStore
+}
+
+// migrationView implements KVReaderWithErr, so that errors of the trie store are reported by Check
+var _ KVReaderWithErr = &migrationView{}
+
+func (v *migrationView) Get(key []byte) []byte {
+	ret, err := v.GetErr(key)
+	if err != nil {
+		panicStoreError(err)
+	}
+	return ret
+}
+
+func (v *migrationView) Has(key []byte) bool {
+	return len(v.Get(key)) > 0
+}
+
+func (v *migrationView) GetErr(key []byte) ([]byte, error) {
+	if data := v.rewrites.Get(key); len(data) > 0 {
+		return data, nil
+	}
+	return getErr(v.store, key)
+}
+
+func (v *migrationView) HasErr(key []byte) (bool, error) {
+	data, err := v.GetErr(key)
+	return len(data) > 0, err
+}
+
+func (v *migrationView) Iterate(fun func(k, v []byte) bool) {
+	v.store.Iterate(func(k, data []byte) bool {
+		if rewritten := v.rewrites.Get(k); len(rewritten) > 0 {
+			data = rewritten
+		}
+		return fun(k, data)
+	})
+}
+
+// migrateNode returns node bytes in the format 'to' or nil if the node is already in the newer format
+func migrateNode(model CommitmentModel, encodedKey, data []byte, valueStore KVReader, from, to NodeFormat) ([]byte, error) {
+	unpackedKey, err := DecodeToUnpackedBytes(encodedKey, model.PathArity())
+	if err != nil {
+		return nil, fmt.Errorf("wrong node key '%s': %v: %w", hex.EncodeToString(encodedKey), err, ErrCorruptedNode)
+	}
+	format, err := NodeFormatOf(data)
+	if err != nil {
+		return nil, fmt.Errorf("node '%s': %v: %w", hex.EncodeToString(encodedKey), err, ErrCorruptedNode)
+	}
+	if format > from {
+		return nil, nil
+	}
+	if format != from {
+		return nil, fmt.Errorf("node '%s' is in %s, expected %s", hex.EncodeToString(encodedKey), format, from)
+	}
+	ret, err := ConvertNode(model, unpackedKey, data, valueStore, to)
+	if err != nil {
+		return nil, fmt.Errorf("node '%s': %w", hex.EncodeToString(encodedKey), err)
+	}
+	return ret, nil
+}
+
+// ConvertNode re-encodes serialized node in another format, preserving how the terminal is serialized.
+// The converted node is decoded back and its commitment is checked against the commitment of the original node
+func ConvertNode(model CommitmentModel, unpackedKey, data []byte, valueStore KVReader, to NodeFormat) ([]byte, error) {
+	arity := model.PathArity()
+	n := NewNodeData()
+	rdr := bytes.NewReader(data)
+	_, isKeyCommitment, skipTerminal, err := n.readFormat(rdr, model, unpackedKey, arity, valueStore)
+	if err == nil && rdr.Len() != 0 {
+		err = ErrNotAllBytesConsumed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrCorruptedNode)
+	}
+	var buf bytes.Buffer
+	if err = n.WriteFormat(&buf, to, arity, isKeyCommitment, skipTerminal); err != nil {
+		return nil, err
+	}
+	nBack, err := NodeDataFromBytes(model, buf.Bytes(), unpackedKey, arity, valueStore)
+	if err != nil {
+		return nil, fmt.Errorf("node can't be read back in %s: %v", to, err)
+	}
+	if !model.EqualCommitments(model.CalcNodeCommitment(n), model.CalcNodeCommitment(nBack)) {
+		return nil, fmt.Errorf("commitment of the node changed in %s", to)
+	}
+	return buf.Bytes(), nil
+}
+
+func isEmptyStore(store KVIterator) bool {
+	ret := true
+	store.Iterate(func(_, _ []byte) bool {
+		ret = false
+		return false
+	})
+	return ret
+}
+
+// clearStore deletes all keys of the store in batches, so that keys of the large store are not collected in memory
+func clearStore(store KVStore) {
+	const batchSize = 10000
+	for {
+		keys := make([][]byte, 0, batchSize)
+		store.Iterate(func(k, _ []byte) bool {
+			keys = append(keys, Concat(k))
+			return len(keys) < batchSize
+		})
+		for _, k := range keys {
+			store.Set(k, nil)
+		}
+		if len(keys) < batchSize {
+			return
+		}
+	}
+}
+
+// migrationRoot returns root commitment of the trie in the store or nil if the store is empty
+func migrationRoot(model CommitmentModel, trieStore KVReader, valueStore KVReader, rootKey []byte) (VCommitment, error) {
+	data, err := getErr(trieStore, rootKey)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	n, err := NodeDataFromBytes(model, data, nil, model.PathArity(), valueStore)
+	if err != nil {
+		return nil, fmt.Errorf("root node: %v: %w", err, ErrCorruptedNode)
+	}
+	return model.CalcNodeCommitment(n), nil
+}
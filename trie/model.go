@@ -2,6 +2,7 @@ package trie
 
 import (
 	"fmt"
+	"math/bits"
 )
 
 // CommitmentModel abstracts 256+ Trie logic from the commitment logic/cryptography
@@ -34,33 +35,47 @@ type CommitmentModel interface {
 	// ShortName short name
 	ShortName() string
 }
+
+// PathArity is the number of children of the trie node minus 1. The number of children is any power of 2 up to 256.
+// Elements of unpacked keys are BitWidth() bits long. If the bit width does not divide 8 (8-, 32-, 64- and 128-ary tries),
+// the last element of the unpacked prefix may be incomplete (see IsAlignedPrefix). IteratePrefix and DeletePrefix
+// match such element with all child indices which share its high bits. Sub-state roots and proofs require aligned prefixes
 type PathArity byte
 
 const (
 	PathArity256 = PathArity(255)
+	PathArity128 = PathArity(127)
+	PathArity64  = PathArity(63)
+	PathArity32  = PathArity(31)
 	PathArity16  = PathArity(15)
+	PathArity8   = PathArity(7)
+	PathArity4   = PathArity(3)
 	PathArity2   = PathArity(1)
 )
 
-var AllPathArity = []PathArity{PathArity256, PathArity16, PathArity2}
+var AllPathArity = []PathArity{PathArity256, PathArity128, PathArity64, PathArity32, PathArity16, PathArity8, PathArity4, PathArity2}
+
+// IsValid checks if arity + 1 is a power of 2
+func (a PathArity) IsValid() bool {
+	return a != 0 && a&(a+1) == 0
+}
+
+// BitWidth is the number of bits of the element of the unpacked key
+func (a PathArity) BitWidth() int {
+	Assert(a.IsValid(), "wrong path arity")
+	return bits.OnesCount8(uint8(a))
+}
 
 func (a PathArity) String() string {
-	switch a {
-	case PathArity256, PathArity16, PathArity2:
+	if a.IsValid() {
 		return fmt.Sprintf("PathArity%d", int(a)+1)
-	default:
-		return "PathArity(wrong)"
 	}
+	return "PathArity(wrong)"
 }
 
 func (a PathArity) TerminalCommitmentIndex() int {
-	switch a {
-	case PathArity256:
-		return 256
-	case PathArity16:
-		return 16
-	case PathArity2:
-		return 2
+	if a.IsValid() {
+		return int(a) + 1
 	}
 	panic("wrong path arity")
 }
@@ -140,6 +140,11 @@ func (n *bufferedNode) markChildModified(index byte) {
 	n.modifiedChildren[index] = struct{}{}
 }
 
+// isModified is true if the node must be re-committed
+func (n *bufferedNode) isModified(model CommitmentModel) bool {
+	return n.pathChanged || len(n.modifiedChildren) > 0 || !model.EqualCommitments(n.newTerminal, n.n.Terminal)
+}
+
 func (n *bufferedNode) Bytes(model CommitmentModel, arity PathArity, optimizeKeyCommitments bool) []byte {
 	// Optimization: if terminal commits to unpackedKey, no need to serialize it,
 	// because all information is in the key
@@ -155,6 +160,14 @@ func (n *bufferedNode) Bytes(model CommitmentModel, arity PathArity, optimizeKey
 	return buf.Bytes()
 }
 
+// bytesWithTerminal serializes the node with the terminal, even if it could be taken from the value store or the key
+func (n *bufferedNode) bytesWithTerminal(arity PathArity) []byte {
+	var buf bytes.Buffer
+	err := n.n.Write(&buf, arity, false, false)
+	Assert(err == nil, "trie::bufferedNode::bytesWithTerminal: %v", err)
+	return buf.Bytes()
+}
+
 func childKey(n Node, childIndex byte) []byte {
 	return Concat(n.Key(), n.PathFragment(), childIndex)
 }
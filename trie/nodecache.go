@@ -0,0 +1,119 @@
+package trie
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// NodeCache is a size-bounded LRU cache of decoded nodes read from the trie store.
+// It can be shared by TrieReaders and Tries on top of the same trie store. Nodes persisted or deleted by the Trie
+// are invalidated by PersistMutations and ClearCache. The cache is safe for concurrent use
+type NodeCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+	// incremented by each invalidation. Nodes read before the invalidation are not put into the cache
+	generation uint64
+	metrics    NodeCacheMetrics
+}
+
+// NodeCacheMetrics counters of the NodeCache
+type NodeCacheMetrics struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+type nodeCacheEntry struct {
+	key  string
+	node *nodeReadOnly
+}
+
+// NewNodeCache creates cache of up to 'size' nodes
+func NewNodeCache(size int) *NodeCache {
+	Assert(size > 0, "trie::NewNodeCache: size must be positive")
+	return &NodeCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Len returns number of cached nodes
+func (c *NodeCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// Metrics returns current values of counters
+func (c *NodeCache) Metrics() NodeCacheMetrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.metrics
+}
+
+// Clear removes all nodes from the cache. Counters are not reset
+func (c *NodeCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.generation++
+}
+
+// get returns cached node and the generation of the cache, which must be passed to put
+func (c *NodeCache) get(unpackedKey []byte) (*nodeReadOnly, uint64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.entries[string(unpackedKey)]; ok {
+		c.lru.MoveToFront(e)
+		c.metrics.Hits++
+		return e.Value.(*nodeCacheEntry).node, c.generation, true
+	}
+	c.metrics.Misses++
+	return nil, c.generation, false
+}
+
+// put caches the node read from the store, unless the cache was invalidated after the read started
+func (c *NodeCache) put(unpackedKey []byte, n *nodeReadOnly, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if e, ok := c.entries[string(unpackedKey)]; ok {
+		e.Value.(*nodeCacheEntry).node = n
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[string(unpackedKey)] = c.lru.PushFront(&nodeCacheEntry{
+		key:  string(unpackedKey),
+		node: n,
+	})
+	for c.lru.Len() > c.size {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.entries, last.Value.(*nodeCacheEntry).key)
+		c.metrics.Evictions++
+	}
+}
+
+// invalidate removes nodes with the unpacked keys from the cache
+func (c *NodeCache) invalidate(unpackedKeys []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	for _, k := range unpackedKeys {
+		if e, ok := c.entries[k]; ok {
+			c.lru.Remove(e)
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (m NodeCacheMetrics) String() string {
+	return fmt.Sprintf("NodeCacheMetrics( hits: %d, misses: %d, evictions: %d )", m.Hits, m.Misses, m.Evictions)
+}
@@ -24,6 +24,7 @@ func NewNodeData() *NodeData {
 	}
 }
 
+// NodeDataFromBytes decodes the node in any of the known serialization formats
 func NodeDataFromBytes(model CommitmentModel, data, unpackedKey []byte, arity PathArity, valueStore KVReader) (*NodeData, error) {
 	ret := NewNodeData()
 	rdr := bytes.NewReader(data)
@@ -41,7 +42,7 @@ func NodeDataFromBytes(model CommitmentModel, data, unpackedKey []byte, arity Pa
 func (n *NodeData) Clone() *NodeData {
 	ret := &NodeData{
 		PathFragment:     make([]byte, len(n.PathFragment)),
-		ChildCommitments: make(map[byte]VCommitment),
+		ChildCommitments: make(map[byte]VCommitment, len(n.ChildCommitments)),
 	}
 	if n.Terminal != nil {
 		ret.Terminal = n.Terminal.Clone()
@@ -67,6 +68,63 @@ func (n *NodeData) String() string {
 	return ret
 }
 
+// NodeFormat is the version of the node serialization format.
+// Nodes in NodeFormatLegacy format have no version marker. Nodes in any other format start with the byte
+// nodeFormatMarker | version. The marker bit is never set in the first byte of the legacy format
+type NodeFormat byte
+
+const (
+	NodeFormatLegacy = NodeFormat(0)
+	NodeFormatV1     = NodeFormat(1)
+	NodeFormatV2     = NodeFormat(2)
+	// CurrentNodeFormat is the format of the nodes written by the trie
+	CurrentNodeFormat = NodeFormatV2
+
+	nodeFormatMarker = 0x80
+)
+
+// nodeCodec encodes and decodes the node, not including the version marker
+type nodeCodec struct {
+	write func(n *NodeData, w io.Writer, arity PathArity, isKeyCommitment bool, skipTerminal bool) error
+	read  func(n *NodeData, r io.Reader, model CommitmentModel, unpackedKey []byte, arity PathArity, valueStore KVReader) (isKeyCommitment bool, skipTerminal bool, err error)
+}
+
+// nodeCodecs is the registry of node serialization formats. Format N+1 must be registered next to format N,
+// so that the trie store can be migrated to it with MigrateNodeFormat
+var nodeCodecs = map[NodeFormat]nodeCodec{
+	NodeFormatLegacy: {write: writeNodeV0, read: readNodeV0},
+	NodeFormatV1:     {write: writeNodeV0, read: readNodeV0},
+	NodeFormatV2:     {write: writeNodeV2, read: readNodeV2},
+}
+
+func (f NodeFormat) String() string {
+	if f == NodeFormatLegacy {
+		return "NodeFormatLegacy"
+	}
+	return fmt.Sprintf("NodeFormatV%d", byte(f))
+}
+
+// IsKnown checks if the format is in the registry
+func (f NodeFormat) IsKnown() bool {
+	_, ok := nodeCodecs[f]
+	return ok
+}
+
+// NodeFormatOf returns the serialization format of the node bytes
+func NodeFormatOf(data []byte) (NodeFormat, error) {
+	if len(data) == 0 {
+		return 0, ErrCorruptedNode
+	}
+	if data[0]&nodeFormatMarker == 0 {
+		return NodeFormatLegacy, nil
+	}
+	ret := NodeFormat(data[0] &^ nodeFormatMarker)
+	if ret == NodeFormatLegacy || !ret.IsKnown() {
+		return 0, fmt.Errorf("%s: %w", ret, ErrUnknownNodeFormat)
+	}
+	return ret, nil
+}
+
 // Read/Write implements optimized serialization of the trie node
 // The serialization of the node takes advantage of the fact that most of the
 // nodes has just few children.
@@ -81,6 +139,11 @@ func (n *NodeData) String() string {
 // In this case:
 // if node has a child commitment at the position of i, 0 <= p <= 255, it has a bit in the byte array
 // at the index i/8. The bit position in the byte is i % 8
+//
+// NodeFormatV2 is the compact version of the same serialization:
+// - length of the path fragment is varint instead of 2 bytes
+// - if 'childIndexListFlag' is set, children are serialized as number of children (1 byte) followed by
+//   child indices in ascending order instead of 'childrenFlags'. It is used when the list is shorter than 'childrenFlags'
 
 const (
 	terminalExistsFlag        = 0x01
@@ -88,6 +151,10 @@ const (
 	takeTerminalFromKeyFlag   = 0x04
 	serializeChildrenFlag     = 0x08
 	serializePathFragmentFlag = 0x10
+	childIndexListFlag        = 0x20 // only NodeFormatV2
+
+	smallFlagsV0Mask = terminalExistsFlag | takeTerminalFromValueFlag | takeTerminalFromKeyFlag |
+		serializeChildrenFlag | serializePathFragmentFlag
 )
 
 // cflags 256 flags, one for each child
@@ -124,8 +191,68 @@ func (fl cflags) hasFlag(i byte) bool {
 	return fl[i/8]&(0x1<<(i%8)) != 0
 }
 
-// Write serialized node data
+// useChildIndexList checks if list of child indices is shorter than cflags
+func useChildIndexList(numChildren int, arity PathArity) bool {
+	return numChildren > 0 && 1+numChildren < cflagsSize(arity)
+}
+
+// Write serialized node data in the CurrentNodeFormat
 func (n *NodeData) Write(w io.Writer, arity PathArity, isKeyCommitment bool, skipTerminal bool) error {
+	return n.WriteFormat(w, CurrentNodeFormat, arity, isKeyCommitment, skipTerminal)
+}
+
+// WriteFormat serializes node data in the specified format
+func (n *NodeData) WriteFormat(w io.Writer, format NodeFormat, arity PathArity, isKeyCommitment bool, skipTerminal bool) error {
+	codec, ok := nodeCodecs[format]
+	if !ok {
+		return fmt.Errorf("%s: %w", format, ErrUnknownNodeFormat)
+	}
+	if format != NodeFormatLegacy {
+		if err := WriteByte(w, nodeFormatMarker|byte(format)); err != nil {
+			return err
+		}
+	}
+	return codec.write(n, w, arity, isKeyCommitment, skipTerminal)
+}
+
+// Read deserializes node data in any of the known formats
+func (n *NodeData) Read(r io.Reader, model CommitmentModel, unpackedKey []byte, arity PathArity, valueStore KVReader) error {
+	_, _, _, err := n.readFormat(r, model, unpackedKey, arity, valueStore)
+	return err
+}
+
+// readFormat deserializes node data and returns its format and how the terminal was serialized
+func (n *NodeData) readFormat(r io.Reader, model CommitmentModel, unpackedKey []byte, arity PathArity, valueStore KVReader) (NodeFormat, bool, bool, error) {
+	first, err := ReadByte(r)
+	if err != nil {
+		return 0, false, false, err
+	}
+	format := NodeFormatLegacy
+	if first&nodeFormatMarker != 0 {
+		format = NodeFormat(first &^ nodeFormatMarker)
+	} else {
+		// the first byte of the legacy format is part of the node
+		r = io.MultiReader(bytes.NewReader([]byte{first}), r)
+	}
+	codec, ok := nodeCodecs[format]
+	if !ok || (format == NodeFormatLegacy && first&nodeFormatMarker != 0) {
+		return 0, false, false, fmt.Errorf("%s: %w", format, ErrUnknownNodeFormat)
+	}
+	isKeyCommitment, skipTerminal, err := codec.read(n, r, model, unpackedKey, arity, valueStore)
+	return format, isKeyCommitment, skipTerminal, err
+}
+
+// writeNodeV0 writes the node in the legacy format, which is also the body of the NodeFormatV1
+func writeNodeV0(n *NodeData, w io.Writer, arity PathArity, isKeyCommitment bool, skipTerminal bool) error {
+	return n.write(w, arity, isKeyCommitment, skipTerminal, false)
+}
+
+// writeNodeV2 writes the node in the compact format
+func writeNodeV2(n *NodeData, w io.Writer, arity PathArity, isKeyCommitment bool, skipTerminal bool) error {
+	return n.write(w, arity, isKeyCommitment, skipTerminal, true)
+}
+
+func (n *NodeData) write(w io.Writer, arity PathArity, isKeyCommitment bool, skipTerminal bool, compact bool) error {
 	var smallFlags byte
 	if n.Terminal != nil {
 		smallFlags |= terminalExistsFlag
@@ -150,11 +277,19 @@ func (n *NodeData) Write(w io.Writer, arity PathArity, isKeyCommitment bool, ski
 			return err
 		}
 	}
+	if compact && useChildIndexList(len(n.ChildCommitments), arity) {
+		smallFlags |= childIndexListFlag
+	}
 	if err = WriteByte(w, smallFlags); err != nil {
 		return err
 	}
 	if smallFlags&serializePathFragmentFlag != 0 {
-		if err = WriteBytes16(w, pathFragmentEncoded); err != nil {
+		if compact {
+			err = WriteBytesVarint(w, pathFragmentEncoded)
+		} else {
+			err = WriteBytes16(w, pathFragmentEncoded)
+		}
+		if err != nil {
 			return err
 		}
 	}
@@ -170,7 +305,18 @@ func (n *NodeData) Write(w io.Writer, arity PathArity, isKeyCommitment bool, ski
 		}
 	}
 	// write child commitments if any
-	if smallFlags&serializeChildrenFlag != 0 {
+	if smallFlags&childIndexListFlag != 0 {
+		indices := make([]byte, 0, 1+len(n.ChildCommitments))
+		indices = append(indices, byte(len(n.ChildCommitments)))
+		for i := 0; i < int(arity)+1; i++ {
+			if _, ok := n.ChildCommitments[uint8(i)]; ok {
+				indices = append(indices, uint8(i))
+			}
+		}
+		if _, err = w.Write(indices); err != nil {
+			return err
+		}
+	} else if smallFlags&serializeChildrenFlag != 0 {
 		childrenFlags := newCflags(arity)
 		// compress children childrenFlags 32 bytes, if any
 		for i := range n.ChildCommitments {
@@ -179,6 +325,8 @@ func (n *NodeData) Write(w io.Writer, arity PathArity, isKeyCommitment bool, ski
 		if _, err = w.Write(childrenFlags); err != nil {
 			return err
 		}
+	}
+	if smallFlags&serializeChildrenFlag != 0 {
 		for i := 0; i < int(arity)+1; i++ {
 			child, ok := n.ChildCommitments[uint8(i)]
 			if !ok {
@@ -192,15 +340,40 @@ func (n *NodeData) Write(w io.Writer, arity PathArity, isKeyCommitment bool, ski
 	return nil
 }
 
-// Read deserialize node data
-func (n *NodeData) Read(r io.Reader, model CommitmentModel, unpackedKey []byte, arity PathArity, valueStore KVReader) error {
-	var err error
-	var smallFlags byte
-	if smallFlags, err = ReadByte(r); err != nil {
-		return err
+// readNodeV0 reads the node in the legacy format
+func readNodeV0(n *NodeData, r io.Reader, model CommitmentModel, unpackedKey []byte, arity PathArity, valueStore KVReader) (bool, bool, error) {
+	smallFlags, err := ReadByte(r)
+	if err != nil {
+		return false, false, err
 	}
+	isKeyCommitment := smallFlags&takeTerminalFromKeyFlag != 0
+	skipTerminal := smallFlags&takeTerminalFromValueFlag != 0
+	return isKeyCommitment, skipTerminal, n.read(smallFlags, r, model, unpackedKey, arity, valueStore, false)
+}
+
+// readNodeV2 reads the node in the compact format
+func readNodeV2(n *NodeData, r io.Reader, model CommitmentModel, unpackedKey []byte, arity PathArity, valueStore KVReader) (bool, bool, error) {
+	smallFlags, err := ReadByte(r)
+	if err != nil {
+		return false, false, err
+	}
+	if smallFlags&^(smallFlagsV0Mask|childIndexListFlag) != 0 {
+		return false, false, errors.New("wrong flag")
+	}
+	isKeyCommitment := smallFlags&takeTerminalFromKeyFlag != 0
+	skipTerminal := smallFlags&takeTerminalFromValueFlag != 0
+	return isKeyCommitment, skipTerminal, n.read(smallFlags, r, model, unpackedKey, arity, valueStore, true)
+}
+
+func (n *NodeData) read(smallFlags byte, r io.Reader, model CommitmentModel, unpackedKey []byte, arity PathArity, valueStore KVReader, compact bool) error {
+	var err error
 	if smallFlags&serializePathFragmentFlag != 0 {
-		encoded, err := ReadBytes16(r)
+		var encoded []byte
+		if compact {
+			encoded, err = ReadBytesVarint(r)
+		} else {
+			encoded, err = ReadBytes16(r)
+		}
 		if err != nil {
 			return err
 		}
@@ -228,9 +401,12 @@ func (n *NodeData) Read(r io.Reader, model CommitmentModel, unpackedKey []byte,
 			if err != nil {
 				return err
 			}
-			value := valueStore.Get(key)
+			value, err := getErr(valueStore, key)
+			if err != nil {
+				return err
+			}
 			if value == nil {
-				return fmt.Errorf("can't find terminal value for key '%x'", key)
+				return fmt.Errorf("can't find terminal value for key '%x': %w", key, ErrMissingValue)
 			}
 			n.Terminal = model.CommitToData(value)
 		} else {
@@ -245,18 +421,58 @@ func (n *NodeData) Read(r io.Reader, model CommitmentModel, unpackedKey []byte,
 			return errors.New("wrong flag")
 		}
 	}
+	if smallFlags&childIndexListFlag != 0 {
+		if smallFlags&serializeChildrenFlag == 0 {
+			return errors.New("wrong flag")
+		}
+		var flags cflags
+		if flags, err = readChildIndexList(r, arity); err != nil {
+			return err
+		}
+		return n.readChildren(r, model, arity, flags)
+	}
 	if smallFlags&serializeChildrenFlag != 0 {
 		var flags cflags
 		if flags, err = readCflags(r, arity); err != nil {
 			return err
 		}
-		for i := 0; i < int(arity)+1; i++ {
-			ib := uint8(i)
-			if flags.hasFlag(ib) {
-				n.ChildCommitments[ib] = model.NewVectorCommitment()
-				if err = n.ChildCommitments[ib].Read(r); err != nil {
-					return err
-				}
+		return n.readChildren(r, model, arity, flags)
+	}
+	return nil
+}
+
+// readChildIndexList reads list of child indices and converts it to cflags.
+// The list must be in ascending order and must be shorter than cflags
+func readChildIndexList(r io.Reader, arity PathArity) (cflags, error) {
+	num, err := ReadByte(r)
+	if err != nil {
+		return nil, err
+	}
+	if !useChildIndexList(int(num), arity) {
+		return nil, fmt.Errorf("wrong number of children %d in the child index list", num)
+	}
+	indices := make([]byte, num)
+	if _, err = io.ReadFull(r, indices); err != nil {
+		return nil, err
+	}
+	ret := newCflags(arity)
+	for i, idx := range indices {
+		if !arity.IsChildIndex(int(idx)) || (i > 0 && idx <= indices[i-1]) {
+			return nil, fmt.Errorf("wrong child index %d in the child index list", idx)
+		}
+		ret.setFlag(idx)
+	}
+	return ret, nil
+}
+
+// readChildren reads child commitments present in flags
+func (n *NodeData) readChildren(r io.Reader, model CommitmentModel, arity PathArity, flags cflags) error {
+	for i := 0; i < int(arity)+1; i++ {
+		ib := uint8(i)
+		if flags.hasFlag(ib) {
+			n.ChildCommitments[ib] = model.NewVectorCommitment()
+			if err := n.ChildCommitments[ib].Read(r); err != nil {
+				return err
 			}
 		}
 	}
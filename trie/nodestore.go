@@ -2,8 +2,10 @@ package trie
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sort"
+	"sync"
 )
 
 // nodeStore direct access to trie
@@ -12,6 +14,10 @@ type nodeStore struct {
 	trieStore  KVReader
 	valueStore KVReader
 	arity      PathArity
+	// shared cache of decoded nodes, may be nil
+	cache *NodeCache
+	// committed trie the nodes are read from instead of the trie store, may be nil
+	base NodeStore
 }
 
 func newNodeStore(trieStore, valueStore KVReader, model CommitmentModel, arity PathArity) *nodeStore {
@@ -24,28 +30,99 @@ func newNodeStore(trieStore, valueStore KVReader, model CommitmentModel, arity P
 }
 
 func (sr *nodeStore) getNode(unpackedKey []byte) (*nodeReadOnly, bool) {
+	n, ok, err := sr.getNodeErr(unpackedKey)
+	if err != nil {
+		panicStoreError(err)
+	}
+	return n, ok
+}
+
+// getNodeErr returns ErrCorruptedNode if the node can't be decoded, ErrMissingValue if the terminal value
+// is not in the value store or the error of the underlying store.
+// Nodes returned from the cache are shared, they must not be modified
+func (sr *nodeStore) getNodeErr(unpackedKey []byte) (*nodeReadOnly, bool, error) {
+	if sr.base != nil {
+		n, ok := sr.readBaseNode(unpackedKey)
+		return n, ok, nil
+	}
+	if sr.cache == nil {
+		n, err := sr.readNodeErr(unpackedKey)
+		return n, n != nil, err
+	}
+	n, generation, ok := sr.cache.get(unpackedKey)
+	if ok {
+		return n, true, nil
+	}
+	n, err := sr.readNodeErr(unpackedKey)
+	if err != nil || n == nil {
+		return nil, false, err
+	}
+	sr.cache.put(unpackedKey, n, generation)
+	return n, true, nil
+}
+
+// readNodeErr reads and decodes the node from the store. Returns nil if the node is not in the store
+func (sr *nodeStore) readNodeErr(unpackedKey []byte) (*nodeReadOnly, error) {
 	// original (unpacked) unpackedKey is encoded to access the node in the kvstore
 	encodedKey, err := EncodeUnpackedBytes(unpackedKey, sr.arity)
-	Assert(err == nil, "trie::nodeStore::getNode assert 1: err: '%v' unpackedKey: '%s', arity: %s",
-		err, hex.EncodeToString(unpackedKey), sr.arity.String())
-
-	nodeBin := sr.trieStore.Get(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("trie::nodeStore::getNode: wrong key '%s', arity: %s: %v",
+			hex.EncodeToString(unpackedKey), sr.arity.String(), err)
+	}
+	nodeBin, err := getErr(sr.trieStore, encodedKey)
+	if err != nil {
+		return nil, err
+	}
 	if len(nodeBin) == 0 {
-		return nil, false
+		return nil, nil
 	}
 	n, err := nodeReadOnlyFromBytes(sr.m, nodeBin, unpackedKey, sr.arity, sr.valueStore)
-	Assert(err == nil, "trie::nodeStore::getNode assert 2: err: '%v' nodeBin: '%s', unpackedKey: '%s', arity: %s",
-		err, hex.EncodeToString(nodeBin), hex.EncodeToString(unpackedKey), sr.arity.String())
-	return n, true
+	if err != nil {
+		if errors.Is(err, ErrMissingValue) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("trie::nodeStore::getNode: err: '%v' nodeBin: '%s', unpackedKey: '%s', arity: %s: %w",
+			err, hex.EncodeToString(nodeBin), hex.EncodeToString(unpackedKey), sr.arity.String(), ErrCorruptedNode)
+	}
+	return n, nil
+}
+
+// readBaseNode returns a copy of the node of the base trie, so that the base is never modified
+func (sr *nodeStore) readBaseNode(unpackedKey []byte) (*nodeReadOnly, bool) {
+	n, ok := sr.base.GetNode(unpackedKey)
+	if !ok {
+		return nil, false
+	}
+	data := &NodeData{
+		PathFragment:     n.PathFragment(),
+		ChildCommitments: n.ChildCommitments(),
+		Terminal:         n.Terminal(),
+	}
+	return &nodeReadOnly{
+		n:   *data.Clone(),
+		key: unpackedKey,
+	}, true
 }
 
 type nodeStoreBuffered struct {
+	// protects the cache when nodes are fetched by parallel commit
+	mutex sync.Mutex
 	// persisted trie
 	reader nodeStore
 	// buffered part of the trie
 	nodeCache map[string]*bufferedNode
 	// cached deleted nodes
-	deleted                map[string]struct{}
+	deleted map[string]struct{}
+	// nodes deleted since the last commit
+	deletedSinceCommit map[string]struct{}
+	// nodes persisted to the store after the last clearCache, which are invalidated in the shared cache
+	persisted map[string]struct{}
+	// approximate memory taken by buffered nodes
+	bufferedBytes int
+	// scratch store of the memory-bounded mode, may be nil
+	spill *spillStore
+	// journal of mutations after savepoints
+	journal                *journal
 	arity                  PathArity
 	optimizeKeyCommitments bool
 }
@@ -55,6 +132,9 @@ func newNodeStoreBuffered(model CommitmentModel, trieStore, valueStore KVReader,
 		reader:                 *newNodeStore(trieStore, valueStore, model, arity),
 		nodeCache:              make(map[string]*bufferedNode),
 		deleted:                make(map[string]struct{}),
+		deletedSinceCommit:     make(map[string]struct{}),
+		persisted:              make(map[string]struct{}),
+		journal:                newJournal(0),
 		arity:                  arity,
 		optimizeKeyCommitments: optimizeKeyCommitments,
 	}
@@ -67,6 +147,10 @@ func (sc *nodeStoreBuffered) clone() *nodeStoreBuffered {
 		reader:                 sc.reader,
 		nodeCache:              make(map[string]*bufferedNode),
 		deleted:                make(map[string]struct{}),
+		deletedSinceCommit:     make(map[string]struct{}),
+		persisted:              make(map[string]struct{}),
+		bufferedBytes:          sc.bufferedBytes,
+		journal:                newJournal(0),
 		arity:                  sc.arity,
 		optimizeKeyCommitments: sc.optimizeKeyCommitments,
 	}
@@ -76,66 +160,120 @@ func (sc *nodeStoreBuffered) clone() *nodeStoreBuffered {
 	for k := range sc.deleted {
 		ret.deleted[k] = struct{}{}
 	}
+	for k := range sc.deletedSinceCommit {
+		ret.deletedSinceCommit[k] = struct{}{}
+	}
+	for k := range sc.persisted {
+		ret.persisted[k] = struct{}{}
+	}
 	return ret
 }
 
 // GetNode fetches node from the trie
 func (sc *nodeStoreBuffered) getNode(unpackedKey []byte) (*bufferedNode, bool) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
 	if _, isDeleted := sc.deleted[string(unpackedKey)]; isDeleted {
 		return nil, false
 	}
 	ret, ok := sc.nodeCache[string(unpackedKey)]
 	if ok {
+		sc.journalNode(ret)
 		return ret, true
 	}
 	n, ok := sc.reader.getNode(unpackedKey)
 	if !ok {
 		return nil, false
 	}
+	sc.journalKey(string(unpackedKey))
 	ret = newBufferedNode(unpackedKey)
-	ret.n = n.n
+	if sc.reader.cache != nil {
+		// the node is shared with the cache
+		ret.n = *n.n.Clone()
+	} else {
+		ret.n = n.n
+	}
 	ret.newTerminal = n.n.Terminal
 	sc.nodeCache[string(unpackedKey)] = ret
+	sc.bufferedBytes += estimateNodeSize(ret)
 	return ret, true
 }
 
 func (sc *nodeStoreBuffered) mustGetNode(key []byte) *bufferedNode {
 	ret, ok := sc.getNode(key)
-	Assert(ok, "trie::mustGetNode assert missing node: key: '%s'", hex.EncodeToString(key))
+	if !ok {
+		panic(fmt.Errorf("trie::mustGetNode: key: '%s': %w", hex.EncodeToString(key), ErrMissingNode))
+	}
 	return ret
 }
 
 // removeKey marks unpackedKey deleted
 func (sc *nodeStoreBuffered) removeKey(unpackedKey []byte) {
-	delete(sc.nodeCache, string(unpackedKey))
+	sc.journalKey(string(unpackedKey))
+	if n, ok := sc.nodeCache[string(unpackedKey)]; ok {
+		sc.bufferedBytes -= estimateNodeSize(n)
+		delete(sc.nodeCache, string(unpackedKey))
+	}
 	sc.deleted[string(unpackedKey)] = struct{}{}
+	sc.deletedSinceCommit[string(unpackedKey)] = struct{}{}
+}
+
+// takeDeletedSinceCommit returns keys deleted since the last commit and starts collecting them anew
+func (sc *nodeStoreBuffered) takeDeletedSinceCommit() map[string]struct{} {
+	ret := sc.deletedSinceCommit
+	sc.deletedSinceCommit = make(map[string]struct{})
+	return ret
 }
 
 // unDelete removes deletion mark, if any
 func (sc *nodeStoreBuffered) unDelete(key []byte) {
+	sc.journalKey(string(key))
 	delete(sc.deleted, string(key))
 }
 
 func (sc *nodeStoreBuffered) insertNewNode(n *bufferedNode) {
-	sc.unDelete(n.unpackedKey) // in case was marked deleted previously
+	sc.unDelete(n.unpackedKey) // in case was marked deleted previously, the key is journaled
 	_, already := sc.nodeCache[string(n.unpackedKey)]
 	Assert(!already, "trie::insertNewNode:: node already exists, key: '%s'",
 		hex.EncodeToString(n.unpackedKey))
 	sc.nodeCache[string(n.unpackedKey)] = n
+	sc.bufferedBytes += estimateNodeSize(n)
 }
 
 func (sc *nodeStoreBuffered) replaceNode(n *bufferedNode) {
-	_, already := sc.nodeCache[string(n.unpackedKey)]
+	sc.journalKey(string(n.unpackedKey))
+	prev, already := sc.nodeCache[string(n.unpackedKey)]
 	Assert(already, "trie::replaceNode:: missing key: '%s'", hex.EncodeToString(n.unpackedKey))
 	sc.nodeCache[string(n.unpackedKey)] = n
+	sc.bufferedBytes += estimateNodeSize(n) - estimateNodeSize(prev)
 }
 
-// PersistMutations persists the cache to the unpackedKey/value store
+// PersistMutations persists the cache to the unpackedKey/value store, including nodes spilled to the scratch store
 // Does not clear cache
 func (sc *nodeStoreBuffered) persistMutations(store KVWriter) int {
 	counter := 0
-	for _, v := range sc.nodeCache {
-		store.Set(mustEncodeUnpackedBytes(v.unpackedKey, sc.arity), v.Bytes(sc.reader.m, sc.arity, sc.optimizeKeyCommitments))
+	if sc.spill != nil {
+		counter += sc.spill.flush(store, sc.spilledNodeBytes)
+	}
+	counter += sc.persistBuffered(store, false)
+	sc.invalidatePersisted()
+	return counter
+}
+
+// persistBuffered writes buffered nodes to the store. If 'inlineTerminals' is true, terminals are always
+// serialized with the node, because values may be not in the value store yet
+func (sc *nodeStoreBuffered) persistBuffered(store KVWriter, inlineTerminals bool) int {
+	counter := 0
+	for k, v := range sc.nodeCache {
+		var nodeBin []byte
+		if inlineTerminals {
+			nodeBin = v.bytesWithTerminal(sc.arity)
+		} else {
+			nodeBin = v.Bytes(sc.reader.m, sc.arity, sc.optimizeKeyCommitments)
+		}
+		store.Set(mustEncodeUnpackedBytes(v.unpackedKey, sc.arity), nodeBin)
+		sc.markPersisted(k)
 		counter++
 	}
 	for k := range sc.deleted {
@@ -143,15 +281,51 @@ func (sc *nodeStoreBuffered) persistMutations(store KVWriter) int {
 		Assert(!inCache, "trie::persistMutations:: inconsistency. Non-existent key is marked for deletion: '%s'",
 			hex.EncodeToString([]byte(k)))
 		store.Set(mustEncodeUnpackedBytes([]byte(k), sc.arity), nil)
+		sc.markPersisted(k)
 		counter++
 	}
 	return counter
 }
 
-// ClearCache clears the node cache
+// markPersisted remembers the node written to the store. The node is always invalidated in the shared cache,
+// even if it is serialized to the same bytes: the terminal taken from the value store may have changed
+func (sc *nodeStoreBuffered) markPersisted(unpackedKey string) {
+	if sc.reader.cache == nil {
+		return
+	}
+	sc.persisted[unpackedKey] = struct{}{}
+}
+
+// invalidatePersisted removes persisted nodes from the shared cache of decoded nodes
+func (sc *nodeStoreBuffered) invalidatePersisted() {
+	if len(sc.persisted) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(sc.persisted))
+	for k := range sc.persisted {
+		keys = append(keys, k)
+	}
+	sc.reader.cache.invalidate(keys)
+}
+
+// ClearCache clears the node cache. Persisted nodes are invalidated in the shared cache once more,
+// because mutations may be written to the store after PersistMutations, e.g. by committing a batch
 func (sc *nodeStoreBuffered) clearCache() {
+	sc.invalidatePersisted()
+	sc.persisted = make(map[string]struct{})
+	sc.resetBuffers()
+	sc.discardSavepoints()
+	if sc.spill != nil {
+		sc.spill.clear()
+	}
+}
+
+// resetBuffers drops buffered nodes
+func (sc *nodeStoreBuffered) resetBuffers() {
 	sc.nodeCache = make(map[string]*bufferedNode)
 	sc.deleted = make(map[string]struct{})
+	sc.deletedSinceCommit = make(map[string]struct{})
+	sc.bufferedBytes = 0
 }
 
 func (sc *nodeStoreBuffered) dangerouslyDumpCacheToString() string {
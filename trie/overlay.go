@@ -0,0 +1,18 @@
+package trie
+
+// NewOverlay creates the Trie on top of the committed base trie. The overlay reads nodes from the base and keeps
+// its mutations privately, the base is never modified or copied. Mutations are committed with Commit
+// and written to a separate writer with PersistMutations: the writer receives nodes buffered by the overlay
+// and deletions, which, applied to the store of the base, give the committed state of the overlay.
+// ClearCache discards all mutations of the overlay.
+// If the base is the Trie, the overlay reads its state at the last Commit.
+// Several overlays can share one base concurrently as long as the base is not changed while overlays are used
+func NewOverlay(base NodeStore, optimizeKeyCommitments ...bool) *Trie {
+	if tr, ok := base.(*Trie); ok {
+		base = tr.Committed()
+	}
+	ret := New(base.Model(), nil, nil, optimizeKeyCommitments...)
+	ret.nodeStore.reader.base = base
+	ret.committed.Store(newCommittedState(&ret.nodeStore.reader))
+	return ret
+}
@@ -0,0 +1,469 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Snapshot is a self-contained binary image of the committed trie. It consists of:
+// - header: format version, ShortName of the commitment model, path arity, hash size, root commitment and
+//   the flag if values are included
+// - node and value records in the trie order: the node record is followed by the value record of its terminal, if
+//   values are included, and then by records of its children in ascending order of child indices
+// - end record with the number of node records and the number of value records
+// - blake2b-256 checksum of the header and all records
+// Nodes are serialized with all commitments, so the snapshot does not depend on optimizations of the source trie.
+// Nodes are bound to the root commitment in the header. Values are bound to terminal commitments, and
+// the snapshot with values must have the value record for each terminal
+
+const (
+	snapshotFormatVersion = byte(1)
+
+	snapshotRecordNode  = byte(0)
+	snapshotRecordValue = byte(1)
+	snapshotRecordEnd   = byte(2)
+)
+
+// SnapshotHeader is the header of the snapshot
+type SnapshotHeader struct {
+	ModelName string
+	PathArity PathArity
+	// HashSize is the size of the root commitment in bytes, 0 for the empty trie
+	HashSize byte
+	Root     []byte
+	// HasValues is true if the snapshot includes values of all terminals
+	HasValues bool
+}
+
+func (h *SnapshotHeader) Write(w io.Writer) error {
+	if err := WriteByte(w, snapshotFormatVersion); err != nil {
+		return err
+	}
+	if err := WriteBytes16(w, []byte(h.ModelName)); err != nil {
+		return err
+	}
+	if err := WriteByte(w, byte(h.PathArity)); err != nil {
+		return err
+	}
+	if err := WriteByte(w, h.HashSize); err != nil {
+		return err
+	}
+	if err := WriteBytes16(w, h.Root); err != nil {
+		return err
+	}
+	hasValues := byte(0)
+	if h.HasValues {
+		hasValues = 1
+	}
+	return WriteByte(w, hasValues)
+}
+
+func (h *SnapshotHeader) Read(r io.Reader) error {
+	sr := snapshotReader{r}
+	version, err := sr.readByte()
+	if err != nil {
+		return err
+	}
+	if version != snapshotFormatVersion {
+		return fmt.Errorf("unsupported format version %d: %w", version, ErrInvalidSnapshot)
+	}
+	name, err := sr.readBytes16()
+	if err != nil {
+		return err
+	}
+	h.ModelName = string(name)
+	arity, err := sr.readByte()
+	if err != nil {
+		return err
+	}
+	h.PathArity = PathArity(arity)
+	if h.HashSize, err = sr.readByte(); err != nil {
+		return err
+	}
+	if h.Root, err = sr.readBytes16(); err != nil {
+		return err
+	}
+	if len(h.Root) != int(h.HashSize) {
+		return fmt.Errorf("root size %d does not match hash size %d: %w", len(h.Root), h.HashSize, ErrInvalidSnapshot)
+	}
+	hasValues, err := sr.readByte()
+	if err != nil {
+		return err
+	}
+	if hasValues > 1 {
+		return fmt.Errorf("wrong values flag %d: %w", hasValues, ErrInvalidSnapshot)
+	}
+	h.HasValues = hasValues == 1
+	return nil
+}
+
+func (h *SnapshotHeader) String() string {
+	return fmt.Sprintf("SnapshotHeader( model: %s, arity: %s, hash size: %d, root: %s, values: %v )",
+		h.ModelName, h.PathArity, h.HashSize, hex.EncodeToString(h.Root), h.HasValues)
+}
+
+// newSnapshotChecksum returns the hash of the snapshot checksum
+func newSnapshotChecksum() hash.Hash {
+	ret, err := blake2b.New256(nil)
+	Assert(err == nil, "trie::newSnapshotChecksum: %v", err)
+	return ret
+}
+
+// valueStoreProvider is implemented by node stores with access to the values of terminals
+type valueStoreProvider interface {
+	valueReader() KVReader
+}
+
+func (tr *TrieReader) valueReader() KVReader {
+	return tr.reader.valueStore
+}
+
+func (tr *Trie) valueReader() KVReader {
+	return tr.nodeStore.reader.valueStore
+}
+
+// ExportSnapshot writes the snapshot of the committed trie. Values of terminals are included if the node store
+// is the Trie or the TrieReader with the value store. Then values of all terminals must be in the value store,
+// otherwise ErrMissingValue is returned. Errors of the stores are returned instead of panicking
+func ExportSnapshot(tr NodeStore, w io.Writer) (err error) {
+	defer catchError(&err)
+
+	var valueStore KVReader
+	if p, ok := tr.(valueStoreProvider); ok {
+		valueStore = p.valueReader()
+	}
+	header := &SnapshotHeader{
+		ModelName: tr.Model().ShortName(),
+		PathArity: tr.PathArity(),
+		HasValues: valueStore != nil,
+	}
+	if root := RootCommitment(tr); root != nil {
+		header.Root = root.Bytes()
+		header.HashSize = byte(len(header.Root))
+	}
+	checksum := newSnapshotChecksum()
+	cw := io.MultiWriter(w, checksum)
+	if err := header.Write(cw); err != nil {
+		return err
+	}
+	counters := &snapshotCounters{}
+	if err := exportNode(tr, valueStore, nil, cw, counters); err != nil {
+		return err
+	}
+	if err := WriteByte(cw, snapshotRecordEnd); err != nil {
+		return err
+	}
+	if err := WriteUint32(cw, counters.nodes); err != nil {
+		return err
+	}
+	if err := WriteUint32(cw, counters.values); err != nil {
+		return err
+	}
+	_, err = w.Write(checksum.Sum(nil))
+	return err
+}
+
+// snapshotCounters numbers of node and value records
+type snapshotCounters struct {
+	nodes  uint32
+	values uint32
+}
+
+func exportNode(tr NodeStore, valueStore KVReader, key []byte, w io.Writer, counters *snapshotCounters) error {
+	n, ok := tr.GetNode(key)
+	if !ok {
+		if len(key) == 0 {
+			// empty trie
+			return nil
+		}
+		return fmt.Errorf("trie::ExportSnapshot: key '%s': %w", hex.EncodeToString(key), ErrMissingNode)
+	}
+	nodeData := &NodeData{
+		PathFragment:     n.PathFragment(),
+		ChildCommitments: n.ChildCommitments(),
+		Terminal:         n.Terminal(),
+	}
+	var buf bytes.Buffer
+	if err := nodeData.Write(&buf, tr.PathArity(), false, false); err != nil {
+		return err
+	}
+	encodedKey, err := EncodeUnpackedBytes(key, tr.PathArity())
+	if err != nil {
+		return err
+	}
+	if err = writeSnapshotRecord(w, snapshotRecordNode, encodedKey, buf.Bytes()); err != nil {
+		return err
+	}
+	counters.nodes++
+	if n.Terminal() != nil && valueStore != nil {
+		packedKey, err := PackUnpackedBytes(Concat(key, n.PathFragment()), tr.PathArity())
+		if err != nil {
+			return err
+		}
+		value, err := getErr(valueStore, packedKey)
+		if err != nil {
+			return err
+		}
+		if len(value) == 0 {
+			return fmt.Errorf("trie::ExportSnapshot: key '%s': %w", hex.EncodeToString(packedKey), ErrMissingValue)
+		}
+		if err = writeSnapshotRecord(w, snapshotRecordValue, packedKey, value); err != nil {
+			return err
+		}
+		counters.values++
+	}
+	children := n.ChildCommitments()
+	indices := make([]int, 0, len(children))
+	for i := range children {
+		indices = append(indices, int(i))
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		if err = exportNode(tr, valueStore, childKey(n, byte(i)), w, counters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSnapshotRecord(w io.Writer, recordType byte, key, data []byte) error {
+	if err := WriteByte(w, recordType); err != nil {
+		return err
+	}
+	if err := WriteBytes16(w, key); err != nil {
+		return err
+	}
+	return WriteBytes32(w, data)
+}
+
+// ImportSnapshot reads the snapshot, checks it and writes nodes to the trie store and values to the value store.
+// Each node is checked against the commitment from its parent, the root is checked against the header.
+// Values are checked against terminal commitments. If the value store is provided, the snapshot of the non-empty
+// trie must include values, and the value of each terminal must be present. Returns the header of the snapshot.
+// Returns ErrTruncatedSnapshot if the snapshot is incomplete and ErrInvalidSnapshot if it is tampered with
+// or does not match the model. Stores may contain part of the snapshot if the error is returned
+func ImportSnapshot(model CommitmentModel, r io.Reader, trieStore, valueStore KVWriter) (*SnapshotHeader, error) {
+	checksum := newSnapshotChecksum()
+	cr := io.TeeReader(r, checksum)
+	header := &SnapshotHeader{}
+	if err := header.Read(cr); err != nil {
+		return nil, fmt.Errorf("trie::ImportSnapshot: wrong header: %w", err)
+	}
+	if header.ModelName != model.ShortName() || header.PathArity != model.PathArity() {
+		return nil, fmt.Errorf("trie::ImportSnapshot: snapshot of model '%s', %s can't be imported to model '%s', %s: %w",
+			header.ModelName, header.PathArity, model.ShortName(), model.PathArity(), ErrInvalidSnapshot)
+	}
+	if valueStore != nil && !header.HasValues && len(header.Root) > 0 {
+		return nil, fmt.Errorf("trie::ImportSnapshot: snapshot does not include values: %w", ErrInvalidSnapshot)
+	}
+	imp := &snapshotImporter{
+		m:          model,
+		r:          snapshotReader{cr},
+		rawReader:  snapshotReader{r},
+		checksum:   checksum,
+		hasValues:  header.HasValues,
+		trieStore:  trieStore,
+		valueStore: valueStore,
+		expected:   make(map[string][]byte),
+	}
+	if len(header.Root) > 0 {
+		imp.expected[""] = header.Root
+	}
+	if err := imp.run(); err != nil {
+		return nil, fmt.Errorf("trie::ImportSnapshot: %w", err)
+	}
+	return header, nil
+}
+
+type snapshotImporter struct {
+	m CommitmentModel
+	// r reads records and updates the checksum, rawReader reads the checksum itself
+	r          snapshotReader
+	rawReader  snapshotReader
+	checksum   hash.Hash
+	hasValues  bool
+	trieStore  KVWriter
+	valueStore KVWriter
+	// commitments of nodes expected to be read, by unpacked keys
+	expected map[string][]byte
+	// full unpacked key and terminal of the last node. The terminal is nil if its value was read
+	lastTerminalKey []byte
+	lastTerminal    TCommitment
+}
+
+func (imp *snapshotImporter) run() error {
+	counters := &snapshotCounters{}
+	for {
+		recordType, err := imp.r.readByte()
+		if err != nil {
+			return err
+		}
+		if recordType != snapshotRecordValue && imp.hasValues && imp.lastTerminal != nil {
+			return fmt.Errorf("missing value of the terminal of the key '%s': %w",
+				hex.EncodeToString(imp.lastTerminalKey), ErrInvalidSnapshot)
+		}
+		switch recordType {
+		case snapshotRecordNode:
+			err = imp.readNode()
+			counters.nodes++
+		case snapshotRecordValue:
+			err = imp.readValue()
+			counters.values++
+		case snapshotRecordEnd:
+			return imp.readEnd(counters)
+		default:
+			err = fmt.Errorf("wrong record type %d: %w", recordType, ErrInvalidSnapshot)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (imp *snapshotImporter) readNode() error {
+	encodedKey, err := imp.r.readBytes16()
+	if err != nil {
+		return err
+	}
+	data, err := imp.r.readBytes32()
+	if err != nil {
+		return err
+	}
+	arity := imp.m.PathArity()
+	unpackedKey, err := DecodeToUnpackedBytes(encodedKey, arity)
+	if err != nil {
+		return fmt.Errorf("wrong node key '%s': %v: %w", hex.EncodeToString(encodedKey), err, ErrInvalidSnapshot)
+	}
+	expected, ok := imp.expected[string(unpackedKey)]
+	if !ok {
+		return fmt.Errorf("unexpected node '%s': %w", hex.EncodeToString(encodedKey), ErrInvalidSnapshot)
+	}
+	delete(imp.expected, string(unpackedKey))
+	n, err := NodeDataFromBytes(imp.m, data, unpackedKey, arity, nil)
+	if err != nil {
+		return fmt.Errorf("wrong node '%s': %v: %w", hex.EncodeToString(encodedKey), err, ErrInvalidSnapshot)
+	}
+	if !bytes.Equal(imp.m.CalcNodeCommitment(n).Bytes(), expected) {
+		return fmt.Errorf("node '%s' does not match its commitment: %w", hex.EncodeToString(encodedKey), ErrInvalidSnapshot)
+	}
+	for i, c := range n.ChildCommitments {
+		imp.expected[string(Concat(unpackedKey, n.PathFragment, i))] = c.Bytes()
+	}
+	imp.lastTerminalKey = Concat(unpackedKey, n.PathFragment)
+	imp.lastTerminal = n.Terminal
+	imp.trieStore.Set(encodedKey, data)
+	return nil
+}
+
+func (imp *snapshotImporter) readValue() error {
+	key, err := imp.r.readBytes16()
+	if err != nil {
+		return err
+	}
+	value, err := imp.r.readBytes32()
+	if err != nil {
+		return err
+	}
+	arity := imp.m.PathArity()
+	if !imp.hasValues || imp.lastTerminal == nil || !bytes.Equal(UnpackBytes(key, arity), imp.lastTerminalKey) {
+		return fmt.Errorf("unexpected value of the key '%s': %w", hex.EncodeToString(key), ErrInvalidSnapshot)
+	}
+	if !imp.m.EqualCommitments(imp.m.CommitToData(value), imp.lastTerminal) {
+		// with the key commitment optimization the unpacked key is committed instead of the value equal to the key
+		if !bytes.Equal(key, value) || !imp.m.EqualCommitments(imp.m.CommitToData(imp.lastTerminalKey), imp.lastTerminal) {
+			return fmt.Errorf("value of the key '%s' does not match its commitment: %w", hex.EncodeToString(key), ErrInvalidSnapshot)
+		}
+	}
+	imp.lastTerminal = nil
+	if imp.valueStore != nil {
+		imp.valueStore.Set(key, value)
+	}
+	return nil
+}
+
+func (imp *snapshotImporter) readEnd(counters *snapshotCounters) error {
+	expected := &snapshotCounters{}
+	if err := imp.r.readUint32(&expected.nodes); err != nil {
+		return err
+	}
+	if err := imp.r.readUint32(&expected.values); err != nil {
+		return err
+	}
+	sum := imp.checksum.Sum(nil)
+	expectedSum, err := imp.rawReader.read(len(sum))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, expectedSum) {
+		return fmt.Errorf("wrong checksum: %w", ErrInvalidSnapshot)
+	}
+	if *expected != *counters {
+		return fmt.Errorf("expected %d node and %d value records, got %d and %d: %w",
+			expected.nodes, expected.values, counters.nodes, counters.values, ErrInvalidSnapshot)
+	}
+	if len(imp.expected) > 0 {
+		return fmt.Errorf("%d nodes are missing: %w", len(imp.expected), ErrInvalidSnapshot)
+	}
+	return nil
+}
+
+// snapshotReader reads exactly the requested number of bytes. Incomplete data is reported as ErrTruncatedSnapshot
+type snapshotReader struct {
+	r io.Reader
+}
+
+func (sr snapshotReader) read(size int) ([]byte, error) {
+	// the buffer grows with the data read, so the tampered size does not cause huge allocation
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, sr.r, int64(size)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrTruncatedSnapshot
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (sr snapshotReader) readByte() (byte, error) {
+	b, err := sr.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (sr snapshotReader) readUint32(pval *uint32) error {
+	b, err := sr.read(4)
+	if err != nil {
+		return err
+	}
+	*pval = MustUint32From4Bytes(b)
+	return nil
+}
+
+func (sr snapshotReader) readBytes16() ([]byte, error) {
+	b, err := sr.read(2)
+	if err != nil {
+		return nil, err
+	}
+	length, err := Uint16From2Bytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return sr.read(int(length))
+}
+
+func (sr snapshotReader) readBytes32() ([]byte, error) {
+	var length uint32
+	if err := sr.readUint32(&length); err != nil {
+		return nil, err
+	}
+	return sr.read(int(length))
+}
@@ -0,0 +1,150 @@
+package trie
+
+// Memory-bounded mode of the Trie.
+// When buffered nodes exceed the budget, the trie commits and flushes buffered nodes to the scratch store,
+// then continues with the empty node cache. The trie reads nodes through the scratch store layered
+// on top of the trie store. PersistMutations writes both spilled and buffered mutations to the store,
+// ClearCache clears the scratch store
+
+const (
+	// approximate memory taken by the buffered node without path fragment and commitments
+	bufferedNodeSizeEstimate = 256
+	// approximate memory taken by one child commitment in the node
+	childCommitmentSizeEstimate = 96
+)
+
+const (
+	spillEntryDeleted = byte(iota)
+	spillEntryNode
+)
+
+// spillStore is a KVReader of the trie nodes, which reads nodes spilled to the scratch store first
+type spillStore struct {
+	base    KVReader
+	scratch KVStore
+	// budget. 0 means no limit
+	maxNodes int
+	maxBytes int
+}
+
+// spillStore implements KVReaderWithErr, so that errors of the trie store are passed to *Err functions
+var _ KVReaderWithErr = &spillStore{}
+
+func (s *spillStore) Get(key []byte) []byte {
+	ret, err := s.GetErr(key)
+	if err != nil {
+		panicStoreError(err)
+	}
+	return ret
+}
+
+func (s *spillStore) Has(key []byte) bool {
+	return len(s.Get(key)) > 0
+}
+
+func (s *spillStore) GetErr(key []byte) ([]byte, error) {
+	if v := s.scratch.Get(key); len(v) > 0 {
+		if v[0] == spillEntryDeleted {
+			return nil, nil
+		}
+		return v[1:], nil
+	}
+	return getErr(s.base, key)
+}
+
+func (s *spillStore) HasErr(key []byte) (bool, error) {
+	v, err := s.GetErr(key)
+	return len(v) > 0, err
+}
+
+// Set writes node to the scratch store. Nil value means deleted node
+func (s *spillStore) Set(key, value []byte) {
+	if len(value) == 0 {
+		s.scratch.Set(key, []byte{spillEntryDeleted})
+		return
+	}
+	s.scratch.Set(key, Concat(spillEntryNode, value))
+}
+
+// flush writes spilled nodes to the store, re-serialized by 'serialize'. Returns number of written keys
+func (s *spillStore) flush(store KVWriter, serialize func(key, data []byte) []byte) int {
+	counter := 0
+	s.scratch.Iterate(func(k, v []byte) bool {
+		if v[0] == spillEntryDeleted {
+			store.Set(k, nil)
+		} else {
+			store.Set(k, serialize(k, v[1:]))
+		}
+		counter++
+		return true
+	})
+	return counter
+}
+
+func (s *spillStore) clear() {
+	keys := make([][]byte, 0)
+	s.scratch.Iterate(func(k, _ []byte) bool {
+		keys = append(keys, k)
+		return true
+	})
+	for _, k := range keys {
+		s.scratch.Set(k, nil)
+	}
+}
+
+func (s *spillStore) exceeded(numNodes, numBytes int) bool {
+	return (s.maxNodes > 0 && numNodes > s.maxNodes) || (s.maxBytes > 0 && numBytes > s.maxBytes)
+}
+
+// SetSpillStore enables memory-bounded mode of the trie. When the number of buffered nodes exceeds maxNodes
+// or their approximate size exceeds maxBytes, the trie is committed and buffered nodes are flushed to the scratch store.
+// The trie continues to work on top of the scratch store and the root is the same as in the unbounded mode.
+// Note, that each spill commits the trie, so Committed returns the state at the spill.
+// PersistMutations writes all mutations, including spilled ones, ClearCache clears the scratch store.
+// 0 means no limit. The scratch store must be empty and must not be used for anything else.
+// The mode is set before the trie is updated and it can't be used with the node cache, ContentAddressedStore or overlay
+func (tr *Trie) SetSpillStore(scratch KVStore, maxNodes, maxBytes int) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	sc := tr.nodeStore
+	Assert(sc.spill == nil, "trie::SetSpillStore: spill store is already set")
+	Assert(sc.reader.cache == nil, "trie::SetSpillStore: can't be used with the node cache")
+	Assert(sc.reader.base == nil, "trie::SetSpillStore: can't be used with the overlay")
+	Assert(len(sc.nodeCache) == 0 && len(sc.deleted) == 0, "trie::SetSpillStore: trie has buffered mutations")
+	sc.spill = &spillStore{
+		base:     sc.reader.trieStore,
+		scratch:  scratch,
+		maxNodes: maxNodes,
+		maxBytes: maxBytes,
+	}
+	sc.reader.trieStore = sc.spill
+}
+
+// spillIfNeeded commits the locked trie and flushes buffered nodes to the scratch store if the budget is exceeded
+func (tr *Trie) spillIfNeeded() {
+	sc := tr.nodeStore
+	if sc.spill == nil || !sc.spill.exceeded(len(sc.nodeCache), sc.bufferedBytes) {
+		return
+	}
+	tr.commit()
+	// values of spilled nodes are not in the value store yet
+	sc.persistBuffered(sc.spill, true)
+	sc.resetBuffers()
+	tr.committed.Store(newCommittedState(&sc.reader))
+}
+
+// spilledNodeBytes re-serializes the spilled node with inline terminal the same way as the buffered node is persisted
+func (sc *nodeStoreBuffered) spilledNodeBytes(key, data []byte) []byte {
+	unpackedKey, err := DecodeToUnpackedBytes(key, sc.arity)
+	Assert(err == nil, "trie::spilledNodeBytes: %v", err)
+	n, err := NodeDataFromBytes(sc.reader.m, data, unpackedKey, sc.arity, nil)
+	Assert(err == nil, "trie::spilledNodeBytes: %v", err)
+	return (&bufferedNode{n: *n, unpackedKey: unpackedKey}).Bytes(sc.reader.m, sc.arity, sc.optimizeKeyCommitments)
+}
+
+// estimateNodeSize returns approximate memory taken by the buffered node
+func estimateNodeSize(n *bufferedNode) int {
+	return bufferedNodeSizeEstimate + len(n.unpackedKey) + len(n.n.PathFragment) +
+		len(n.n.ChildCommitments)*childCommitmentSizeEstimate
+}
@@ -0,0 +1,32 @@
+package trie
+
+import "encoding/hex"
+
+// SubstateRoot returns commitment to the sub-state of all keys with the prefix, i.e. commitment of the topmost
+// node which covers the prefix. Returns nil if there are no keys with the prefix in the trie.
+// The empty prefix covers the whole state, so the sub-state root is the root commitment
+func SubstateRoot(tr NodeStore, prefix []byte) VCommitment {
+	n, ok := SubstateNode(tr, prefix)
+	if !ok {
+		return nil
+	}
+	return tr.Model().CalcNodeCommitment(&NodeData{
+		PathFragment:     n.PathFragment(),
+		ChildCommitments: n.ChildCommitments(),
+		Terminal:         n.Terminal(),
+	})
+}
+
+// SubstateNode returns the topmost node which covers the prefix. All keys with the prefix are in the subtree of the node
+// and all keys of the subtree have the prefix.
+// The prefix must be aligned to the path arity (see IsAlignedPrefix), otherwise keys with the prefix may be spread
+// among several sibling subtrees and there is no single node to commit to the sub-state
+func SubstateNode(tr NodeStore, prefix []byte) (Node, bool) {
+	Assert(IsAlignedPrefix(prefix, tr.PathArity()), "trie::SubstateNode: prefix '%s' is not aligned to %s",
+		hex.EncodeToString(prefix), tr.PathArity().String())
+	key, ok := findNodeWithPrefix(tr, UnpackBytes(prefix, tr.PathArity()))
+	if !ok {
+		return nil, false
+	}
+	return tr.GetNode(key)
+}
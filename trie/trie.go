@@ -8,13 +8,25 @@ package trie
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
 )
 
 // Trie is an updatable trie implemented on top of the unpackedKey/value store. It is virtualized and optimized by caching of the
-// trie update operation and keeping consistent trie in the cache
+// trie update operation and keeping consistent trie in the cache.
+// Trie is safe for concurrent use. The last committed state can be read through Committed while the trie is being mutated
 type Trie struct {
+	mutex     sync.Mutex
 	nodeStore *nodeStoreBuffered
+	// last committed state, *committedState
+	committed atomic.Value
+	// number of goroutines to commit subtrees in parallel. 0 or 1 means serial commit
+	commitWorkers int
 }
 
 // TrieReader direct read-only access to trie
@@ -57,14 +69,50 @@ func New(model CommitmentModel, trieStore, valueStore KVReader, optimizeKeyCommi
 	ret := &Trie{
 		nodeStore: newNodeStoreBuffered(model, trieStore, valueStore, model.PathArity(), o),
 	}
+	ret.committed.Store(newCommittedState(&ret.nodeStore.reader))
 	return ret
 }
 
-// Clone is a deep copy of the trie, including its buffered data
+// Clone is a deep copy of the trie, including its buffered data. Savepoints are not copied.
+// Mutations can be undone without copying the trie with Savepoint and RollbackTo
 func (tr *Trie) Clone() *Trie {
-	return &Trie{
-		nodeStore: tr.nodeStore.clone(),
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	Assert(tr.nodeStore.spill == nil, "trie::Clone: not supported in the memory-bounded mode")
+
+	ret := &Trie{
+		nodeStore:     tr.nodeStore.clone(),
+		commitWorkers: tr.commitWorkers,
 	}
+	ret.committed.Store(tr.committed.Load())
+	return ret
+}
+
+// SetCommitWorkers sets number of goroutines used by Commit to calculate commitments of subtrees in parallel.
+// 0 or 1 means serial commit. The commitment model must be safe for concurrent use
+func (tr *Trie) SetCommitWorkers(n int) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.commitWorkers = n
+}
+
+// SetNodeCache sets the cache of decoded nodes read from the trie store. The cache can be shared with
+// TrieReaders and other Tries on top of the same trie store. Nil means no cache.
+// It must be set before the trie is used concurrently
+func (tr *Trie) SetNodeCache(c *NodeCache) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	Assert(c == nil || tr.nodeStore.spill == nil, "trie::SetNodeCache: can't be used in the memory-bounded mode")
+	Assert(c == nil || tr.nodeStore.reader.base == nil, "trie::SetNodeCache: can't be used with the overlay")
+	tr.nodeStore.reader.cache = c
+}
+
+// Committed returns read-only access to the state of the trie at the last Commit. It does not see mutations
+// made after the last Commit and can be used concurrently with them.
+// The returned NodeStore is consistent until mutations of the next commit are persisted to the store,
+// so it should be taken again for each query
+func (tr *Trie) Committed() NodeStore {
+	return tr.committed.Load().(*committedState)
 }
 
 func (tr *Trie) Model() CommitmentModel {
@@ -77,9 +125,24 @@ func (tr *Trie) PathArity() PathArity {
 
 // GetNode fetches node from the trie
 func (tr *Trie) GetNode(unpackedKey []byte) (Node, bool) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
 	return tr.nodeStore.getNode(unpackedKey)
 }
 
+// GetNodeErr fetches node from the trie. Instead of panicking returns ErrCorruptedNode, ErrMissingValue
+// or the error of the underlying store
+func (tr *Trie) GetNodeErr(unpackedKey []byte) (ret Node, ok bool, err error) {
+	defer catchError(&err)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	n, ok := tr.nodeStore.getNode(unpackedKey)
+	if !ok {
+		return nil, false, nil
+	}
+	return n, true, nil
+}
+
 func (tr *Trie) Info() string {
 	return fmt.Sprintf("Trie( model dscr: '%s', optimize key commitments: %v)",
 		tr.nodeStore.reader.m.Description(), tr.nodeStore.optimizeKeyCommitments,
@@ -87,14 +150,36 @@ func (tr *Trie) Info() string {
 }
 
 // PersistMutations persists the cache to the unpackedKey/value store
-// Does not clear cache
+// Does not clear cache. Copies of committed nodes kept for Committed are released, after that the committed state
+// reads them from the trie store, so the store must be the trie store or be written to it before the next read.
+// The overlay keeps the copies, because its mutations are never written to the store of the base
 func (tr *Trie) PersistMutations(store KVWriter) int {
-	return tr.nodeStore.persistMutations(store)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	ret := tr.nodeStore.persistMutations(store)
+	if tr.nodeStore.reader.base == nil {
+		tr.committed.Store(newCommittedState(&tr.nodeStore.reader))
+	}
+	return ret
+}
+
+// PersistMutationsErr same as PersistMutations, but returns error if the writer panics with the error
+func (tr *Trie) PersistMutationsErr(store KVWriter) (ret int, err error) {
+	defer catchWriterError(&err)
+	return tr.PersistMutations(store), nil
 }
 
 // ClearCache clears the node cache
 func (tr *Trie) ClearCache() {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.clearCache()
+}
+
+// clearCache clears the cache of the locked trie. Mutations of the committed state are expected to be persisted
+func (tr *Trie) clearCache() {
 	tr.nodeStore.clearCache()
+	tr.committed.Store(newCommittedState(&tr.nodeStore.reader))
 }
 
 // newTerminalNode creates new node in the trie with specified PathFragment and Terminal commitment.
@@ -112,7 +197,81 @@ func (tr *Trie) newTerminalNode(unpackedKey, unpackedPathFragment []byte, newTer
 // Commit calculates a new root commitment value from the cache and commits all mutations in the cached TrieReader
 // It is a re-calculation of the trie. bufferedNode caches are updated accordingly.
 func (tr *Trie) Commit() {
-	tr.commitNode(nil, nil)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.commit()
+}
+
+func (tr *Trie) commit() {
+	tr.nodeStore.discardSavepoints()
+	var sem chan struct{}
+	if tr.commitWorkers > 1 {
+		// the calling goroutine is one of workers
+		sem = make(chan struct{}, tr.commitWorkers-1)
+	}
+	committed := &committedNodes{nodes: make(map[string]*nodeReadOnly)}
+	tr.commitNode(nil, nil, sem, committed)
+	prev := tr.committed.Load().(*committedState)
+	tr.committed.Store(prev.next(committed.nodes, tr.nodeStore.takeDeletedSinceCommit()))
+}
+
+// committedNodes collects copies of the nodes modified by the commit
+type committedNodes struct {
+	mutex sync.Mutex
+	nodes map[string]*nodeReadOnly
+}
+
+func (c *committedNodes) add(n *bufferedNode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.nodes[string(n.unpackedKey)] = &nodeReadOnly{
+		n:   *n.n.Clone(),
+		key: n.unpackedKey,
+	}
+}
+
+// CommitErr same as Commit, but returns error instead of panicking if the store is corrupted or not accessible.
+// The commit can't be rolled back: after the error commitments of the buffered nodes are not consistent
+// and the trie must be cleared with ClearCache, which discards all mutations not persisted with PersistMutations
+func (tr *Trie) CommitErr() (err error) {
+	defer catchError(&err)
+	tr.Commit()
+	return nil
+}
+
+// commitWorkers are goroutines committing subtrees in parallel. Panic of a worker is recovered
+// and raised again on the goroutine which waits for the workers, so that CommitErr can catch it
+type commitWorkers struct {
+	wg       sync.WaitGroup
+	mutex    sync.Mutex
+	panicked interface{}
+}
+
+// run starts the worker, which releases the slot taken in 'sem' when it finishes
+func (w *commitWorkers) run(sem chan struct{}, fun func()) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-sem }()
+		defer func() {
+			if r := recover(); r != nil {
+				w.mutex.Lock()
+				defer w.mutex.Unlock()
+				if w.panicked == nil {
+					w.panicked = r
+				}
+			}
+		}()
+		fun()
+	}()
+}
+
+// wait waits for all workers and panics with the first panic of workers, if any
+func (w *commitWorkers) wait() {
+	w.wg.Wait()
+	if w.panicked != nil {
+		panic(w.panicked)
+	}
 }
 
 // commitNode re-calculates node commitment and, recursively, its children commitments
@@ -120,8 +279,10 @@ func (tr *Trie) Commit() {
 // Return update to the upper commitment. nil mean upper commitment is not updated
 // It calls implementation-specific function UpdateNodeCommitment and passes parameter
 // calcDelta = true if node's commitment can be updated incrementally. The implementation
-// of UpdateNodeCommitment may use this parameter to optimize underlying cryptography
-func (tr *Trie) commitNode(key []byte, update *VCommitment) {
+// of UpdateNodeCommitment may use this parameter to optimize underlying cryptography.
+// If 'sem' is not nil, modified children are committed in parallel goroutines as long as 'sem' has free slots.
+// Copies of the committed nodes are collected in 'committed'
+func (tr *Trie) commitNode(key []byte, update *VCommitment, sem chan struct{}, committed *committedNodes) {
 	n, ok := tr.nodeStore.getNode(key)
 	if !ok {
 		if update != nil {
@@ -129,8 +290,7 @@ func (tr *Trie) commitNode(key []byte, update *VCommitment) {
 		}
 		return
 	}
-	isModified := n.pathChanged || len(n.modifiedChildren) > 0 || !tr.Model().EqualCommitments(n.newTerminal, n.n.Terminal)
-	if !isModified {
+	if !n.isModified(tr.Model()) {
 		return
 	}
 	mutate := NodeData{
@@ -138,11 +298,33 @@ func (tr *Trie) commitNode(key []byte, update *VCommitment) {
 		ChildCommitments: n.n.ChildCommitments,
 		Terminal:         n.n.Terminal,
 	}
-	childUpdates := make(map[byte]VCommitment)
+	childIndices := make([]byte, 0, len(n.modifiedChildren))
 	for childIndex := range n.modifiedChildren {
-		curCommitment := mutate.ChildCommitments[childIndex] // may be nil
-		tr.commitNode(childKey(n, childIndex), &curCommitment)
-		childUpdates[childIndex] = curCommitment
+		childIndices = append(childIndices, childIndex)
+	}
+	curCommitments := make([]VCommitment, len(childIndices))
+	var workers commitWorkers
+	// if the calling goroutine panics, workers must not outlive the commit
+	defer workers.wg.Wait()
+	for i, childIndex := range childIndices {
+		curCommitments[i] = mutate.ChildCommitments[childIndex] // may be nil
+		if sem != nil && i < len(childIndices)-1 {
+			select {
+			case sem <- struct{}{}:
+				i, childIndex := i, childIndex
+				workers.run(sem, func() {
+					tr.commitNode(childKey(n, childIndex), &curCommitments[i], sem, committed)
+				})
+				continue
+			default:
+			}
+		}
+		tr.commitNode(childKey(n, childIndex), &curCommitments[i], sem, committed)
+	}
+	workers.wait()
+	childUpdates := make(map[byte]VCommitment)
+	for i, childIndex := range childIndices {
+		childUpdates[childIndex] = curCommitments[i]
 	}
 
 	calcDelta := !n.pathChanged && update != nil && *update == nil
@@ -154,10 +336,18 @@ func (tr *Trie) commitNode(key []byte, update *VCommitment) {
 		n.modifiedChildren = make(map[byte]struct{})
 	}
 	n.pathChanged = false
+	committed.add(n)
 }
 
 // Update updates Trie with the unpackedKey/value. Reorganizes and re-calculates trie, keeps cache consistent
 func (tr *Trie) Update(key []byte, value []byte) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.update(key, value)
+	tr.spillIfNeeded()
+}
+
+func (tr *Trie) update(key []byte, value []byte) {
 	var c TCommitment
 	if tr.nodeStore.optimizeKeyCommitments && bytes.Equal(key, value) {
 		c = tr.nodeStore.reader.m.CommitToData(UnpackBytes(value, tr.nodeStore.arity))
@@ -166,12 +356,12 @@ func (tr *Trie) Update(key []byte, value []byte) {
 	}
 	if c == nil {
 		// nil value means deletion
-		tr.Delete(key)
+		tr.delete(key)
 		return
 	}
 	// find path in the trie corresponding to the unpackedKey
 	unpackedKey := UnpackBytes(key, tr.nodeStore.arity)
-	proof, lastCommonPrefix, ending := proofPath(tr, unpackedKey)
+	proof, lastCommonPrefix, ending := proofPath(tr.unlocked(), unpackedKey)
 	if len(proof) == 0 {
 		tr.newTerminalNode(nil, unpackedKey, c)
 		return
@@ -199,6 +389,19 @@ func (tr *Trie) Update(key []byte, value []byte) {
 	tr.markModifiedCommitmentsBackToRoot(proof)
 }
 
+// UpdateErr same as Update, but returns error instead of panicking if the store is corrupted or not accessible.
+// After the error, buffered mutations made by the call are rolled back and the trie can be used further
+func (tr *Trie) UpdateErr(key []byte, value []byte) (err error) {
+	defer catchError(&err)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.mutateOrRollback(func() {
+		tr.update(key, value)
+	})
+	tr.spillIfNeeded()
+	return nil
+}
+
 // InsertKeyCommitment inserts unpackedKey/value pair with equal unpackedKey and value.
 // Key must not be empty.
 // It leads to optimized serialization of trie nodes because terminal commitment is
@@ -256,8 +459,15 @@ func (tr *Trie) splitNode(fullKey, lastKey, commonPrefix []byte, newTerminal TCo
 
 // Delete deletes Key/value from the Trie, reorganizes the trie
 func (tr *Trie) Delete(key []byte) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.delete(key)
+	tr.spillIfNeeded()
+}
+
+func (tr *Trie) delete(key []byte) {
 	unpackedKey := UnpackBytes(key, tr.nodeStore.arity)
-	proof, _, ending := proofPath(tr, unpackedKey)
+	proof, _, ending := proofPath(tr.unlocked(), unpackedKey)
 	if len(proof) == 0 || ending != EndingTerminal {
 		return
 	}
@@ -267,6 +477,12 @@ func (tr *Trie) Delete(key []byte) {
 		return
 	}
 	lastNode.setNewTerminal(nil)
+	tr.reorgLastNode(proof, lastNode)
+}
+
+// reorgLastNode reorganizes the trie after the terminal or children of the last node of the proof path were deleted
+func (tr *Trie) reorgLastNode(proof [][]byte, lastNode *bufferedNode) {
+	lastKey := proof[len(proof)-1]
 	reorg, mergeChildIndex := tr.checkReorg(lastNode)
 	switch reorg {
 	case nodeReorgNOP:
@@ -290,6 +506,142 @@ func (tr *Trie) Delete(key []byte) {
 	}
 }
 
+// DeleteErr same as Delete, but returns error instead of panicking if the store is corrupted or not accessible.
+// After the error, buffered mutations made by the call are rolled back and the trie can be used further
+func (tr *Trie) DeleteErr(key []byte) (err error) {
+	defer catchError(&err)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.mutateOrRollback(func() {
+		tr.delete(key)
+	})
+	tr.spillIfNeeded()
+	return nil
+}
+
+// DeletePrefix deletes all keys with the prefix, i.e. the whole sub-state, in one operation.
+// The subtree of the node covering the prefix is detached and the parent is reorganized only once.
+// Returns deleted keys in the lexicographical order, so their values can be removed from the value store
+func (tr *Trie) DeletePrefix(prefix []byte) [][]byte {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	ret := tr.deletePrefix(prefix)
+	tr.spillIfNeeded()
+	return ret
+}
+
+func (tr *Trie) deletePrefix(prefix []byte) [][]byte {
+	unpackedPrefix, last, lastBits := unpackPrefix(prefix, tr.nodeStore.arity)
+	proof, lastCommonPrefix, ending := proofPath(tr.unlocked(), unpackedPrefix)
+	if len(proof) == 0 {
+		return nil
+	}
+	lastKey := proof[len(proof)-1]
+	switch {
+	case ending == EndingTerminal:
+		if lastBits > 0 {
+			// the node ends with whole elements of the prefix, the incomplete element selects its children
+			return tr.deleteChildrenWithLastBits(proof, last, lastBits)
+		}
+	case ending == EndingSplit && len(lastKey)+len(lastCommonPrefix) == len(unpackedPrefix):
+		// prefix ends in the middle of the path fragment
+		if lastBits > 0 {
+			lastNode := tr.nodeStore.mustGetNode(lastKey)
+			if !matchesLastBits(lastNode.PathFragment()[len(lastCommonPrefix)], last, lastBits, tr.nodeStore.arity) {
+				return nil
+			}
+		}
+	default:
+		// no keys with the prefix
+		return nil
+	}
+	ret := make([][]byte, 0)
+	tr.removeSubtree(lastKey, &ret)
+	if len(proof) < 2 {
+		// the root was removed, the trie is empty
+		return ret
+	}
+	tr.markModifiedCommitmentsBackToRoot(proof)
+	prevKey := proof[len(proof)-2]
+	prevNode := tr.nodeStore.mustGetNode(prevKey)
+	if reorg, mergeChildIndex := tr.checkReorg(prevNode); reorg == nodeReorgMerge {
+		tr.mergeNode(prevKey, prevNode, mergeChildIndex)
+	}
+	return ret
+}
+
+// deleteChildrenWithLastBits removes subtrees of the children of the last node of the proof path, which match
+// the incomplete last element of the unpacked prefix. Returns deleted keys in the lexicographical order
+func (tr *Trie) deleteChildrenWithLastBits(proof [][]byte, last byte, lastBits int) [][]byte {
+	lastNode := tr.nodeStore.mustGetNode(proof[len(proof)-1])
+	children := make(map[byte]struct{})
+	for i := range lastNode.ChildCommitments() {
+		children[i] = struct{}{}
+	}
+	for i := range lastNode.modifiedChildren {
+		children[i] = struct{}{}
+	}
+	indices := make([]int, 0, len(children))
+	for i := range children {
+		if matchesLastBits(i, last, lastBits, tr.nodeStore.arity) {
+			indices = append(indices, int(i))
+		}
+	}
+	sort.Ints(indices)
+	ret := make([][]byte, 0)
+	for _, i := range indices {
+		tr.removeSubtree(childKey(lastNode, byte(i)), &ret)
+		lastNode.markChildModified(byte(i))
+	}
+	if len(ret) == 0 {
+		return ret
+	}
+	tr.reorgLastNode(proof, lastNode)
+	return ret
+}
+
+// DeletePrefixErr same as DeletePrefix, but returns error instead of panicking if the store is corrupted or not accessible.
+// After the error, buffered mutations made by the call are rolled back and the trie can be used further
+func (tr *Trie) DeletePrefixErr(prefix []byte) (ret [][]byte, err error) {
+	defer catchError(&err)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.mutateOrRollback(func() {
+		ret = tr.deletePrefix(prefix)
+	})
+	tr.spillIfNeeded()
+	return ret, nil
+}
+
+// removeSubtree marks all nodes of the subtree deleted and collects packed keys of their terminals
+func (tr *Trie) removeSubtree(key []byte, deletedKeys *[][]byte) {
+	n, ok := tr.nodeStore.getNode(key)
+	if !ok {
+		return
+	}
+	if n.newTerminal != nil {
+		packedKey, err := PackUnpackedBytes(Concat(key, n.PathFragment()), tr.nodeStore.arity)
+		Assert(err == nil, "trie::removeSubtree: err: %v, key: '%s'", err, hex.EncodeToString(key))
+		*deletedKeys = append(*deletedKeys, packedKey)
+	}
+	children := make(map[byte]struct{})
+	for i := range n.ChildCommitments() {
+		children[i] = struct{}{}
+	}
+	for i := range n.modifiedChildren {
+		children[i] = struct{}{}
+	}
+	indices := make([]int, 0, len(children))
+	for i := range children {
+		indices = append(indices, int(i))
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		tr.removeSubtree(childKey(n, byte(i)), deletedKeys)
+	}
+	tr.nodeStore.removeKey(key)
+}
+
 // mergeNode merges nodes when it is possible, i.e. first node does not contain Terminal commitment and has only one
 // child commitment. In this case pathFragments can be merged in one resulting node
 func (tr *Trie) mergeNode(key []byte, n *bufferedNode, childIndex byte) {
@@ -377,46 +729,46 @@ func (tr *Trie) checkReorg(n *bufferedNode) (reorgStatus, byte) {
 
 // UpdateStr updates unpackedKey/value pair in the trie
 func (tr *Trie) UpdateStr(key interface{}, value interface{}) {
-	var k, v []byte
-	if key != nil {
-		switch kt := key.(type) {
-		case []byte:
-			k = kt
-		case string:
-			k = []byte(kt)
-		default:
-			panic("[]byte or string expected")
-		}
+	if err := tr.UpdateStrErr(key, value); err != nil {
+		panic(err)
 	}
-	if value != nil {
-		switch vt := value.(type) {
-		case []byte:
-			v = vt
-		case string:
-			v = []byte(vt)
-		default:
-			panic("[]byte or string expected")
-		}
+}
+
+// UpdateStrErr same as UpdateStr, but returns error on wrong types of parameters
+func (tr *Trie) UpdateStrErr(key interface{}, value interface{}) error {
+	k, err := bytesFromStrOrBytes(key)
+	if err != nil {
+		return err
+	}
+	v, err := bytesFromStrOrBytes(value)
+	if err != nil {
+		return err
 	}
-	tr.Update(k, v)
+	return tr.UpdateErr(k, v)
 }
 
 // DeleteStr removes node from trie
 func (tr *Trie) DeleteStr(key interface{}) {
-	var k []byte
-	if key != nil {
-		switch kt := key.(type) {
-		case []byte:
-			k = kt
-		case string:
-			k = []byte(kt)
-		default:
-			panic("[]byte or string expected")
-		}
+	k, err := bytesFromStrOrBytes(key)
+	if err != nil {
+		panic(err)
 	}
 	tr.Delete(k)
 }
 
+func bytesFromStrOrBytes(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch vt := v.(type) {
+	case []byte:
+		return vt, nil
+	case string:
+		return []byte(vt), nil
+	}
+	return nil, xerrors.New("[]byte or string expected")
+}
+
 func (tr *Trie) VectorCommitmentFromBytes(data []byte) (VCommitment, error) {
 	ret := tr.nodeStore.reader.m.NewVectorCommitment()
 	rdr := bytes.NewReader(data)
@@ -456,6 +808,7 @@ func (tr *Trie) Reconcile(store KVIterator) [][]byte {
 
 // UpdateAll mass-updates trie from the unpackedKey/value store.
 // To be used to build trie for arbitrary unpackedKey/value data sets
+// The initial import of the large data set is more efficient with BuildTrie
 func (tr *Trie) UpdateAll(store KVIterator) {
 	store.Iterate(func(k, v []byte) bool {
 		tr.Update(k, v)
@@ -464,9 +817,143 @@ func (tr *Trie) UpdateAll(store KVIterator) {
 }
 
 func (tr *Trie) DangerouslyDumpCacheToString() string {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
 	return tr.nodeStore.dangerouslyDumpCacheToString()
 }
 
+// trieUnlocked is NodeStore of the Trie used internally when the Trie is already locked
+type trieUnlocked Trie
+
+func (tr *Trie) unlocked() *trieUnlocked {
+	return (*trieUnlocked)(tr)
+}
+
+func (tr *trieUnlocked) GetNode(unpackedKey []byte) (Node, bool) {
+	return tr.nodeStore.getNode(unpackedKey)
+}
+
+func (tr *trieUnlocked) Model() CommitmentModel {
+	return tr.nodeStore.reader.m
+}
+
+func (tr *trieUnlocked) PathArity() PathArity {
+	return tr.nodeStore.arity
+}
+
+func (tr *trieUnlocked) Info() string {
+	return (*Trie)(tr).Info()
+}
+
+// committedState is an immutable state of the Trie at the moment of the commit. It is a chain of layers
+// with nodes modified by each commit on top of the persisted trie
+type committedState struct {
+	reader *nodeStore
+	prev   *committedState
+	depth  int
+	// nodes modified and deleted by the commit
+	nodes   map[string]*nodeReadOnly
+	deleted map[string]struct{}
+}
+
+// maxCommittedStateDepth is the maximum number of layers before they are merged into one
+const maxCommittedStateDepth = 16
+
+// committedState implements NodeStore
+var _ NodeStore = &committedState{}
+
+func newCommittedState(reader *nodeStore) *committedState {
+	return &committedState{
+		reader:  reader,
+		nodes:   make(map[string]*nodeReadOnly),
+		deleted: make(map[string]struct{}),
+	}
+}
+
+// next returns the committed state with the new layer on top of the current one.
+// Layers are merged while the layer below is not bigger than the new one, so each node is copied
+// O(log(number of nodes)) times. If there are still more than maxCommittedStateDepth layers, all of them are merged
+func (cs *committedState) next(nodes map[string]*nodeReadOnly, deleted map[string]struct{}) *committedState {
+	ret := &committedState{
+		reader:  cs.reader,
+		prev:    cs,
+		depth:   cs.depth + 1,
+		nodes:   nodes,
+		deleted: deleted,
+	}
+	for ret.prev != nil && ret.prev.size() <= ret.size() {
+		ret = mergeCommittedLayers(ret.prev, ret)
+	}
+	if ret.depth <= maxCommittedStateDepth {
+		return ret
+	}
+	layers := make([]*committedState, 0, ret.depth+1)
+	for l := ret; l != nil; l = l.prev {
+		layers = append(layers, l)
+	}
+	merged := newCommittedState(cs.reader)
+	for i := len(layers) - 1; i >= 0; i-- {
+		merged = mergeCommittedLayers(merged, layers[i])
+	}
+	return merged
+}
+
+// size is the number of modified and deleted nodes in the layer
+func (cs *committedState) size() int {
+	return len(cs.nodes) + len(cs.deleted)
+}
+
+// mergeCommittedLayers returns the new layer with nodes of both layers on top of the layers below 'lower'.
+// Layers are not changed, because they may be still read through older committed states
+func mergeCommittedLayers(lower, upper *committedState) *committedState {
+	ret := &committedState{
+		reader:  lower.reader,
+		prev:    lower.prev,
+		depth:   lower.depth,
+		nodes:   make(map[string]*nodeReadOnly, len(lower.nodes)+len(upper.nodes)),
+		deleted: make(map[string]struct{}, len(lower.deleted)+len(upper.deleted)),
+	}
+	for _, l := range []*committedState{lower, upper} {
+		for k := range l.deleted {
+			delete(ret.nodes, k)
+			ret.deleted[k] = struct{}{}
+		}
+		for k, n := range l.nodes {
+			delete(ret.deleted, k)
+			ret.nodes[k] = n
+		}
+	}
+	return ret
+}
+
+func (cs *committedState) GetNode(unpackedKey []byte) (Node, bool) {
+	for l := cs; l != nil; l = l.prev {
+		if n, ok := l.nodes[string(unpackedKey)]; ok {
+			return n, true
+		}
+		if _, isDeleted := l.deleted[string(unpackedKey)]; isDeleted {
+			return nil, false
+		}
+	}
+	n, ok := cs.reader.getNode(unpackedKey)
+	if !ok {
+		return nil, false
+	}
+	return n, true
+}
+
+func (cs *committedState) Model() CommitmentModel {
+	return cs.reader.m
+}
+
+func (cs *committedState) PathArity() PathArity {
+	return cs.reader.arity
+}
+
+func (cs *committedState) Info() string {
+	return fmt.Sprintf("Committed state ( model: %s, path arity: %s )", cs.reader.m.Description(), cs.reader.arity)
+}
+
 // TrieReader implements NodeStore
 var _ NodeStore = &TrieReader{}
 
@@ -476,10 +963,26 @@ func NewTrieReader(model CommitmentModel, trieStore, valueStore KVReader) *TrieR
 	}
 }
 
+// SetNodeCache sets the cache of decoded nodes. The cache can be shared with other readers and Tries
+// on top of the same trie store. Nil means no cache. It must be set before the reader is used concurrently
+func (tr *TrieReader) SetNodeCache(c *NodeCache) {
+	tr.reader.cache = c
+}
+
 func (tr *TrieReader) GetNode(unpackedKey []byte) (Node, bool) {
 	return tr.reader.getNode(unpackedKey)
 }
 
+// GetNodeErr fetches node from the trie. Instead of panicking returns ErrCorruptedNode, ErrMissingValue
+// or the error of the underlying store
+func (tr *TrieReader) GetNodeErr(unpackedKey []byte) (Node, bool, error) {
+	n, ok, err := tr.reader.getNodeErr(unpackedKey)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return n, true, nil
+}
+
 func (tr *TrieReader) Model() CommitmentModel {
 	return tr.reader.m
 }
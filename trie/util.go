@@ -253,6 +253,59 @@ func WriteBytes16(w io.Writer, data []byte) error {
 	return err
 }
 
+// ReadBytesVarint reads data prefixed with the uvarint length
+func ReadBytesVarint(r io.Reader) ([]byte, error) {
+	length, err := ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > math.MaxUint16 {
+		return nil, fmt.Errorf("ReadBytesVarint: too long data (%v)", length)
+	}
+	if length == 0 {
+		return []byte{}, nil
+	}
+	ret := make([]byte, length)
+	if _, err = io.ReadFull(r, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// WriteBytesVarint writes data prefixed with the uvarint length
+func WriteBytesVarint(w io.Writer, data []byte) error {
+	if len(data) > math.MaxUint16 {
+		panic(fmt.Sprintf("WriteBytesVarint: too long data (%v)", len(data)))
+	}
+	err := WriteUvarint(w, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	if len(data) != 0 {
+		_, err = w.Write(data)
+	}
+	return err
+}
+
+// byteReader adapts io.Reader to io.ByteReader
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	return ReadByte(b.r)
+}
+
+func ReadUvarint(r io.Reader) (uint64, error) {
+	return binary.ReadUvarint(byteReader{r})
+}
+
+func WriteUvarint(w io.Writer, val uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	_, err := w.Write(buf[:binary.PutUvarint(buf[:], val)])
+	return err
+}
+
 func ReadUint16(r io.Reader, pval *uint16) error {
 	var tmp2 [2]byte
 	_, err := r.Read(tmp2[:])
@@ -0,0 +1,290 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+)
+
+// VersionedStore keeps nodes of several committed states of the trie in one KVStore.
+// Each call to Persist records a new version. Nodes are never overwritten in place, instead each modification
+// of the node is stored under the node key and the version. The trie of any retained version can be read through
+// the KVReader returned by TrieStore.
+// Prune removes node records which are not reachable from the retained versions.
+//
+// Note, that historical states can be read only if terminal commitments are stored in the nodes, i.e. value
+// store is not used by the commitment model or is versioned by the user
+//
+// Layout of the underlying store:
+// - meta key -> first and last version
+// - root prefix + version -> root commitment of the version
+// - index prefix + node key -> list of versions of the node
+// - node prefix + node key + version -> node bytes
+// - changed prefix + version -> list of node keys changed in the version
+type VersionedStore struct {
+	store KVStore
+}
+
+const (
+	versionedMetaPrefix    = byte(0)
+	versionedRootPrefix    = byte(1)
+	versionedIndexPrefix   = byte(2)
+	versionedNodePrefix    = byte(3)
+	versionedChangedPrefix = byte(4)
+
+	versionedEntryDeleted = byte(0)
+	versionedEntryNode    = byte(1)
+)
+
+// versionEntry is an element of the version index of the node
+type versionEntry struct {
+	version uint32
+	flag    byte
+}
+
+func NewVersionedStore(store KVStore) *VersionedStore {
+	return &VersionedStore{store: store}
+}
+
+// Versions returns first and last retained versions. Returns false if nothing was persisted yet
+func (vs *VersionedStore) Versions() (uint32, uint32, bool) {
+	data := vs.store.Get([]byte{versionedMetaPrefix})
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+	Assert(len(data) == 8, "trie::VersionedStore: wrong meta record")
+	return MustUint32From4Bytes(data[:4]), MustUint32From4Bytes(data[4:]), true
+}
+
+func (vs *VersionedStore) setVersions(first, last uint32) {
+	vs.store.Set([]byte{versionedMetaPrefix}, Concat(Uint32To4Bytes(first), Uint32To4Bytes(last)))
+}
+
+// Root returns bytes of the root commitment of the version or nil if the trie of the version is empty.
+// Returns false if version is not retained
+func (vs *VersionedStore) Root(version uint32) ([]byte, bool) {
+	if !vs.isRetained(version) {
+		return nil, false
+	}
+	return vs.store.Get(Concat(versionedRootPrefix, Uint32To4Bytes(version))), true
+}
+
+func (vs *VersionedStore) isRetained(version uint32) bool {
+	first, last, ok := vs.Versions()
+	return ok && first <= version && version <= last
+}
+
+// Persist persists mutations of the committed trie as a new version. Returns the new version.
+// The trie must be created on top of LatestTrieStore. The cache of the trie is cleared
+func (vs *VersionedStore) Persist(tr *Trie) uint32 {
+	first, last, ok := vs.Versions()
+	version := uint32(0)
+	if ok {
+		version = last + 1
+	}
+	root := RootCommitment(tr)
+	mutations := make(map[string][]byte)
+	tr.PersistMutations(kvCollector(mutations))
+	tr.ClearCache()
+
+	keys := make([]string, 0, len(mutations))
+	for k := range mutations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var changed bytes.Buffer
+	for _, k := range keys {
+		entry := versionEntry{version: version, flag: versionedEntryNode}
+		if len(mutations[k]) == 0 {
+			entry.flag = versionedEntryDeleted
+		} else {
+			vs.store.Set(vs.nodeKey([]byte(k), version), mutations[k])
+		}
+		vs.appendEntry([]byte(k), entry)
+		_ = WriteBytes16(&changed, []byte(k))
+	}
+	if changed.Len() > 0 {
+		vs.store.Set(Concat(versionedChangedPrefix, Uint32To4Bytes(version)), changed.Bytes())
+	}
+	if root != nil {
+		vs.store.Set(Concat(versionedRootPrefix, Uint32To4Bytes(version)), root.Bytes())
+	}
+	vs.setVersions(first, version)
+	return version
+}
+
+// TrieStore returns KVReader of the trie nodes of the version. Returns false if version is not retained
+func (vs *VersionedStore) TrieStore(version uint32) (KVReader, bool) {
+	if !vs.isRetained(version) {
+		return nil, false
+	}
+	return &versionedReader{vs: vs, version: version}, true
+}
+
+// LatestTrieStore returns KVReader of the trie nodes which always reads the last version.
+// It is used to create the updatable Trie
+func (vs *VersionedStore) LatestTrieStore() KVReader {
+	return &versionedReader{vs: vs, latest: true}
+}
+
+// Prune removes all versions before the 'keepFrom' version together with the node records
+// not reachable from the retained versions. Returns number of removed node records
+func (vs *VersionedStore) Prune(keepFrom uint32) int {
+	first, last, ok := vs.Versions()
+	if !ok || keepFrom <= first {
+		return 0
+	}
+	Assert(keepFrom <= last, "trie::VersionedStore::Prune: can't prune the last version %d", last)
+
+	// collect nodes changed in pruned versions
+	toCheck := make(map[string]struct{})
+	for v := first; v < keepFrom; v++ {
+		for _, k := range vs.changedKeys(v) {
+			toCheck[string(k)] = struct{}{}
+		}
+		vs.store.Set(Concat(versionedChangedPrefix, Uint32To4Bytes(v)), nil)
+		vs.store.Set(Concat(versionedRootPrefix, Uint32To4Bytes(v)), nil)
+	}
+	keys := make([]string, 0, len(toCheck))
+	for k := range toCheck {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	counter := 0
+	// nodes which remain visible from 'keepFrom' in the record of the older version
+	carry := make([][]byte, 0)
+	for _, k := range keys {
+		entries := vs.index([]byte(k))
+		// entries before the one visible at the 'keepFrom' version are not reachable.
+		// The visible entry is not needed too if it marks deletion
+		remove := 0
+		for i, e := range entries {
+			if e.version > keepFrom {
+				break
+			}
+			remove = i
+			if e.flag == versionedEntryDeleted {
+				remove = i + 1
+			}
+		}
+		for _, e := range entries[:remove] {
+			if e.flag == versionedEntryNode {
+				vs.store.Set(vs.nodeKey([]byte(k), e.version), nil)
+				counter++
+			}
+		}
+		entries = entries[remove:]
+		vs.setIndex([]byte(k), entries)
+		if len(entries) > 0 && entries[0].version < keepFrom {
+			carry = append(carry, []byte(k))
+		}
+	}
+	if len(carry) > 0 {
+		// nodes in the older records must be checked again when 'keepFrom' version is pruned
+		var changed bytes.Buffer
+		already := make(map[string]struct{})
+		for _, k := range append(vs.changedKeys(keepFrom), carry...) {
+			if _, ok := already[string(k)]; !ok {
+				already[string(k)] = struct{}{}
+				_ = WriteBytes16(&changed, k)
+			}
+		}
+		vs.store.Set(Concat(versionedChangedPrefix, Uint32To4Bytes(keepFrom)), changed.Bytes())
+	}
+	vs.setVersions(keepFrom, last)
+	return counter
+}
+
+func (vs *VersionedStore) nodeKey(key []byte, version uint32) []byte {
+	return Concat(versionedNodePrefix, key, Uint32To4Bytes(version))
+}
+
+func (vs *VersionedStore) changedKeys(version uint32) [][]byte {
+	data := vs.store.Get(Concat(versionedChangedPrefix, Uint32To4Bytes(version)))
+	ret := make([][]byte, 0)
+	rdr := bytes.NewReader(data)
+	for rdr.Len() > 0 {
+		k, err := ReadBytes16(rdr)
+		Assert(err == nil, "trie::VersionedStore: wrong changed keys record of version %d: %v", version, err)
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+// index returns versions of the node sorted in ascending order
+func (vs *VersionedStore) index(key []byte) []versionEntry {
+	data := vs.store.Get(Concat(versionedIndexPrefix, key))
+	Assert(len(data)%5 == 0, "trie::VersionedStore: wrong index record of the key '%s'", hex.EncodeToString(key))
+	ret := make([]versionEntry, len(data)/5)
+	for i := range ret {
+		ret[i].version = MustUint32From4Bytes(data[i*5 : i*5+4])
+		ret[i].flag = data[i*5+4]
+	}
+	return ret
+}
+
+func (vs *VersionedStore) setIndex(key []byte, entries []versionEntry) {
+	if len(entries) == 0 {
+		vs.store.Set(Concat(versionedIndexPrefix, key), nil)
+		return
+	}
+	data := make([]byte, 0, len(entries)*5)
+	for _, e := range entries {
+		data = append(data, Uint32To4Bytes(e.version)...)
+		data = append(data, e.flag)
+	}
+	vs.store.Set(Concat(versionedIndexPrefix, key), data)
+}
+
+func (vs *VersionedStore) appendEntry(key []byte, e versionEntry) {
+	data := vs.store.Get(Concat(versionedIndexPrefix, key))
+	vs.store.Set(Concat(versionedIndexPrefix, key), Concat(data, Uint32To4Bytes(e.version), e.flag))
+}
+
+// get returns node bytes of the key visible at the version
+func (vs *VersionedStore) get(key []byte, version uint32) []byte {
+	entries := vs.index(key)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].version > version {
+			continue
+		}
+		if entries[i].flag == versionedEntryDeleted {
+			return nil
+		}
+		ret := vs.store.Get(vs.nodeKey(key, entries[i].version))
+		Assert(len(ret) > 0, "trie::VersionedStore: missing node record, key: '%s', version: %d",
+			hex.EncodeToString(key), entries[i].version)
+		return ret
+	}
+	return nil
+}
+
+// versionedReader is a KVReader of the trie nodes at the specific or the last version
+type versionedReader struct {
+	vs      *VersionedStore
+	version uint32
+	latest  bool
+}
+
+func (r *versionedReader) Get(key []byte) []byte {
+	if r.latest {
+		_, last, ok := r.vs.Versions()
+		if !ok {
+			return nil
+		}
+		return r.vs.get(key, last)
+	}
+	return r.vs.get(key, r.version)
+}
+
+func (r *versionedReader) Has(key []byte) bool {
+	return len(r.Get(key)) > 0
+}
+
+// kvCollector collects mutations in the map
+type kvCollector map[string][]byte
+
+func (c kvCollector) Set(key, value []byte) {
+	c[string(key)] = value
+}
@@ -0,0 +1,115 @@
+package trie_sync
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/models/trie_blake2b/trie_blake2b_verify"
+	"github.com/iotaledger/trie.go/trie"
+	"golang.org/x/xerrors"
+)
+
+// ErrInvalidChunk the chunk received from the server does not match the root or is not complete
+var ErrInvalidChunk = xerrors.New("invalid chunk")
+
+// Client downloads the state with the trusted root commitment from the server
+type Client struct {
+	model      *trie_blake2b.CommitmentModel
+	root       []byte
+	t          Transport
+	tr         *trie.Trie
+	trieStore  trie.KVStore
+	valueStore trie.KVStore
+}
+
+// NewClient creates the client which rebuilds the trie in the empty trie store and the value store.
+// The trie must be created with the same optimization of key commitments as the trie of the server
+func NewClient(model *trie_blake2b.CommitmentModel, root []byte, t Transport, trieStore, valueStore trie.KVStore, optimizeKeyCommitments ...bool) *Client {
+	return &Client{
+		model:      model,
+		root:       root,
+		t:          t,
+		tr:         trie.New(model, trieStore, valueStore, optimizeKeyCommitments...),
+		trieStore:  trieStore,
+		valueStore: valueStore,
+	}
+}
+
+// Sync downloads the state by chunks of maxKeys keys. Each chunk is checked against the root and persisted
+// in the stores before requesting the next one. Returns number of downloaded keys
+func (c *Client) Sync(maxKeys uint16) (int, error) {
+	start := []byte{}
+	counter := 0
+	for {
+		resp, err := c.request(&ChunkRequest{Start: start, MaxKeys: maxKeys})
+		if err != nil {
+			return counter, err
+		}
+		if err = c.verifyChunk(start, resp); err != nil {
+			return counter, err
+		}
+		for i := range resp.Keys {
+			c.tr.Update(resp.Keys[i], resp.Values[i])
+			c.valueStore.Set(resp.Keys[i], resp.Values[i])
+		}
+		c.tr.Commit()
+		c.tr.PersistMutations(c.trieStore)
+		c.tr.ClearCache()
+		counter += len(resp.Keys)
+		if resp.Last {
+			break
+		}
+		// the smallest key after the last key of the chunk
+		start = trie.Concat(resp.Keys[len(resp.Keys)-1], byte(0))
+	}
+	var rootBytes []byte
+	if root := trie.RootCommitment(c.tr); root != nil {
+		rootBytes = root.Bytes()
+	}
+	if !bytes.Equal(rootBytes, c.root) {
+		return counter, xerrors.New("trie_sync: root of the downloaded state does not match")
+	}
+	return counter, nil
+}
+
+func (c *Client) request(req *ChunkRequest) (*ChunkResponse, error) {
+	if err := c.t.Send(req.Bytes()); err != nil {
+		return nil, err
+	}
+	data, err := c.t.Receive()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ChunkResponseFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("trie_sync: wrong response: %v: %w", err, ErrInvalidChunk)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("trie_sync: server error: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+// verifyChunk checks the range proof of the chunk against the root. The range of the proof must be the range
+// of the chunk
+func (c *Client) verifyChunk(start []byte, resp *ChunkResponse) error {
+	p := resp.Proof
+	if p.PathArity != c.model.PathArity() || p.HashSize != c.model.HashSize() {
+		return fmt.Errorf("trie_sync: proof does not match the model: %w", ErrInvalidChunk)
+	}
+	if !resp.Last && len(resp.Keys) == 0 {
+		return fmt.Errorf("trie_sync: empty chunk is not the last: %w", ErrInvalidChunk)
+	}
+	var end []byte
+	if !resp.Last {
+		end = trie.UnpackBytes(resp.Keys[len(resp.Keys)-1], p.PathArity)
+	}
+	if !bytes.Equal(p.Start, trie.UnpackBytes(start, p.PathArity)) || !bytes.Equal(p.End, end) || (p.End == nil) != (end == nil) {
+		return fmt.Errorf("trie_sync: range of the proof does not match the chunk: %w", ErrInvalidChunk)
+	}
+	if err := trie_blake2b_verify.ValidateRange(p, c.root, resp.Keys, resp.Values); err != nil {
+		return fmt.Errorf("trie_sync: %v: %w", err, ErrInvalidChunk)
+	}
+	return nil
+}
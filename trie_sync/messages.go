@@ -0,0 +1,154 @@
+package trie_sync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+)
+
+// ChunkRequest requests keys starting from Start
+type ChunkRequest struct {
+	Start   []byte
+	MaxKeys uint16
+}
+
+// ChunkResponse contains sorted keys starting from Start of the request together with their values.
+// The chunk covers the range from Start to the last key of the chunk. If Last is true, the range is not bounded
+// from above, i.e. there are no more keys in the state.
+// Proof is the range proof of the chunk, so it proves that the chunk contains all keys of the range.
+// If the server can't serve the request, Error is not empty and other fields are not used
+type ChunkResponse struct {
+	Keys   [][]byte
+	Values [][]byte
+	Last   bool
+	Proof  *trie_blake2b.RangeProof
+	Error  string
+}
+
+func ChunkRequestFromBytes(data []byte) (*ChunkRequest, error) {
+	ret := &ChunkRequest{}
+	rdr := bytes.NewReader(data)
+	if err := ret.Read(rdr); err != nil {
+		return nil, err
+	}
+	if rdr.Len() != 0 {
+		return nil, trie.ErrNotAllBytesConsumed
+	}
+	return ret, nil
+}
+
+func (r *ChunkRequest) Bytes() []byte {
+	return trie.MustBytes(r)
+}
+
+func (r *ChunkRequest) Write(w io.Writer) error {
+	if err := trie.WriteBytes16(w, r.Start); err != nil {
+		return err
+	}
+	return trie.WriteUint16(w, r.MaxKeys)
+}
+
+func (r *ChunkRequest) Read(rdr io.Reader) error {
+	var err error
+	if r.Start, err = trie.ReadBytes16(rdr); err != nil {
+		return err
+	}
+	return trie.ReadUint16(rdr, &r.MaxKeys)
+}
+
+func (r *ChunkRequest) String() string {
+	return fmt.Sprintf("ChunkRequest( start: '%x', max keys: %d )", r.Start, r.MaxKeys)
+}
+
+func ChunkResponseFromBytes(data []byte) (*ChunkResponse, error) {
+	ret := &ChunkResponse{}
+	rdr := bytes.NewReader(data)
+	if err := ret.Read(rdr); err != nil {
+		return nil, err
+	}
+	if rdr.Len() != 0 {
+		return nil, trie.ErrNotAllBytesConsumed
+	}
+	return ret, nil
+}
+
+func (r *ChunkResponse) Bytes() []byte {
+	return trie.MustBytes(r)
+}
+
+func (r *ChunkResponse) Write(w io.Writer) error {
+	if err := trie.WriteBytes16(w, []byte(r.Error)); err != nil {
+		return err
+	}
+	if r.Error != "" {
+		return nil
+	}
+	if len(r.Keys) != len(r.Values) {
+		return errors.New("number of keys and values differ")
+	}
+	if err := trie.WriteUint16(w, uint16(len(r.Keys))); err != nil {
+		return err
+	}
+	for i := range r.Keys {
+		if err := trie.WriteBytes16(w, r.Keys[i]); err != nil {
+			return err
+		}
+		if err := trie.WriteBytes32(w, r.Values[i]); err != nil {
+			return err
+		}
+	}
+	var last byte
+	if r.Last {
+		last = 1
+	}
+	if err := trie.WriteByte(w, last); err != nil {
+		return err
+	}
+	return r.Proof.Write(w)
+}
+
+func (r *ChunkResponse) Read(rdr io.Reader) error {
+	errStr, err := trie.ReadBytes16(rdr)
+	if err != nil {
+		return err
+	}
+	r.Error = string(errStr)
+	if r.Error != "" {
+		return nil
+	}
+	var size uint16
+	if err = trie.ReadUint16(rdr, &size); err != nil {
+		return err
+	}
+	r.Keys = make([][]byte, size)
+	r.Values = make([][]byte, size)
+	for i := range r.Keys {
+		if r.Keys[i], err = trie.ReadBytes16(rdr); err != nil {
+			return err
+		}
+		if r.Values[i], err = trie.ReadBytes32(rdr); err != nil {
+			return err
+		}
+	}
+	last, err := trie.ReadByte(rdr)
+	if err != nil {
+		return err
+	}
+	if last > 1 {
+		return errors.New("wrong last flag")
+	}
+	r.Last = last == 1
+	r.Proof = &trie_blake2b.RangeProof{}
+	return r.Proof.Read(rdr)
+}
+
+func (r *ChunkResponse) String() string {
+	if r.Error != "" {
+		return fmt.Sprintf("ChunkResponse( error: '%s' )", r.Error)
+	}
+	return fmt.Sprintf("ChunkResponse( keys: %d, last: %v )", len(r.Keys), r.Last)
+}
@@ -0,0 +1,87 @@
+package trie_sync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/iotaledger/trie.go/models/trie_blake2b"
+	"github.com/iotaledger/trie.go/trie"
+)
+
+// Server serves chunks of the committed state
+type Server struct {
+	model      *trie_blake2b.CommitmentModel
+	tr         *trie.TrieReader
+	valueStore trie.KVReader
+}
+
+func NewServer(model *trie_blake2b.CommitmentModel, trieStore, valueStore trie.KVReader) *Server {
+	return &Server{
+		model:      model,
+		tr:         trie.NewTrieReader(model, trieStore, valueStore),
+		valueStore: valueStore,
+	}
+}
+
+// Chunk returns the chunk of keys starting from the Start of the request
+func (s *Server) Chunk(req *ChunkRequest) (*ChunkResponse, error) {
+	if req.MaxKeys == 0 {
+		return nil, errors.New("trie_sync: MaxKeys must be positive")
+	}
+	maxKeys := int(req.MaxKeys)
+	ret := &ChunkResponse{
+		Keys:   make([][]byte, 0, maxKeys),
+		Values: make([][]byte, 0, maxKeys),
+		Last:   true,
+	}
+	var err error
+	s.tr.IterateFrom(req.Start, func(k []byte, _ trie.TCommitment) bool {
+		if len(ret.Keys) == maxKeys {
+			ret.Last = false
+			return false
+		}
+		v := s.valueStore.Get(k)
+		if len(v) == 0 {
+			err = fmt.Errorf("trie_sync: value of the key '%x': %w", k, trie.ErrMissingValue)
+			return false
+		}
+		ret.Keys = append(ret.Keys, k)
+		ret.Values = append(ret.Values, v)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var end []byte
+	if !ret.Last {
+		// nil end means no bound, so the empty key must not be nil
+		end = append([]byte{}, ret.Keys[len(ret.Keys)-1]...)
+	}
+	ret.Proof = s.model.RangeProof(req.Start, end, s.tr)
+	return ret, nil
+}
+
+// Serve answers requests received from the transport until the transport is closed
+func (s *Server) Serve(t Transport) error {
+	for {
+		data, err := t.Receive()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		req, err := ChunkRequestFromBytes(data)
+		if err != nil {
+			return fmt.Errorf("trie_sync: wrong request: %v", err)
+		}
+		resp, err := s.Chunk(req)
+		if err != nil {
+			resp = &ChunkResponse{Error: err.Error()}
+		}
+		if err = t.Send(resp.Bytes()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package trie_sync implements chunked download of the state from a peer, verified against the trusted root commitment.
+// The server serves contiguous ranges of keys together with proofs. The client rebuilds the trie chunk by chunk
+// and checks each chunk against the root before applying it.
+// Only trie_blake2b commitment model is supported
+package trie_sync
+
+import (
+	"io"
+	"sync"
+)
+
+// Transport delivers messages between the client and the server in both directions
+type Transport interface {
+	// Send sends the message to the other side. The message must not be modified after sending
+	Send(msg []byte) error
+	// Receive waits for the message from the other side. Returns io.EOF if the transport is closed
+	Receive() ([]byte, error)
+	// Close closes the transport on both sides
+	Close() error
+}
+
+// pipe is an in-memory Transport with two connected ends
+type pipe struct {
+	done chan struct{}
+	once sync.Once
+}
+
+type pipeEnd struct {
+	p   *pipe
+	in  chan []byte
+	out chan []byte
+}
+
+// NewPipe returns two connected ends of the in-memory transport
+func NewPipe() (Transport, Transport) {
+	p := &pipe{done: make(chan struct{})}
+	ab := make(chan []byte)
+	ba := make(chan []byte)
+	return &pipeEnd{p: p, in: ba, out: ab}, &pipeEnd{p: p, in: ab, out: ba}
+}
+
+func (e *pipeEnd) Send(msg []byte) error {
+	select {
+	case e.out <- msg:
+		return nil
+	case <-e.p.done:
+		return io.ErrClosedPipe
+	}
+}
+
+func (e *pipeEnd) Receive() ([]byte, error) {
+	select {
+	case msg := <-e.in:
+		return msg, nil
+	case <-e.p.done:
+		return nil, io.EOF
+	}
+}
+
+func (e *pipeEnd) Close() error {
+	e.p.once.Do(func() {
+		close(e.p.done)
+	})
+	return nil
+}